@@ -0,0 +1,92 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// blobStore keeps image bytes outside of the book under
+// rbook -blobs, each stored once, named by its checksum:
+//
+//	my.rbook.blobs/Ab/AbCd...
+//
+// The same plot drawn twice costs nothing the second time,
+// and the book itself stays small enough to diff and replay.
+type blobStore struct {
+	dir string
+}
+
+func newBlobStore(bookpath string) *blobStore {
+	return &blobStore{dir: bookpath + ".blobs"}
+}
+
+// pathFor returns where the blob with checksum hash lives.
+// The first two characters fan out the directory.
+func (s *blobStore) pathFor(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash)
+}
+
+// put stores by, if not already present, and returns its checksum.
+func (s *blobStore) put(by []byte) (hash string, err error) {
+	hash = checksumOf(by)
+	path := s.pathFor(hash)
+	if FileExists(path) {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return "", fmt.Errorf("blobStore.put() error on MkdirAll: '%s'", err)
+	}
+	// write aside then rename, so a crash never leaves
+	// a partial blob under a good name.
+	tmp := fmt.Sprintf("%v.tmp.%v", path, os.Getpid())
+	err = ioutil.WriteFile(tmp, by, 0660)
+	if err != nil {
+		return "", fmt.Errorf("blobStore.put() error writing '%v': '%s'", tmp, err)
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("blobStore.put() error on rename to '%v': '%s'", path, err)
+	}
+	return
+}
+
+// get returns the bytes stored under hash, checking that
+// they still have that checksum.
+func (s *blobStore) get(hash string) (by []byte, err error) {
+	if len(hash) < 2 {
+		return nil, fmt.Errorf("blobStore.get() error: bad hash '%v'", hash)
+	}
+	path := s.pathFor(hash)
+	by, err = ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("blobStore.get() error: '%s'", err)
+	}
+	if checksumOf(by) != hash {
+		return nil, fmt.Errorf("blobStore.get() error: blob '%v' is corrupt; its checksum no longer matches its name", path)
+	}
+	return
+}
+
+// imageBytes returns the png bytes of the Image element e,
+// whether held inline in e.ImageBy, left in the book file
+// by ReadBookLazy, or in the blob store. Caller holds h.mut.
+func (h *HashRBook) imageBytes(e *HashRElem) ([]byte, error) {
+	if e.lazyImage {
+		by, err := h.loadLazyImage(e)
+		if err != nil || len(by) > 0 || e.ImageHash == "" {
+			return by, err
+		}
+		// dropped for its vector renderings; the png is a blob.
+		return h.blobs.get(e.ImageHash)
+	}
+	if len(e.ImageBy) > 0 || e.ImageHash == "" {
+		return e.ImageBy, nil
+	}
+	return h.blobs.get(e.ImageHash)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestBlobStore(t *testing.T) {
+
+	cv.Convey("under rbook -blobs an Image element holds only ImageHash; the bytes are stored once in <book>.blobs/ and found again after the book is re-read", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-blobs")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "blobs.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		h, appendFD, err := ReadBook("tester", "testhost", path)
+		panicOn(err)
+
+		png := []byte("\x89PNG pretend plot")
+		for i := 0; i < 2; i++ {
+			e := &HashRElem{
+				Typ:       Image,
+				Tm:        time.Now(),
+				Seqno:     i,
+				ImagePath: filepath.Join(dir, "plot", string(rune('a'+i))+".png"),
+			}
+			e.ImageHash, err = h.blobs.put(png)
+			panicOn(err)
+			h.mut.Lock()
+			h.appendElem(e)
+			h.mut.Unlock()
+			by, err := e.SaveToSlice()
+			panicOn(err)
+			_, err = appendFD.Write(by)
+			panicOn(err)
+		}
+		appendFD.Close()
+
+		// the same plot twice is stored once.
+		blobs, err := filepath.Glob(filepath.Join(path+".blobs", "*", "*"))
+		panicOn(err)
+		cv.So(len(blobs), cv.ShouldEqual, 1)
+
+		h2, appendFD2, err := ReadBook("tester", "testhost", path)
+		panicOn(err)
+		appendFD2.Close()
+		e := h2.path2image[filepath.Join(dir, "plot", "b.png")]
+		cv.So(e, cv.ShouldNotBeNil)
+		cv.So(len(e.ImageBy), cv.ShouldEqual, 0)
+		by, err := h2.imageBytes(e)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(string(by), cv.ShouldEqual, string(png))
+
+		cfg := &RbookConfig{}
+		cv.So(cfg.verifyBook(path), cv.ShouldBeTrue)
+
+		// a damaged blob is noticed, both when served and by -verify.
+		panicOn(ioutil.WriteFile(blobs[0], []byte("not the plot"), 0660))
+		_, err = h2.imageBytes(e)
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(cfg.verifyBook(path), cv.ShouldBeFalse)
+	})
+}
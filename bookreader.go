@@ -0,0 +1,73 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"fmt"
+	"os"
+)
+
+// BookReader reads a book one element at a time, so
+// tools like -dump and the exporters can stream a big
+// book without holding all of it, images and all, in memory.
+//
+//	r, err := OpenBookReader(path)
+//	...
+//	defer r.Close()
+//	for {
+//		e, err := r.Next()
+//		if err == io.EOF {
+//			break
+//		}
+//		...
+//	}
+//
+// BookReader never writes to the book; a torn last
+// frame is reported by Next as TruncatedFrame.
+type BookReader struct {
+	// Book is the header; its elems are not filled in.
+	Book *HashRBook
+
+	fd     *os.File
+	scan   *bookFrameScanner
+	offset int64
+}
+
+// OpenBookReader opens the book at path and reads its header.
+func OpenBookReader(path string) (r *BookReader, err error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	scan := newBookFrameScanner(fd)
+	book, err := LoadBook(scan.mpr)
+	if err != nil {
+		fd.Close()
+		return nil, fmt.Errorf("OpenBookReader() bad header frame at offset 0 of '%v': '%s'", path, err)
+	}
+	book.blobs = newBlobStore(path)
+	book.diskPath = absPath(path)
+	return &BookReader{
+		Book: book,
+		fd:   fd,
+		scan: scan,
+	}, nil
+}
+
+// Next returns the next element in the book, or io.EOF
+// after the last one.
+func (r *BookReader) Next() (e *HashRElem, err error) {
+	r.offset = r.scan.offset()
+	return LoadElem(r.scan.mpr)
+}
+
+// Offset returns where in the book file the frame most
+// recently asked for by Next begins.
+func (r *BookReader) Offset() int64 {
+	return r.offset
+}
+
+// Close closes the book file.
+func (r *BookReader) Close() error {
+	return r.fd.Close()
+}
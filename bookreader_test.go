@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestBookReader(t *testing.T) {
+
+	cv.Convey("BookReader.Next should stream the elements of a book in order, at the offsets the index has, and -dump should stream the same script that dumpToScript writes", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-reader")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "reader.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		writeTestBook(path, 4)
+		h, appendFD, err := ReadBook("tester", "testhost", path)
+		panicOn(err)
+		appendFD.Close()
+
+		r, err := OpenBookReader(path)
+		panicOn(err)
+		cv.So(r.Book.BookID, cv.ShouldEqual, h.BookID)
+		n := 0
+		for {
+			e, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			panicOn(err)
+			cv.So(e.Seqno, cv.ShouldEqual, n)
+			cv.So(r.Offset(), cv.ShouldEqual, h.index[n].Offset)
+			n++
+		}
+		r.Close()
+		cv.So(n, cv.ShouldEqual, 4)
+
+		cfg := &RbookConfig{}
+		streamed := filepath.Join(dir, "streamed.rsh")
+		fd, err := os.Create(streamed)
+		panicOn(err)
+		cv.So(cfg.dumpBook(fd, path), cv.ShouldBeTrue)
+		fd.Close()
+
+		loaded := filepath.Join(dir, "loaded.rsh")
+		fd, err = os.Create(loaded)
+		panicOn(err)
+		writeScriptHeader(fd, path, h)
+		cfg.dumpToScript(fd, h)
+		fd.Close()
+		cv.So(string(mustReadFile(streamed)), cv.ShouldEqual, string(mustReadFile(loaded)))
+		cv.So(bytes.Count(mustReadFile(streamed), []byte("x <- 1")), cv.ShouldEqual, 4)
+	})
+
+	cv.Convey("ReadBookLazy should not keep image bytes in memory, but read them back from the book on demand, and keep them through a full re-save", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-lazy")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "lazy.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		h, appendFD, err := ReadBook("tester", "testhost", path)
+		panicOn(err)
+		for i := 0; i < 3; i++ {
+			e := &HashRElem{
+				Typ:       Image,
+				Tm:        time.Now(),
+				Seqno:     i,
+				ImagePath: filepath.Join(dir, "plot", string(rune('a'+i))+".png"),
+				ImageBy:   []byte("pretend png " + string(rune('a'+i))),
+			}
+			h.mut.Lock()
+			h.appendElem(e)
+			h.mut.Unlock()
+			by, err := e.SaveToSlice()
+			panicOn(err)
+			_, err = appendFD.Write(by)
+			panicOn(err)
+		}
+		appendFD.Close()
+
+		lz, appendFD, err := ReadBookLazy("tester", "testhost", path)
+		panicOn(err)
+		defer appendFD.Close()
+		for _, e := range lz.elems {
+			cv.So(len(e.ImageBy), cv.ShouldEqual, 0)
+		}
+		e := lz.path2image[filepath.Join(dir, "plot", "b.png")]
+		lz.mut.Lock()
+		by, err := lz.imageBytes(e)
+		lz.mut.Unlock()
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(string(by), cv.ShouldEqual, "pretend png b")
+
+		// as when git deletes the book out from under us, just as
+		// archiveElem has added a plot that did not reach the disk.
+		d := &HashRElem{
+			Typ:       Image,
+			Tm:        time.Now(),
+			Seqno:     3,
+			ImagePath: filepath.Join(dir, "plot", "d.png"),
+			ImageBy:   []byte("pretend png d"),
+		}
+		lz.mut.Lock()
+		lz.appendElem(d)
+		lz.mut.Unlock()
+		panicOn(os.Remove(path))
+		appendFD2 := lz.DeletePathAndReSaveFullBook(path)
+		appendFD2.Close()
+
+		// d is in the index, on disk too, and its image only in the book.
+		lz.mut.Lock()
+		cv.So(len(lz.index), cv.ShouldEqual, 4)
+		cv.So(lz.index[3].Seqno, cv.ShouldEqual, 3)
+		cv.So(len(d.ImageBy), cv.ShouldEqual, 0)
+		by, err = lz.imageBytes(d)
+		lz.mut.Unlock()
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(string(by), cv.ShouldEqual, "pretend png d")
+		ents, err := readIndexFile(path, lz.BookID)
+		panicOn(err)
+		cv.So(sameIndex(ents, lz.index), cv.ShouldBeTrue)
+
+		cfg := &RbookConfig{}
+		cv.So(cfg.verifyBook(path), cv.ShouldBeTrue)
+		lz.mut.Lock()
+		by, err = lz.imageBytes(lz.elems[2])
+		lz.mut.Unlock()
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(string(by), cv.ShouldEqual, "pretend png c")
+	})
+}
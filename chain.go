@@ -0,0 +1,78 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"fmt"
+	"time"
+)
+
+// chainAnchor is where the hash chain starts: the
+// PrevHash of the very first element of the book.
+func (h *HashRBook) chainAnchor() string {
+	return checksumOf([]byte("rbook:" + h.BookID + ":" + h.CreateTm.UTC().Format(RFC3339NanoNumericTZ0pad)))
+}
+
+// chainLink returns the chain head after e, given head, the
+// chain head before e. Elements written before we had the
+// chain have no PrevHash; we fold them into the head, so
+// the head still covers all of history. Only in a book
+// older than chainFormatVersion, as legacy says, is that
+// allowed; elsewhere a missing PrevHash or Checksum is an
+// error, as a tamperer may have cleared it.
+//
+// With trust, we take a non-empty e.Checksum as given rather
+// than recomputing it. On a broken link we return an error,
+// but also the head as it would be after e, so the caller
+// may keep going if it likes.
+func chainLink(head string, e *HashRElem, trust, legacy bool) (next string, err error) {
+	if !legacy && (e.PrevHash == "" || e.Checksum == "") {
+		return "", fmt.Errorf("hash chain broken at seqno %v: it has no PrevHash or no Checksum, which every element of a book of format version %v or later has", e.Seqno, chainFormatVersion)
+	}
+	sum := e.Checksum
+	if !trust || sum == "" {
+		sum, err = e.ComputeChecksum()
+		if err != nil {
+			return "", err
+		}
+	}
+	if e.PrevHash == "" {
+		// from before we had the chain.
+		return checksumOf([]byte(head + sum)), nil
+	}
+	if e.PrevHash != head {
+		return sum, fmt.Errorf("hash chain broken at seqno %v: its PrevHash does not match the element before it", e.Seqno)
+	}
+	return sum, nil
+}
+
+// attestBook implements rbook -attest: verify the book at path,
+// then print the head of its hash chain and when the head was
+// written. Publishing (or notarizing) that line fixes the
+// history up to that point: any later change to it will
+// give a different head.
+func (c *RbookConfig) attestBook(path string) (ok bool) {
+	res, ok := verifyFrames(path)
+	if !ok {
+		fmt.Printf("rbook -attest: not attesting to a damaged book.\n")
+		return false
+	}
+	h := res.book
+	headTm := h.CreateTm
+	lastSeqno := -1
+	if res.last != nil {
+		headTm = res.last.Tm
+		lastSeqno = res.last.Seqno
+	}
+	fmt.Printf(`rbook attestation
+    book: %v
+  BookID: %v
+ created: %v
+   elems: %v (last seqno %v)
+    head: %v
+  headTm: %v
+attested: %v
+`, path, h.BookID, h.CreateTm.In(Chicago).Format(RFC3339MicroNumericTZ), res.nelem, lastSeqno, res.head,
+		headTm.In(Chicago).Format(RFC3339MicroNumericTZ), time.Now().In(Chicago).Format(RFC3339MicroNumericTZ))
+	return true
+}
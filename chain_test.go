@@ -0,0 +1,171 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+// frameOffsets returns the byte offset of each element frame in
+// the book at path, plus the offset of the end of the last frame.
+func frameOffsets(path string) (offs []int64) {
+	fd, err := os.Open(path)
+	panicOn(err)
+	defer fd.Close()
+	scan := newBookFrameScanner(fd)
+	_, err = LoadBook(scan.mpr)
+	panicOn(err)
+	for {
+		offs = append(offs, scan.offset())
+		_, err := LoadElem(scan.mpr)
+		if err == io.EOF {
+			return
+		}
+		panicOn(err)
+	}
+}
+
+func TestHashChain(t *testing.T) {
+
+	cv.Convey("each element should carry the hash of the element before it, so that deleting a whole frame is caught by -verify, even though every remaining frame checksum is fine", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-chain")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "chain.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		writeTestBook(path, 3)
+		cfg := &RbookConfig{}
+		res, ok := verifyFrames(path)
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(res.nelem, cv.ShouldEqual, 3)
+		cv.So(res.last.Seqno, cv.ShouldEqual, 2)
+		head3 := res.head
+
+		// re-opening the book picks up the chain where it left off.
+		h, appendFD, err := ReadBook("tester", "testhost", path)
+		panicOn(err)
+		cv.So(h.chainHead, cv.ShouldEqual, head3)
+
+		msg, _ := prepCommandMessage("y <- 2", 3)
+		e := &HashRElem{Typ: Command, Tm: time.Now(), Seqno: 3, CmdJSON: msg}
+		h.mut.Lock()
+		h.appendElem(e)
+		h.mut.Unlock()
+		by, err := e.SaveToSlice()
+		panicOn(err)
+		_, err = appendFD.Write(by)
+		panicOn(err)
+		appendFD.Close()
+
+		cv.So(e.PrevHash, cv.ShouldEqual, head3)
+		res, ok = verifyFrames(path)
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(res.head, cv.ShouldNotEqual, head3)
+		cv.So(cfg.attestBook(path), cv.ShouldBeTrue)
+
+		// cut out the frame for seqno 1.
+		offs := frameOffsets(path)
+		all, err := ioutil.ReadFile(path)
+		panicOn(err)
+		cut := append(append([]byte{}, all[:offs[1]]...), all[offs[2]:]...)
+		panicOn(ioutil.WriteFile(path, cut, 0660))
+
+		cv.So(cfg.verifyBook(path), cv.ShouldBeFalse)
+		cv.So(cfg.attestBook(path), cv.ShouldBeFalse)
+	})
+
+	cv.Convey("-verify should fail a book of format version 1 or later that has a header or element frame with its Checksum, or an element with its PrevHash, cleared; only older books may lack them", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-chain")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "cleared.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		writeTestBook(path, 3)
+		cfg := &RbookConfig{}
+		cv.So(cfg.verifyBook(path), cv.ShouldBeTrue)
+
+		// rewrite rewrites the book at path as tamper says, as is:
+		// with no new checksums.
+		rewrite := func(tamper func(h *HashRBook)) {
+			h, err := loadBookReadOnly(path)
+			panicOn(err)
+			tamper(h)
+			fd, err := os.Create(path)
+			panicOn(err)
+			_, err = writeFrameAsIs(fd, h)
+			panicOn(err)
+			for _, e := range h.elems {
+				_, err = writeFrameAsIs(fd, e)
+				panicOn(err)
+			}
+			panicOn(fd.Close())
+		}
+		pristine, err := ioutil.ReadFile(path)
+		panicOn(err)
+		restore := func() { panicOn(ioutil.WriteFile(path, pristine, 0660)) }
+
+		rewrite(func(h *HashRBook) { h.elems[1].Checksum = "" })
+		cv.So(cfg.verifyBook(path), cv.ShouldBeFalse)
+		restore()
+
+		rewrite(func(h *HashRBook) {
+			e := h.elems[1]
+			e.PrevHash = ""
+			e.Checksum, err = e.ComputeChecksum()
+			panicOn(err)
+		})
+		cv.So(cfg.verifyBook(path), cv.ShouldBeFalse)
+		restore()
+
+		rewrite(func(h *HashRBook) { h.Checksum = "" })
+		cv.So(cfg.verifyBook(path), cv.ShouldBeFalse)
+		restore()
+
+		// a book from before format version 1 is allowed them.
+		rewrite(func(h *HashRBook) {
+			h.FormatVersion = 0
+			h.Checksum = ""
+			for _, e := range h.elems {
+				e.Checksum = ""
+				e.PrevHash = ""
+			}
+		})
+		res, ok := verifyFrames(path)
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(res.unchecked, cv.ShouldEqual, 3)
+	})
+
+	cv.Convey("chainLink should fold in older elements that were written before the chain, without complaint", t, func() {
+		e := &HashRElem{Typ: Command, Seqno: 7}
+		head, err := chainLink("anchor", e, false, true)
+		cv.So(err, cv.ShouldBeNil)
+		sum, err := e.ComputeChecksum()
+		panicOn(err)
+		cv.So(head, cv.ShouldEqual, checksumOf([]byte("anchor"+sum)))
+
+		// but not in a book that has had the chain all along.
+		_, err = chainLink("anchor", e, false, false)
+		cv.So(err, cv.ShouldNotBeNil)
+
+		e.PrevHash = "not-the-anchor"
+		_, err = chainLink("anchor", e, false, true)
+		cv.So(err, cv.ShouldNotBeNil)
+	})
+}
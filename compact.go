@@ -0,0 +1,322 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// loadBookReadOnly reads the whole book at path, without
+// opening it for writing. Unlike ReadBook, a torn last
+// frame is left alone on disk; we just stop before it.
+func loadBookReadOnly(path string) (h *HashRBook, err error) {
+	r, err := OpenBookReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	h = r.Book
+	for {
+		var e *HashRElem
+		e, err = r.Next()
+		if err == io.EOF {
+			return h, nil
+		}
+		if err == TruncatedFrame {
+			fmt.Fprintf(os.Stderr, "rbook: note: '%v' has a torn last frame; ignoring it.\n", path)
+			return h, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		h.elems = append(h.elems, e)
+		if e.Typ == Image {
+			h.path2image[e.ImagePath] = e
+		}
+	}
+}
+
+var (
+	seqnoRegex          = regexp.MustCompile(`"seqno":\s*-?\d+`)
+	overlayOnSeqnoRegex = regexp.MustCompile(`"overlayOnSeqno":\s*(-?\d+)`)
+	hideSeqnoRegex      = regexp.MustCompile(`"overlayHideSeqno":\s*-?\d+`)
+	pathhashRegex       = regexp.MustCompile(`"pathhash":"[^"]*"`)
+)
+
+// rewriteMsgJSON replaces the first match of re within the
+// length-prefixed json msg (as made by prepCommandMessage and friends)
+// with repl, and fixes up the length prefix to match.
+func rewriteMsgJSON(msg string, re *regexp.Regexp, repl string) string {
+	colon := strings.IndexByte(msg, ':')
+	if colon < 0 {
+		return msg
+	}
+	json := msg[colon+1:]
+	loc := re.FindStringIndex(json)
+	if loc == nil {
+		return msg
+	}
+	json = json[:loc[0]] + repl + json[loc[1]:]
+	return fmt.Sprintf("%v:%v", len(json), json)
+}
+
+// reseqElem returns a copy of e renumbered as seqno, and not yet
+// in any hash chain, with the seqno in its message rewritten to
+// match. old2new maps the seqnos of the elements renumbered so
+// far; an overlay is pointed at the new seqno of what it is on,
+// and is not kept (kept is false) if that was dropped.
+func reseqElem(e *HashRElem, seqno int, old2new map[int]int) (ne *HashRElem, kept bool) {
+	cp := *e
+	ne = &cp
+	ne.Checksum = ""
+	ne.PrevHash = ""
+	ne.Seqno = seqno
+	repl := fmt.Sprintf(`"seqno": %v`, ne.Seqno)
+
+	switch ne.Typ {
+	case Command:
+		ne.CmdJSON = rewriteMsgJSON(ne.CmdJSON, seqnoRegex, repl)
+		ne.msg = []byte(ne.CmdJSON)
+	case Console:
+		ne.ConsoleJSON = rewriteMsgJSON(ne.ConsoleJSON, seqnoRegex, repl)
+		ne.msg = []byte(ne.ConsoleJSON)
+	case Comment:
+		ne.CommentJSON = rewriteMsgJSON(ne.CommentJSON, seqnoRegex, repl)
+		ne.msg = []byte(ne.CommentJSON)
+	case Image:
+		ne.ImageJSON = rewriteMsgJSON(ne.ImageJSON, seqnoRegex, repl)
+		ne.msg = []byte(ne.ImageJSON)
+	case Error:
+		ne.ErrorJSON = rewriteMsgJSON(ne.ErrorJSON, seqnoRegex, repl)
+		ne.msg = []byte(ne.ErrorJSON)
+	case Table:
+		ne.TableJSON = rewriteMsgJSON(ne.TableJSON, seqnoRegex, repl)
+		ne.msg = []byte(ne.TableJSON)
+	case Widget:
+		ne.WidgetJSON = rewriteMsgJSON(ne.WidgetJSON, seqnoRegex, repl)
+		ne.msg = []byte(ne.WidgetJSON)
+	case OverlayLaterNote:
+		m := overlayOnSeqnoRegex.FindStringSubmatch(ne.OverlayNoteJSON)
+		if m != nil {
+			var on int
+			fmt.Sscanf(m[1], "%d", &on)
+			newOn, ok := old2new[on]
+			if !ok {
+				// the note was on something we dropped.
+				return nil, false
+			}
+			ne.OverlayNoteJSON = rewriteMsgJSON(ne.OverlayNoteJSON, overlayOnSeqnoRegex, fmt.Sprintf(`"overlayOnSeqno":%v`, newOn))
+		}
+		ne.OverlayNoteJSON = rewriteMsgJSON(ne.OverlayNoteJSON, seqnoRegex, repl)
+	case OverlayHideOutput:
+		newHide, ok := old2new[ne.OverlayHideSeqno]
+		if !ok {
+			return nil, false
+		}
+		ne.OverlayHideSeqno = newHide
+		ne.OverlayHideSeqnoJSON = rewriteMsgJSON(ne.OverlayHideSeqnoJSON, hideSeqnoRegex, fmt.Sprintf(`"overlayHideSeqno":%v`, newHide))
+		ne.OverlayHideSeqnoJSON = rewriteMsgJSON(ne.OverlayHideSeqnoJSON, seqnoRegex, repl)
+	}
+	old2new[e.Seqno] = ne.Seqno
+	return ne, true
+}
+
+// compactBook implements rbook -compact: write a cleaned up copy of
+// the book at path to c.Out. The input book is not changed.
+// The copy keeps the BookID, but:
+//
+//   - drops the duplicate Command elements that older rbook wrote
+//     (dedup on BeginCommandLineNum, just as dumpToScript does);
+//   - with -compact-hide, removes each output an OverlayHideOutput
+//     record hid, and the record itself;
+//   - with -compact-png or -compact-width, re-encodes (and
+//     downscales) the plot images;
+//   - with -compact-norecord, drops the recordPlot() of each
+//     plot;
+//   - renumbers the seqno of every element from 0, and
+//     builds a fresh hash chain.
+func (c *RbookConfig) compactBook(path string) (ok bool) {
+
+	in, err := loadBookReadOnly(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -compact: could not read book '%v': '%v'\n", path, err)
+		return false
+	}
+	if in.FormatVersion > BookFormatVersion {
+		// it may hold seqnos we do not know how to renumber.
+		fmt.Fprintf(os.Stderr, "rbook -compact: '%v' is format version %v, newer than this rbook knows (%v). Use a newer rbook.\n", path, in.FormatVersion, BookFormatVersion)
+		return false
+	}
+	if FileExists(c.Out) {
+		fmt.Fprintf(os.Stderr, "rbook -compact: refusing to overwrite existing '%v'\n", c.Out)
+		return false
+	}
+
+	hidden := make(map[int]bool)
+	if c.CompactHide {
+		for _, e := range in.elems {
+			if e.Typ == OverlayHideOutput {
+				hidden[e.OverlayHideSeqno] = true
+			}
+		}
+	}
+
+	out := &HashRBook{
+		CreateTm:      in.CreateTm,
+		BookID:        in.BookID,
+		User:          in.User,
+		Host:          in.Host,
+		Path:          c.Out,
+		FormatVersion: BookFormatVersion,
+		path2image:    make(map[string]*HashRElem),
+		blobs:         newBlobStore(c.Out),
+	}
+	out.chainHead = out.chainAnchor()
+
+	old2new := make(map[int]int)
+	lastCommandLineNum := 0
+	lastCommandSeqno := -1 // in out.
+	var ndup, nhide, nimg, nrec int
+	for _, e := range in.elems {
+		switch {
+		case e.Typ == Command && e.BeginCommandLineNum > 0 && e.BeginCommandLineNum == lastCommandLineNum:
+			// notes and hides on the duplicate go to the command we kept.
+			old2new[e.Seqno] = lastCommandSeqno
+			ndup++
+			continue
+		case hidden[e.Seqno]:
+			nhide++
+			continue
+		case c.CompactHide && e.Typ == OverlayHideOutput:
+			continue
+		}
+		if e.Typ == Command && e.BeginCommandLineNum > 0 {
+			lastCommandLineNum = e.BeginCommandLineNum
+		}
+
+		ne, kept := reseqElem(e, len(out.elems), old2new)
+		if !kept {
+			continue
+		}
+		if ne.Typ == Command {
+			lastCommandSeqno = ne.Seqno
+		}
+		if ne.Typ == Image {
+			by, err := in.imageBytes(e)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "rbook -compact: image for seqno %v: '%v'\n", e.Seqno, err)
+				return false
+			}
+			if c.CompactPng || c.CompactWidth > 0 {
+				by2, err := recompressPNG(by, c.CompactWidth)
+				if err != nil {
+					// keep what we had; it may not be a png at all.
+					vvlog("rbook -compact: could not re-encode image for seqno %v: '%v'", e.Seqno, err)
+				} else if !bytes.Equal(by, by2) {
+					by = by2
+					nimg++
+					// as PathHash() does, so browsers see the new bytes.
+					ne.ImagePathHash = checksumOf([]byte(ne.ImageHost + ":" + ne.ImagePath + ":" + string(by)))
+					ne.ImageJSON = rewriteMsgJSON(ne.ImageJSON, pathhashRegex, fmt.Sprintf(`"pathhash":"%v"`, ne.ImagePathHash))
+					ne.msg = []byte(ne.ImageJSON)
+				}
+			}
+			if c.CompactNoRecord && len(ne.PlotRDS) > 0 {
+				ne.PlotRDS = nil
+				nrec++
+			}
+			if ne.ImageHash != "" {
+				ne.ImageHash, err = out.blobs.put(by)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "rbook -compact: %v\n", err)
+					return false
+				}
+			} else {
+				ne.ImageBy = by
+			}
+		}
+		out.appendElem(ne)
+	}
+
+	fd := out.DeletePathAndReSaveFullBook(c.Out)
+	panicOn(fd.Close())
+
+	inSz, err := FileSize(path)
+	panicOn(err)
+	outSz, err := FileSize(c.Out)
+	panicOn(err)
+	fmt.Printf("rbook -compact: wrote '%v' (BookID %v): %v elements from %v; dropped %v duplicate commands, %v hidden outputs, and %v recordPlot()s; re-encoded %v images. %v -> %v bytes.\n", c.Out, out.BookID, len(out.elems), len(in.elems), ndup, nhide, nrec, nimg, inSz, outSz)
+	return true
+}
+
+// recompressPNG re-encodes the png in by at best compression,
+// first downscaling it to maxWidth pixels wide if it is wider
+// (maxWidth <= 0 means never downscale). If that does not
+// make it smaller, we return by unchanged.
+func recompressPNG(by []byte, maxWidth int) ([]byte, error) {
+	img, err := png.Decode(bytes.NewReader(by))
+	if err != nil {
+		return nil, err
+	}
+	scaled := false
+	if maxWidth > 0 && img.Bounds().Dx() > maxWidth {
+		img = downscale(img, maxWidth)
+		scaled = true
+	}
+	var buf bytes.Buffer
+	enc := &png.Encoder{CompressionLevel: png.BestCompression}
+	err = enc.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+	if !scaled && buf.Len() >= len(by) {
+		return by, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// downscale shrinks src to w pixels wide, keeping the aspect
+// ratio, by averaging the block of source pixels under each
+// destination pixel. w must be less than the width of src.
+func downscale(src image.Image, w int) image.Image {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	h := sh * w / sw
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewRGBA64(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*sh/h
+		y1 := b.Min.Y + (y+1)*sh/h
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*sw/w
+			x1 := b.Min.X + (x+1)*sw/w
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestCompactBook(t *testing.T) {
+
+	cv.Convey("rbook -compact should drop duplicate commands and hidden outputs, shrink images, and renumber seqnos, keeping the BookID and leaving the input book alone", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-compact")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "in.rbook")
+		outPath := filepath.Join(dir, "out.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		h, appendFD, err := ReadBook("tester", "testhost", path)
+		panicOn(err)
+		add := func(e *HashRElem) {
+			e.Tm = time.Now()
+			h.mut.Lock()
+			h.appendElem(e)
+			h.mut.Unlock()
+			by, err := e.SaveToSlice()
+			panicOn(err)
+			_, err = appendFD.Write(by)
+			panicOn(err)
+		}
+		cmd := func(seqno, line int, code string) *HashRElem {
+			msg, n := prepCommandMessage(code, seqno)
+			return &HashRElem{Typ: Command, Seqno: seqno, CmdJSON: msg, BeginCommandLineNum: line, NumCommandLines: n}
+		}
+
+		img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+		for x := 0; x < 40; x++ {
+			img.Set(x, x%20, color.RGBA{R: 255, A: 255})
+		}
+		var pngbuf bytes.Buffer
+		panicOn(png.Encode(&pngbuf, img))
+
+		c0 := cmd(0, 1, "x <- 1")
+		add(c0)
+		dup := *c0
+		add(&dup) // as older rbook wrote each command twice.
+		add(&HashRElem{Typ: Console, Seqno: 1, ConsoleJSON: prepConsoleMessage(`["big output"]`, 1)})
+		add(cmd(2, 2, "plot(x)"))
+		add(&HashRElem{Typ: Image, Seqno: 3, ImagePath: "/p.png", ImageBy: pngbuf.Bytes(), ImageJSON: prepImageMessage("/p.png", "oldhash", 3)})
+		add(&HashRElem{Typ: OverlayHideOutput, Seqno: 4, OverlayHideSeqno: 1, OverlayHideSeqnoJSON: prepOverlayHideOutput(4, 1)})
+		add(cmd(5, 3, "y <- 2"))
+		appendFD.Close()
+		inSum := checksumOf(mustReadFile(path))
+
+		cfg := &RbookConfig{Out: outPath, CompactHide: true, CompactWidth: 10}
+		cv.So(cfg.compactBook(path), cv.ShouldBeTrue)
+		cv.So(checksumOf(mustReadFile(path)), cv.ShouldEqual, inSum)
+
+		cv.So(cfg.verifyBook(outPath), cv.ShouldBeTrue)
+		out, err := loadBookReadOnly(outPath)
+		panicOn(err)
+		cv.So(out.BookID, cv.ShouldEqual, h.BookID)
+
+		// x <- 1, plot(x), image, y <- 2
+		cv.So(len(out.elems), cv.ShouldEqual, 4)
+		for i, e := range out.elems {
+			cv.So(e.Seqno, cv.ShouldEqual, i)
+			cv.So(string(e.msg), cv.ShouldContainSubstring, fmt.Sprintf(`"seqno": %v,`, i))
+			colon := strings.Index(string(e.msg), ":")
+			cv.So(string(e.msg[:colon]), cv.ShouldEqual, fmt.Sprintf("%v", len(e.msg)-colon-1))
+		}
+		e := out.elems[2]
+		cv.So(e.Typ, cv.ShouldEqual, Image)
+		small, err := png.Decode(bytes.NewReader(e.ImageBy))
+		panicOn(err)
+		cv.So(small.Bounds().Dx(), cv.ShouldEqual, 10)
+		cv.So(small.Bounds().Dy(), cv.ShouldEqual, 5)
+		cv.So(e.ImageJSON, cv.ShouldContainSubstring, e.ImagePathHash)
+
+		// and we will not clobber an existing book.
+		cv.So(cfg.compactBook(path), cv.ShouldBeFalse)
+	})
+
+	cv.Convey("a note or hide on a duplicate command that -compact drops should follow the command it keeps, not be lost", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-compact")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "dupnote.rbook")
+		outPath := filepath.Join(dir, "dupnote-compact.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		cmd := func(seqno, line int, code string) *HashRElem {
+			msg, n := prepCommandMessage(code, seqno)
+			return &HashRElem{Typ: Command, Tm: time.Now(), Seqno: seqno, CmdJSON: msg, BeginCommandLineNum: line, NumCommandLines: n}
+		}
+		writeHostBook(path, "testhost", []*HashRElem{
+			cmd(0, 1, "x <- 1"),
+			cmd(1, 1, "x <- 1"), // the same command line again, under its own seqno.
+			{Typ: OverlayLaterNote, Tm: time.Now(), OverlayNoteJSON: prepOverlayLaterNoteMessage("about x", 2, 1)},
+			cmd(3, 2, "y <- 2"),
+		})
+
+		cfg := &RbookConfig{Out: outPath}
+		cv.So(cfg.compactBook(path), cv.ShouldBeTrue)
+		out, err := loadBookReadOnly(outPath)
+		panicOn(err)
+		cv.So(len(out.elems), cv.ShouldEqual, 3)
+		e := out.elems[1]
+		cv.So(e.Typ, cv.ShouldEqual, OverlayLaterNote)
+		note := &overlayNoteJSON{}
+		panicOn(decodeLenPrefixed(e.OverlayNoteJSON, note))
+		cv.So(note.OverlayOnSeqno, cv.ShouldEqual, 0)
+		cv.So(note.Seqno, cv.ShouldEqual, 1)
+	})
+}
+
+func mustReadFile(path string) []byte {
+	by, err := ioutil.ReadFile(path)
+	panicOn(err)
+	return by
+}
@@ -48,6 +48,7 @@ var embedded_index_template = fmt.Sprintf(`
 
     .RconsoleOutput {background-color: #792374; } /* purple-ish*/
     .RconsoleLine   {text-indent: 50px; }
+    .RmessageOutput {background-color: #7a4a12; } /* message() and warning(): amber */
     .Rcomment       {background-color: #7d8145;
                      /* background-color: #edf1b5; */
                      margin-top: 0.50em;
@@ -56,6 +57,42 @@ var embedded_index_template = fmt.Sprintf(`
     .Rcommand       {margin-top: -1.0em;
                      display: block;
                     }
+    .RcmdStats      {float: right;
+                     font-size: 14px;
+                     font-weight: normal;
+                     color: rgba(255,255,255,0.5);
+                    }
+    .RimagePdf      {display: block;
+                     font-size: 14px;
+                     color: rgba(255,255,255,0.5);
+                    }
+    .RtableWrap     {max-height: 420px;
+                     overflow: auto;
+                     max-width: 100%%;
+                    }
+    .Rtable         {border-collapse: collapse;
+                     font-family: monospace;
+                     font-size: 14px;
+                    }
+    .Rtable th, .Rtable td {border: 1px solid rgba(255,255,255,0.2);
+                     padding: 1px 6px;
+                    }
+    .Rtable thead th {position: sticky;
+                     top: 0;
+                     background-color: #3a3a3a;
+                     cursor: pointer;
+                    }
+    .RtableNA       {color: rgba(255,255,255,0.4); }
+    .RtableNote     {font-size: 12px;
+                     color: rgba(255,255,255,0.5);
+                    }
+    .Rwidget        {width: 100%%;
+                     max-width: 1000px;
+                     height: 500px;
+                     border: none;
+                     background-color: #ffffff;
+                     display: block;
+                    }
     .RsecondCommandLine { color: rgba(0,0,0,0.4);
                         };
 
@@ -67,6 +104,19 @@ var embedded_index_template = fmt.Sprintf(`
 
     /*.RcommandLine   { margin-top: -0.1em; }*/
 
+    .searchHit       { cursor: pointer; white-space: pre; }
+
+    .Rerror          {background-color: #5c0f0f; /* dark red */
+                      border-left: 6px solid #ff4d4d;
+                      margin-top: 0.50em;
+                      display: block;
+                      white-space: pre;
+                     }
+    .RerrorCommand   {text-indent: 50px; color: #ff8080; }
+    .RerrorLine      {text-indent: 50px; color: #ffb3b3; }
+    .RerrorTraceback {text-indent: 50px; font-size: 16px; color: rgba(255,179,179,0.7); }
+    .searchHit:hover { background-color: #792374; }
+
     </style>
 
    <script src="/js_css/cdn.jsdelivr.net/gh/highlightjs/cdn-release@11.6.0/build/highlight.min.js"></script>
@@ -76,6 +126,23 @@ var embedded_index_template = fmt.Sprintf(`
       var globalLastSeqno = -1;
       var lineNum = 1;
 
+      // paged replay: the server sends only the most recent cells
+      // at first. globalOldestSeqno is the oldest we have; when
+      // globalMoreOlder, we ask for the page before it on scrolling
+      // near the top. While a page arrives, cells go into pageDiv,
+      // which is then put above the rest of the log.
+      var globalConn = null;
+      var globalOldestSeqno = -1;
+      var globalMoreOlder = false;
+      var pageRequested = false;
+      var pageDiv = null;
+      var pageInfo = null;
+      var pageCount = 0;
+      var pageLineNumSave = 1;
+
+      // a search hit to jump to, once its page of older cells arrives.
+      var pendingJumpSeqno = -1;
+
       function noNumbers(e) {
           this.value = this.value.replace(/[^\d]/, '');
       }
@@ -83,6 +150,10 @@ var embedded_index_template = fmt.Sprintf(`
       // down arrow scrolls to bottom of page, otherwise leave current view unchanged.
       function checkKey(event) {
             //console.log("event: ", event);
+            if (event.target.tagName == "INPUT") {
+                // typing into the goto or search dialog.
+                return;
+            }
             if (event.shiftKey) {
                switch (event.key) {
                   case "ArrowDown":
@@ -108,6 +179,12 @@ var embedded_index_template = fmt.Sprintf(`
                 gotoLineEntry.addEventListener('input', noNumbers, false);
                 gotoDialogBox.showModal();
              }
+             if (event.key == "/") {
+                // search dialog; see /search on the server.
+                event.preventDefault();
+                document.getElementById("searchHits").innerHTML = "";
+                document.getElementById("mySearchDialog").showModal();
+             }
       }
       document.onkeydown = checkKey;
 
@@ -132,6 +209,66 @@ var embedded_index_template = fmt.Sprintf(`
           }
       }
 
+      // searchBook asks the server for the cells matching the
+      // regular expression in the search dialog, and lists them;
+      // clicking one jumps to its cell.
+      function searchBook(event) {
+          event.preventDefault();
+          var q = document.getElementById("search_request").value;
+          var hitsDiv = document.getElementById("searchHits");
+          hitsDiv.innerHTML = "";
+          if (q == '') {
+              return;
+          }
+          fetch("/search?q=" + encodeURIComponent(q)).then(function(resp) {
+              if (!resp.ok) {
+                  return resp.text().then(function(text) { throw new Error(text); });
+              }
+              return resp.json();
+          }).then(function(res) {
+              if (res.hits.length == 0) {
+                  hitsDiv.textContent = "no hits.";
+                  return;
+              }
+              for (let i = 0; i < res.hits.length; i++) {
+                  let hit = res.hits[i];
+                  var div = document.createElement('div');
+                  div.className = "searchHit";
+                  div.textContent = '[' + pad(hit.line,3) + '] ' + hit.typ + ': ' + hit.text;
+                  div.title = "seqno " + hit.seqno + " at " + hit.tm;
+                  div.onclick = function() {
+                      document.getElementById("mySearchDialog").close();
+                      jumpToSeqno(hit.seqno);
+                  };
+                  hitsDiv.appendChild(div);
+              }
+              if (res.more) {
+                  var more = document.createElement('div');
+                  more.textContent = "... and more; only the first " + res.hits.length + " are shown.";
+                  hitsDiv.appendChild(more);
+              }
+          }).catch(function(err) {
+              hitsDiv.textContent = err.message;
+          });
+      }
+
+      // jumpToSeqno scrolls to the cell for seqno. If it is in a
+      // page of older cells not yet loaded, we ask for older pages
+      // until it arrives.
+      function jumpToSeqno(seqno) {
+          var list = document.getElementsByClassName('cell_' + seqno);
+          if (list.length > 0) {
+              pendingJumpSeqno = -1;
+              list[0].scrollIntoView({behavior:"instant", block: "start", inline: "nearest"});
+              return;
+          }
+          pendingJumpSeqno = -1;
+          if (globalMoreOlder && seqno < globalOldestSeqno) {
+              pendingJumpSeqno = seqno;
+              requestOlder();
+          }
+      }
+
       function stamp() {
           var dt = new Date();
           //document.getElementById("datetime").innerHTML = dt.toLocaleString();
@@ -161,9 +298,12 @@ var embedded_index_template = fmt.Sprintf(`
  */
 function tryConnectToReload(address) {
   var conn = new WebSocket(address);
+  globalConn = conn;
 
   conn.onclose = function() {
     globalLastSeqno = -1;
+    pageDiv = null;
+    pageRequested = false;
     setTimeout(function() {
       tryConnectToReload(address);
     }, 2000);
@@ -246,6 +386,9 @@ function scrollToBottom() {
 }
 
 function nextID() {
+    if (pageDiv !== null) {
+        return "log_p" + pageCount.toString() + "_" + pageDiv.children.length.toString();
+    }
     var d  = document.getElementById("log");
     var n  = d.children.length;
     var id  = "log_" + n.toString();
@@ -355,17 +498,154 @@ function toggleConsoleOutputDoubleClick(seqno) {
     }
 }
       
+// ask for the page of cells before the oldest we have.
+function requestOlder() {
+    if (!globalMoreOlder || pageRequested || globalConn === null) {
+        return;
+    }
+    pageRequested = true;
+    globalConn.send(JSON.stringify({older: globalOldestSeqno}));
+}
+
+function showOlderLink() {
+    document.getElementById("older-log").hidden = !globalMoreOlder;
+}
+
+window.addEventListener("scroll", function() {
+    if (window.scrollY < 200) {
+        requestOlder();
+    }
+});
+
+// makeRtable builds the sortable, scrollable table for a Table
+// element: t has names, types, rownames, rows (null for NA), and
+// nrow and ncol, the rows and columns in the whole data.frame.
+function makeRtable(t, seqno) {
+    var wrap = document.createElement('div');
+    wrap.id = nextID();
+    wrap.className = 'RtableWrap cell_' + seqno;
+    var table = document.createElement('table');
+    table.className = 'Rtable';
+    var thead = document.createElement('thead');
+    var hrow = document.createElement('tr');
+    var tbody = document.createElement('tbody');
+    var numeric = function(ty) { return ty == 'numeric' || ty == 'double' || ty == 'integer'; };
+
+    var corner = document.createElement('th');
+    corner.textContent = '';
+    hrow.appendChild(corner);
+
+    var sortCol = -1, sortDir = 1;
+    var order = t.rows.map(function(row, i) { return i; });
+    var fill = function() {
+        tbody.textContent = '';
+        for (let k = 0; k < order.length; k++) {
+            var i = order[k];
+            var tr = document.createElement('tr');
+            var rn = document.createElement('th');
+            rn.textContent = t.rownames[i];
+            tr.appendChild(rn);
+            for (let j = 0; j < t.names.length; j++) {
+                var td = document.createElement('td');
+                var v = t.rows[i][j];
+                if (v === null) {
+                    td.textContent = 'NA';
+                    td.className = 'RtableNA';
+                } else {
+                    td.textContent = String(v);
+                }
+                if (numeric(t.types[j])) {
+                    td.style.textAlign = 'right';
+                }
+                tr.appendChild(td);
+            }
+            tbody.appendChild(tr);
+        }
+    };
+    var sortBy = function(j) {
+        sortDir = (sortCol == j) ? -sortDir : 1;
+        sortCol = j;
+        order.sort(function(a, b) {
+            var x = t.rows[a][j], y = t.rows[b][j];
+            // NA last, as R's order() puts them.
+            if (x === null || y === null) {
+                return (x === null) - (y === null);
+            }
+            if (x < y) { return -sortDir; }
+            if (x > y) { return sortDir; }
+            return a - b;
+        });
+        fill();
+    };
+    for (let j = 0; j < t.names.length; j++) {
+        var th = document.createElement('th');
+        th.textContent = t.names[j];
+        th.title = t.types[j] + '; click to sort';
+        th.onclick = function() { sortBy(j); };
+        hrow.appendChild(th);
+    }
+    thead.appendChild(hrow);
+    table.appendChild(thead);
+    table.appendChild(tbody);
+    fill();
+    wrap.appendChild(table);
+
+    var note = document.createElement('div');
+    note.className = 'RtableNote';
+    note.textContent = t.nrow + ' rows x ' + t.ncol + ' columns';
+    if (t.rows.length < t.nrow) {
+        note.textContent += '; the first ' + t.rows.length + ' rows shown';
+    }
+    if (t.names.length < t.ncol) {
+        note.textContent += '; the first ' + t.names.length + ' columns shown';
+    }
+    wrap.appendChild(note);
+    return wrap;
+}
+
 function appendLog(msg){
  
     //console.log("msg = ", msg);
     
     const update = JSON.parse(msg)
 
-    var d  = document.getElementById("log");
+    var log = document.getElementById("log");
+
+    if (update.pageStart) {
+        pageCount++;
+        pageDiv = document.createElement('div');
+        pageInfo = update;
+        pageLineNumSave = lineNum;
+        if (update.firstLine > 0) {
+            lineNum = update.firstLine;
+        }
+        return;
+    }
+    if (update.pageEnd) {
+        // put the older page above, keeping what the user sees in place.
+        var before = document.body.scrollHeight;
+        log.insertBefore(pageDiv, log.firstChild);
+        window.scrollBy(0, document.body.scrollHeight - before);
+        pageDiv = null;
+        lineNum = pageLineNumSave;
+        globalOldestSeqno = pageInfo.oldest;
+        globalMoreOlder = pageInfo.more;
+        pageRequested = false;
+        showOlderLink();
+        if (pendingJumpSeqno >= 0) {
+            jumpToSeqno(pendingJumpSeqno);
+        }
+        return;
+    }
+
+    var d = log;
+    if (pageDiv !== null) {
+        d = pageDiv;
+    }
 
     if (update.comment) {
          //console.log("we just saw comment message: ", update.comment);
-         var newstuff = '<div id="' + nextID() + '" class="Rcomment">';
+         var newstuff = '<div id="' + nextID() + '" class="Rcomment cell_' + update.seqno + '">';
 
         for (let i = 0; i < update.comment.length; i++) {
             newstuff += '<div class="RcommentLine">' + update.comment[i] + '</div>';
@@ -384,12 +664,22 @@ function appendLog(msg){
          document.getElementById("bookID").innerHTML = '#' + update.book.user + "@" + update.book.host + ":" + update.book.path + "<br/>#BookID:" + update.book.bookID;
          document.getElementById("datetime").innerHTML = update.book.createTm;
          globalLastSeqno = -1;
+         if (update.firstLine > 0) {
+             lineNum = update.firstLine;
+         }
+         globalOldestSeqno = update.oldest;
+         globalMoreOlder = update.more;
+         pageRequested = false;
+         pageDiv = null;
+         d = log;
+         showOlderLink();
          // this clears all previous log entries/cells.
          d.innerHTML = "";         
     }
      
     // try to prevent duplicates due to websocket tomfoolery.
-     if (update.seqno) {
+    // Pages of older cells come after newer ones, so skip this for them.
+     if (update.seqno && pageDiv === null) {
          // recognize a refresh from the start
          if (update.seqno == 0) {
              console.log("update.seqno is 0, clearing all and restarting: update=", update);
@@ -420,7 +710,7 @@ function appendLog(msg){
     if (update.command) {
          //console.log("we just saw command message: ", update.command);
 
-         var newstuff = '<div id="' + nextID() + '" class="Rcommand"><pre><code>';
+         var newstuff = '<div id="' + nextID() + '" class="Rcommand cell_' + update.seqno + '"><pre><code>';
 
          for (let i = 0; i < update.command.length; i++) {
              var lineNumClass = 'line_' + lineNum.toString();
@@ -435,6 +725,16 @@ function appendLog(msg){
          newstuff += '</code></pre></div>';
          var newDiv = document.createElement('div');
          newDiv.innerHTML = newstuff;
+         if (update.stats) {
+             // how long it ran, and the memory it used; see stats.go.
+             var statsSpan = document.createElement('span');
+             statsSpan.className = 'RcmdStats';
+             statsSpan.textContent = update.stats;
+             var firstLine = newDiv.querySelector('.RcommandLine');
+             if (firstLine !== null) {
+                 firstLine.appendChild(statsSpan);
+             }
+         }
          d.appendChild(newDiv);
          //d.innerHTML += newstuff + '</code></pre></div>';
          //console.log("we added a command block")
@@ -444,6 +744,52 @@ function appendLog(msg){
         //console.log("we added command text");
     }
 
+    if (update.error) {
+         // an R error: the input that failed, R's message, and the traceback.
+         var errDiv = document.createElement('div');
+         errDiv.id = nextID();
+         errDiv.className = 'Rerror cell_' + update.seqno;
+         var addErrorLine = function(cls, text) {
+             var line = document.createElement('div');
+             line.className = cls;
+             line.textContent = text;
+             errDiv.appendChild(line);
+         };
+         for (let i = 0; i < update.errorCommand.length; i++) {
+             addErrorLine('RerrorCommand', '! ' + update.errorCommand[i]);
+         }
+         for (let i = 0; i < update.error.length; i++) {
+             addErrorLine('RerrorLine', update.error[i]);
+         }
+         var tb = update.traceback || [];
+         for (let i = 0; i < tb.length; i++) {
+             // numbered as traceback() prints it, innermost first.
+             var callLines = tb[i].split('\n');
+             for (let j = 0; j < callLines.length; j++) {
+                 var num = (j == 0) ? String(tb.length - i).padStart(3) + ': ' : '     ';
+                 addErrorLine('RerrorTraceback', num + callLines[j]);
+             }
+         }
+         d.appendChild(errDiv);
+    }
+
+    if (update.table) {
+         // a printed data.frame or matrix; click a column name to sort by it.
+         d.appendChild(makeRtable(update.table, update.seqno));
+    }
+
+    if (update.widget) {
+         // a printed htmlwidget. Its scripts run in a sandboxed
+         // iframe, which cannot reach this page or its websocket.
+         var widgetHost = window.location.hostname;
+         var frame = document.createElement('iframe');
+         frame.className = 'Rwidget cell_' + update.seqno;
+         frame.setAttribute('sandbox', 'allow-scripts');
+         frame.title = update.widgetClass;
+         frame.src = 'http://' + widgetHost + ':{{.Port}}/widget/' + update.widget;
+         d.appendChild(frame);
+    }
+
     // in theory the command and the output could arrive together, so
     // print the console output after the text of the command.
     if (update.console) {
@@ -456,7 +802,12 @@ function appendLog(msg){
         var isLong = false;
 
         // use seqno_topparent_3319 class as an ID to locate the "compressed" or not state.
-        var newstuff = '<div id="' + nextID() + '" class="RconsoleOutput seqno_topparent_'+update.seqno+'"><pre><code>';
+        var outputClass = 'RconsoleOutput';
+        if (update.stream == 'stderr') {
+            // from message(), warning(), and the like.
+            outputClass += ' RmessageOutput';
+        }
+        var newstuff = '<div id="' + nextID() + '" class="' + outputClass + ' seqno_topparent_'+update.seqno+' cell_'+update.seqno+'"><pre><code>';
 
          if (update.console.length >= 40) {
             // special case handling for very long output so we still show the top/bottom 15 lines
@@ -510,13 +861,25 @@ function appendLog(msg){
         // remove the leading slash(es) from update.image to avoid the 247msec network 301 redirect
         // that happens when seeing host:port/rbook//path -> host:port/rbook/path
         var upimg = update.image.replace(/^\/+/, '');
+        // prefer the svg rendering, when rbook -vector made one.
+        if (update.svg) {
+           upimg = update.svg.replace(/^\/+/, '');
+        }
         //var urlhost = {{.WsHost}};
         var urlhost = window.location.hostname; // .host has the :port too, which we elide.
 
-        var newstuff = '<div id="'+ nextID() +'" style="max-width: 800px"><img src="http://'+urlhost+':{{.Port}}/rbook/' + upimg + '?pathhash=' + hash + '" style="max-width:100%%;"/></div>';
+        var newstuff = '<div id="'+ nextID() +'" class="cell_' + update.seqno + '" style="max-width: 800px"><img src="http://'+urlhost+':{{.Port}}/rbook/' + upimg + '?pathhash=' + hash + '" style="max-width:100%%;"/></div>';
 
          var newDiv = document.createElement('div');
          newDiv.innerHTML = newstuff;
+         if (update.pdf) {
+            var pdfLink = document.createElement('a');
+            pdfLink.className = 'RimagePdf';
+            pdfLink.href = 'http://'+urlhost+':{{.Port}}/rbook/' + update.pdf.replace(/^\/+/, '') + '?pathhash=' + hash;
+            pdfLink.target = '_blank';
+            pdfLink.textContent = 'pdf';
+            newDiv.firstChild.appendChild(pdfLink);
+         }
          d.appendChild(newDiv);
 
         //d.innerHTML += newstuff;        
@@ -570,14 +933,22 @@ try {
           <button id="gotoDialogOK" value="default" hidden>ok</button>
       </form>
   </dialog>
+  <dialog id="mySearchDialog">
+      <form method="dialog" onsubmit="searchBook(event)">
+          <label>search:<input name="search_req" id="search_request" placeholder="a regular expression"/></label>
+          <button id="searchDialogOK" value="default" hidden>ok</button>
+      </form>
+      <div id="searchHits"></div>
+  </dialog>
   
   <p><span id="bookID"></span><br/>
     #R rbook created: <span id="datetime"></span></p>
-[g: goto line || shift-down: end-of-log || shift-up: pop to top || shift-space: page up || space: page down]
+[g: goto line || /: search || shift-down: end-of-log || shift-up: pop to top || shift-space: page up || space: page down]
   <p/>
   <br/>
+  <div id="older-log" onclick="requestOlder()" hidden>--- older cells: scroll up or click to load ---</div>
   <div id="log"> </div>
-  <div id="end-of-log">--- end of log --- [g: goto line || shift-down: end-of-log || shift-up: pop to top || shift-space: page up || space: page down]</div>
+  <div id="end-of-log">--- end of log --- [g: goto line || /: search || shift-down: end-of-log || shift-up: pop to top || shift-space: page up || space: page down]</div>
 </body>
 
 </html>
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestErrorElem(t *testing.T) {
+
+	cv.Convey("an Error element should keep the failed command, R's message, and the traceback; and -dump, the exports, -textconv, -grep, and -extract should all show it", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-error")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "my.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		t0 := time.Date(2023, 9, 12, 5, 0, 0, 0, Chicago)
+		msg, n := prepCommandMessage("f <- function(x) stop('boom')", 0)
+		writeHostBook(path, "rog", []*HashRElem{
+			{Typ: Command, Tm: t0, CmdJSON: msg, BeginCommandLineNum: 1, NumCommandLines: n}, // 0
+			{Typ: Error, Tm: t0.Add(time.Minute), ErrorJSON: prepErrorMessage( // 1
+				[]string{"g(1,", "  2)"},
+				[]string{"Error in f(x) : boom"},
+				[]string{`stop("boom")`, "f(x)", "g(1,\n  2)"}, 1)},
+			{Typ: Console, Tm: t0.Add(2 * time.Minute), ConsoleJSON: prepConsoleMessage(`["## [1] 2"]`, 2)}, // 2
+		})
+
+		h, err := loadBookReadOnly(path)
+		panicOn(err)
+		cv.So(h.elems[1].Typ, cv.ShouldEqual, Error)
+		cv.So(Error.String(), cv.ShouldEqual, "Error")
+		d, err := decodeElem(h.elems[1])
+		panicOn(err)
+		cv.So(d.Seqno, cv.ShouldEqual, 1)
+		cv.So(d.ErrorCommand, cv.ShouldResemble, []string{"g(1,", "  2)"})
+		cv.So(d.Error, cv.ShouldResemble, []string{"Error in f(x) : boom"})
+		cv.So(len(d.Traceback), cv.ShouldEqual, 3)
+
+		// all commented out, so the script still runs.
+		fd, err := ioutil.TempFile(dir, "dump")
+		panicOn(err)
+		cv.So((&RbookConfig{}).dumpBook(fd, path), cv.ShouldBeTrue)
+		panicOn(fd.Close())
+		dump := string(mustReadFile(fd.Name()))
+		cv.So(dump, cv.ShouldContainSubstring, " ## error: 2023-09-12T05:01:00.000000-05:00\n")
+		cv.So(dump, cv.ShouldContainSubstring, "\n#! g(1,\n#!   2)\n    #! Error in f(x) : boom\n    #! traceback:\n")
+		cv.So(dump, cv.ShouldContainSubstring, "\n    #!   3: stop(\"boom\")\n    #!   2: f(x)\n    #!   1: g(1,\n    #!        2)\n")
+
+		cfg := &RbookConfig{Out: filepath.Join(dir, "out.html")}
+		cv.So(cfg.exportHTML(path), cv.ShouldBeTrue)
+		page := string(mustReadFile(cfg.Out))
+		cv.So(page, cv.ShouldContainSubstring, `<div class="Rerror" title="`)
+		cv.So(page, cv.ShouldContainSubstring, `<div class="RerrorTraceback">  3: stop(&#34;boom&#34;)</div>`)
+
+		var text bytes.Buffer
+		cv.So(cfg.textconvBook(&text, path), cv.ShouldBeTrue)
+		cv.So(text.String(), cv.ShouldContainSubstring, "\n[0001] error ")
+		cv.So(text.String(), cv.ShouldContainSubstring, "\n    ! g(1,\n")
+
+		var out bytes.Buffer
+		n2, err := grepBook(&out, regexp.MustCompile("boom"), path)
+		panicOn(err)
+		cv.So(n2, cv.ShouldEqual, 3)
+		cv.So(out.String(), cv.ShouldContainSubstring, "  seqno 1  [001]  ")
+		cv.So(out.String(), cv.ShouldContainSubstring, "  error: Error in f(x) : boom\n")
+
+		f, err := newElemFilter("", "", "", "error")
+		panicOn(err)
+		cfg = &RbookConfig{Out: filepath.Join(dir, "errors.rbook"), filter: f}
+		cv.So(cfg.extractBook(path), cv.ShouldBeTrue)
+		x, err := loadBookReadOnly(cfg.Out)
+		panicOn(err)
+		cv.So(len(x.elems), cv.ShouldEqual, 1)
+		cv.So(strings.HasPrefix(x.elems[0].ErrorJSON[strings.Index(x.elems[0].ErrorJSON, ":")+1:], `{"seqno": 0,`), cv.ShouldBeTrue)
+	})
+}
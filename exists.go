@@ -4,6 +4,7 @@ package main
 
 import (
 	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -44,3 +45,13 @@ func FileModTime(name string) (time.Time, error) {
 	}
 	return fi.ModTime(), nil
 }
+
+// absPath returns path made absolute, or path itself if it
+// cannot be.
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
@@ -0,0 +1,134 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// exportOverlays holds what the overlay elements of a book say
+// about the elements they overlay. Overlays come after what they
+// are about, so the exporters gather them in a first pass.
+type exportOverlays struct {
+	// seqnos whose output an OverlayHideOutput has folded away.
+	hidden map[int]bool
+
+	// later notes, by the seqno they are on.
+	notes map[int][]*exportNote
+}
+
+type exportNote struct {
+	elem *HashRElem
+	note string
+}
+
+// overlayNoteJSON is the decoded form of HashRElem.OverlayNoteJSON,
+// as made by prepOverlayLaterNoteMessage.
+type overlayNoteJSON struct {
+	Seqno          int    `json:"seqno"`
+	OverlayNote    string `json:"overlayNote"`
+	OverlayOnSeqno int    `json:"overlayOnSeqno"`
+}
+
+func newExportOverlays() *exportOverlays {
+	return &exportOverlays{
+		hidden: make(map[int]bool),
+		notes:  make(map[int][]*exportNote),
+	}
+}
+
+// add takes note of e, if it is an overlay.
+func (ov *exportOverlays) add(e *HashRElem) error {
+	switch e.Typ {
+	case OverlayHideOutput:
+		ov.hidden[e.OverlayHideSeqno] = true
+	case OverlayLaterNote:
+		d := &overlayNoteJSON{}
+		err := decodeLenPrefixed(e.OverlayNoteJSON, d)
+		if err != nil {
+			return fmt.Errorf("exportOverlays.add() error on seqno %v: '%s'", e.Seqno, err)
+		}
+		ov.notes[d.OverlayOnSeqno] = append(ov.notes[d.OverlayOnSeqno], &exportNote{elem: e, note: d.OverlayNote})
+	}
+	return nil
+}
+
+// scanOverlays reads the book at path for its overlays.
+func scanOverlays(path string) (ov *exportOverlays, err error) {
+	r, err := OpenBookReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	ov = newExportOverlays()
+	for {
+		e, err := r.Next()
+		if err == io.EOF || err == TruncatedFrame {
+			return ov, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = ov.add(e)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// decodeLenPrefixed decodes the json of a length-prefixed
+// message (as made by prepCommandMessage and friends) into v.
+func decodeLenPrefixed(msg string, v interface{}) error {
+	colon := strings.IndexByte(msg, ':')
+	if colon < 0 {
+		return fmt.Errorf("decodeLenPrefixed() error: no length prefix on '%v'", msg)
+	}
+	return json.Unmarshal([]byte(msg[colon+1:]), v)
+}
+
+// decodeElem decodes the browser message of e. Elements with
+// no message (the overlays, and types from a newer rbook)
+// give nil.
+func decodeElem(e *HashRElem) (d *DecodeJSON, err error) {
+	if len(e.msg) == 0 {
+		return nil, nil
+	}
+	colon := bytes.IndexByte(e.msg, ':')
+	d = &DecodeJSON{}
+	err = json.Unmarshal(e.msg[colon+1:], d)
+	if err != nil {
+		return nil, fmt.Errorf("decodeElem() error on seqno %v: '%s'", e.Seqno, err)
+	}
+	return
+}
+
+// exportOutPath returns where an export of the book at bookpath
+// goes: the -o path if given, else the book path plus ext.
+func (c *RbookConfig) exportOutPath(bookpath, ext string) string {
+	if c.Out != "" {
+		return c.Out
+	}
+	return bookpath + ext
+}
+
+// createAside opens a temporary file beside path. Call
+// finishAside to rename it into place once it is all written,
+// so a failed export never leaves a partial file at path.
+func createAside(path string) (*os.File, error) {
+	return os.Create(fmt.Sprintf("%v.tmp.%v", path, os.Getpid()))
+}
+
+func finishAside(fd *os.File, path string) error {
+	err := fd.Close()
+	if err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+	return os.Rename(fd.Name(), path)
+}
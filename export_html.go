@@ -0,0 +1,261 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// exportHTML implements rbook -export-html: write the book at
+// bookpath as one self-contained html file, with highlight.js and
+// every plot inlined, that a browser can show with no rbook
+// server and no network. Hidden outputs are folded, and later
+// notes shown under what they are about.
+func (c *RbookConfig) exportHTML(bookpath string) (ok bool) {
+	out := c.exportOutPath(bookpath, ".html")
+
+	ov, err := scanOverlays(bookpath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -export-html: could not read book '%v': '%v'\n", bookpath, err)
+		return false
+	}
+	r, err := OpenBookReader(bookpath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -export-html: %v\n", err)
+		return false
+	}
+	defer r.Close()
+
+	fd, err := createAside(out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -export-html: %v\n", err)
+		return false
+	}
+	w := bufio.NewWriter(fd)
+	writeHTMLHead(w, bookpath, r.Book)
+
+	lastCommandLineNum := 0
+	nelem := 0
+	for {
+		e, err := r.Next()
+		if err == io.EOF || err == TruncatedFrame {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rbook -export-html: bad frame at offset %v: '%v'\n", r.Offset(), err)
+			fd.Close()
+			os.Remove(fd.Name())
+			return false
+		}
+		if e.BeginCommandLineNum > 0 {
+			if e.BeginCommandLineNum == lastCommandLineNum {
+				continue // a duplicate, as -dump skips.
+			}
+			lastCommandLineNum = e.BeginCommandLineNum
+		}
+		err = writeHTMLElem(w, r.Book, e, ov)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rbook -export-html: %v\n", err)
+			fd.Close()
+			os.Remove(fd.Name())
+			return false
+		}
+		nelem++
+	}
+	writeHTMLTail(w)
+
+	err = w.Flush()
+	if err == nil {
+		err = finishAside(fd, out)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -export-html: %v\n", err)
+		return false
+	}
+	fmt.Printf("rbook -export-html: wrote %v elements of '%v' to '%v'\n", nelem, bookpath, out)
+	return true
+}
+
+// readJsCss returns the js_css/ file at rel, from the rbook
+// checkout or from the current directory.
+func readJsCss(rel string) ([]byte, error) {
+	by, err := ioutil.ReadFile(homeRbookDir() + rel)
+	if err == nil {
+		return by, nil
+	}
+	return ioutil.ReadFile(rel)
+}
+
+// same look as the live page; see embed_template.go.
+const exportHTMLStyle = `
+    body {
+        font-family:Consolas,Monaco,Lucida Console,Liberation Mono,DejaVu Sans Mono,Bitstream Vera Sans Mono,Courier New;
+        font-weight: bold;
+        font-size: 20px;
+        background-color: #101010;
+        color: #ffffff;
+    }
+    pre { margin: 0; }
+    pre > code { white-space: pre; display: block; }
+    .RconsoleOutput { background-color: #792374; }
+    .RconsoleLine   { text-indent: 50px; white-space: pre; }
+    .RmessageOutput { background-color: #7a4a12; }
+    .Rcomment       { background-color: #7d8145; margin-top: 0.50em; display: block; }
+    .Rcommand       { margin-top: 0.50em; display: block; }
+    .RsecondCommandLine { color: rgba(255,255,255,0.4); }
+    .Rerror         { background-color: #5c0f0f; border-left: 6px solid #ff4d4d; margin-top: 0.50em; display: block; }
+    .RerrorLine     { text-indent: 50px; white-space: pre; color: #ffb3b3; }
+    .RerrorTraceback { text-indent: 50px; white-space: pre; font-size: 16px; color: rgba(255,179,179,0.7); }
+    .RlaterNote     { background-color: #2c5d7c; margin: 0.25em 0 0.25em 50px; padding: 0.25em; }
+    .RlaterNoteTm   { font-size: 14px; color: rgba(255,255,255,0.6); }
+    .RsourceHost    { font-size: 14px; color: rgba(255,255,255,0.6); }
+    summary         { cursor: pointer; white-space: pre; }
+    img             { max-width: 100%; }
+    .Rwidget        { width: 100%; max-width: 1000px; height: 500px; border: none; background-color: #ffffff; display: block; }
+`
+
+func writeHTMLHead(w io.Writer, bookpath string, book *HashRBook) {
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>rbook %v</title>
+  <style>%v</style>
+`, html.EscapeString(filepath.Base(bookpath)), exportHTMLStyle)
+
+	css, err := readJsCss(highlightCSS)
+	if err == nil {
+		fmt.Fprintf(w, "  <style>\n%s\n  </style>\n", css)
+	}
+	js, err := readJsCss(highlightJS)
+	if err == nil {
+		fmt.Fprintf(w, "  <script>\n%s\n  </script>\n", js)
+	} else {
+		fmt.Fprintf(os.Stderr, "rbook -export-html: note: no syntax highlighting; could not read highlight.js: '%v'\n", err)
+	}
+
+	fmt.Fprintf(w, `</head>
+<body>
+<div id="bookID">#%v@%v:%v<br/>#BookID:%v</div>
+<div id="datetime">%v</div>
+<div id="log">
+`, html.EscapeString(book.User), html.EscapeString(book.Host), html.EscapeString(book.Path), html.EscapeString(book.BookID), book.CreateTm.In(Chicago).Format(RFC3339MicroNumericTZ))
+}
+
+func writeHTMLTail(w io.Writer) {
+	fmt.Fprintf(w, `</div>
+<script>
+if (typeof hljs !== "undefined") {
+    document.querySelectorAll("code.language-r").forEach(function(el) { hljs.highlightElement(el); });
+}
+</script>
+</body>
+</html>
+`)
+}
+
+// writeHTMLElem writes e, and any later notes on it.
+func writeHTMLElem(w io.Writer, book *HashRBook, e *HashRElem, ov *exportOverlays) error {
+	d, err := decodeElem(e)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return nil
+	}
+	tm := e.Tm.In(Chicago).Format(RFC3339MicroNumericTZ)
+	host := ""
+	if e.SourceHost != "" {
+		// from rbook -merge.
+		tm += " on " + e.SourceHost
+		host = fmt.Sprintf(`<span class="RsourceHost">%v</span> `, html.EscapeString(e.SourceHost))
+	}
+
+	switch e.Typ {
+	case Command:
+		fmt.Fprintf(w, "<div class=\"Rcommand\" title=\"%v\"><pre>%v", html.EscapeString(tm), host)
+		for i, line := range d.Command {
+			num := fmt.Sprintf("[%03d]", e.BeginCommandLineNum+i)
+			if i > 0 {
+				num = `<span class="RsecondCommandLine">` + num + `</span>`
+			}
+			fmt.Fprintf(w, "<div class=\"RcommandLine line_%v\">%v <code class=\"language-r\" style=\"display:inline\">%v</code></div>", e.BeginCommandLineNum+i, num, html.EscapeString(line))
+		}
+		fmt.Fprintf(w, "</pre></div>\n")
+
+	case Console:
+		lines := d.Console
+		class := "RconsoleOutput"
+		if d.Stream == "stderr" {
+			class += " RmessageOutput"
+		}
+		if ov.hidden[e.Seqno] && len(lines) > 1 {
+			// folded, as OverlayHideOutput does on the live page.
+			fmt.Fprintf(w, "<details class=\"%v\"><summary>%v <i>(%v more lines)</i></summary>", class, html.EscapeString(lines[0]), len(lines)-1)
+			for _, line := range lines[1:] {
+				fmt.Fprintf(w, "<div class=\"RconsoleLine\">%v</div>", html.EscapeString(line))
+			}
+			fmt.Fprintf(w, "</details>\n")
+		} else {
+			fmt.Fprintf(w, "<div class=\"%v\">", class)
+			for _, line := range lines {
+				fmt.Fprintf(w, "<div class=\"RconsoleLine\">%v</div>", html.EscapeString(line))
+			}
+			fmt.Fprintf(w, "</div>\n")
+		}
+
+	case Comment:
+		fmt.Fprintf(w, "<div class=\"Rcomment\" title=\"%v\">%v", html.EscapeString(tm), host)
+		for _, line := range d.Comment {
+			fmt.Fprintf(w, "<div class=\"RcommentLine\">%v</div>", html.EscapeString(line))
+		}
+		fmt.Fprintf(w, "</div>\n")
+
+	case Error:
+		fmt.Fprintf(w, "<div class=\"Rerror\" title=\"%v\"><pre>%v", html.EscapeString(tm), host)
+		for _, line := range d.ErrorCommand {
+			fmt.Fprintf(w, "<div>! <code class=\"language-r\" style=\"display:inline\">%v</code></div>", html.EscapeString(line))
+		}
+		fmt.Fprintf(w, "</pre>")
+		for _, line := range d.Error {
+			fmt.Fprintf(w, "<div class=\"RerrorLine\">%v</div>", html.EscapeString(line))
+		}
+		for _, line := range tracebackLines(d.Traceback) {
+			fmt.Fprintf(w, "<div class=\"RerrorTraceback\">%v</div>", html.EscapeString(line))
+		}
+		fmt.Fprintf(w, "</div>\n")
+
+	case Image:
+		// the svg when there is one, to stay sharp when printed.
+		mime := mimeSVG
+		by, err := book.renderBytes(e, mime)
+		if err == nil && len(by) == 0 {
+			mime = e.mimeOf()
+			by, err = book.imageBytes(e)
+		}
+		if err != nil {
+			return fmt.Errorf("image for seqno %v: '%s'", e.Seqno, err)
+		}
+		fmt.Fprintf(w, "<div class=\"Rimage\" style=\"max-width: 800px\" title=\"%v\"><img alt=\"%v\" src=\"data:%v;base64,%v\"/></div>\n", html.EscapeString(tm), html.EscapeString(e.ImagePath), mime, base64.StdEncoding.EncodeToString(by))
+
+	case Widget:
+		// inline, sandboxed as on the live page.
+		by, err := book.widgetHTML(e)
+		if err != nil {
+			return fmt.Errorf("widget for seqno %v: '%s'", e.Seqno, err)
+		}
+		fmt.Fprintf(w, "<iframe class=\"Rwidget\" title=\"%v\" sandbox=\"allow-scripts\" srcdoc=\"%v\"></iframe>\n", html.EscapeString(d.WidgetClass), html.EscapeString(string(by)))
+	}
+
+	for _, note := range ov.notes[e.Seqno] {
+		fmt.Fprintf(w, "<div class=\"RlaterNote\"><span class=\"RlaterNoteTm\">later note, %v:</span> %v</div>\n", note.elem.Tm.In(Chicago).Format(RFC3339MicroNumericTZ), html.EscapeString(note.note))
+	}
+	return nil
+}
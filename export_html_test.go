@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+// writeExportTestBook makes a book at path with one of each
+// kind of element, for the exporters to chew on. It returns
+// the png bytes of its one image.
+func writeExportTestBook(path string) (pngBy []byte) {
+	h, appendFD, err := ReadBook("tester", "testhost", path)
+	panicOn(err)
+	add := func(e *HashRElem) {
+		e.Tm = time.Now()
+		e.Seqno = len(h.elems)
+		h.mut.Lock()
+		h.appendElem(e)
+		h.mut.Unlock()
+		by, err := e.SaveToSlice()
+		panicOn(err)
+		_, err = appendFD.Write(by)
+		panicOn(err)
+	}
+	cmd := func(line int, code string) *HashRElem {
+		msg, n := prepCommandMessage(code, len(h.elems))
+		return &HashRElem{Typ: Command, CmdJSON: msg, BeginCommandLineNum: line, NumCommandLines: n}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	img.Set(2, 2, color.RGBA{G: 255, A: 255})
+	var buf bytes.Buffer
+	panicOn(png.Encode(&buf, img))
+	pngBy = buf.Bytes()
+
+	add(cmd(1, "x <- c(1,2,3)"))                                                             // 0
+	add(&HashRElem{Typ: Console, ConsoleJSON: prepConsoleMessage(`["## [1] 1 2 3"]`, 1)})    // 1
+	add(&HashRElem{Typ: Comment, CommentJSON: prepCommentMessage(`"#why x < y & z"`, 2)})    // 2
+	add(cmd(2, "print(letters)"))                                                            // 3
+	add(&HashRElem{Typ: Console, ConsoleJSON: prepConsoleMessage(`["## a b","## c d"]`, 4)}) // 4
+	add(cmd(2, "print(letters)"))                                                            // 5, a duplicate
+	add(cmd(3, "plot(x)"))                                                                   // 6
+	add(&HashRElem{Typ: Image, ImageHost: "testhost", ImagePath: "/tmp/p.png", ImageBy: pngBy, ImagePathHash: "ph", ImageJSON: prepImageMessage("/tmp/p.png", "ph", 7)})
+	add(&HashRElem{Typ: OverlayHideOutput, OverlayHideSeqno: 4, OverlayHideSeqnoJSON: prepOverlayHideOutput(8, 4)})
+	add(&HashRElem{Typ: OverlayLaterNote, OverlayNoteJSON: prepOverlayLaterNoteMessage("x was <fine>", 9, 0)})
+	panicOn(appendFD.Close())
+	return
+}
+
+func TestExportHTML(t *testing.T) {
+
+	cv.Convey("rbook -export-html should write one self-contained html file with the commands, output, comments, inline images, folded hidden outputs and later notes", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-export-html")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "my.rbook")
+		outPath := filepath.Join(dir, "out.html")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		pngBy := writeExportTestBook(path)
+
+		cfg := &RbookConfig{Out: outPath}
+		cv.So(cfg.exportHTML(path), cv.ShouldBeTrue)
+		page := string(mustReadFile(outPath))
+
+		cv.So(page, cv.ShouldContainSubstring, `x &lt;- c(1,2,3)`)
+		cv.So(page, cv.ShouldContainSubstring, `## [1] 1 2 3`)
+		cv.So(page, cv.ShouldContainSubstring, `### why x &lt; y &amp; z`)
+		cv.So(page, cv.ShouldContainSubstring, "data:image/png;base64,"+base64.StdEncoding.EncodeToString(pngBy))
+		cv.So(page, cv.ShouldContainSubstring, `<details class="RconsoleOutput"><summary>## a b <i>(1 more lines)</i></summary>`)
+		cv.So(page, cv.ShouldContainSubstring, `x was &lt;fine&gt;`)
+		cv.So(strings.Count(page, `print(letters)`), cv.ShouldEqual, 1)
+
+		// nothing fetched from a server.
+		cv.So(strings.Contains(page, "/rbook/"), cv.ShouldBeFalse)
+		cv.So(strings.Contains(page, "ws://"), cv.ShouldBeFalse)
+
+		// the note comes right after what it is on.
+		cv.So(strings.Index(page, "x was &lt;fine&gt;"), cv.ShouldBeGreaterThan, strings.Index(page, "x &lt;- c(1,2,3)"))
+		cv.So(strings.Index(page, "## [1] 1 2 3"), cv.ShouldBeGreaterThan, strings.Index(page, "x was &lt;fine&gt;"))
+
+		// without -o, beside the book.
+		cfg = &RbookConfig{}
+		cv.So(cfg.exportHTML(path), cv.ShouldBeTrue)
+		cv.So(FileExists(path+".html"), cv.ShouldBeTrue)
+	})
+}
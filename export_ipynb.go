@@ -0,0 +1,275 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// The parts of Jupyter's nbformat v4 that we write.
+// See https://nbformat.readthedocs.io/en/latest/format_description.html
+
+type ipynbNotebook struct {
+	Cells         []interface{}          `json:"cells"`
+	Metadata      map[string]interface{} `json:"metadata"`
+	NBFormat      int                    `json:"nbformat"`
+	NBFormatMinor int                    `json:"nbformat_minor"`
+}
+
+type ipynbMarkdownCell struct {
+	CellType string                 `json:"cell_type"`
+	ID       string                 `json:"id"`
+	Metadata map[string]interface{} `json:"metadata"`
+	Source   []string               `json:"source"`
+}
+
+type ipynbCodeCell struct {
+	CellType string `json:"cell_type"`
+	ID       string `json:"id"`
+
+	// nil for output with no command before it; null
+	// is how nbformat says a cell was never run.
+	ExecutionCount *int `json:"execution_count"`
+
+	Metadata map[string]interface{} `json:"metadata"`
+	Source   []string               `json:"source"`
+	Outputs  []*ipynbOutput         `json:"outputs"`
+}
+
+type ipynbOutput struct {
+	OutputType string                 `json:"output_type"`
+	Name       string                 `json:"name,omitempty"`
+	Text       []string               `json:"text,omitempty"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+
+	// for an error.
+	Ename     string   `json:"ename,omitempty"`
+	Evalue    string   `json:"evalue,omitempty"`
+	Traceback []string `json:"traceback,omitempty"`
+}
+
+// ipynbLines splits lines into nbformat's multi-line string
+// form: every line but the last ends in a newline.
+func ipynbLines(lines []string) []string {
+	res := make([]string, len(lines))
+	for i, line := range lines {
+		res[i] = line
+		if i < len(lines)-1 {
+			res[i] += "\n"
+		}
+	}
+	return res
+}
+
+// rbookCellMeta is what we keep of an element in cell metadata.
+func rbookCellMeta(e *HashRElem) map[string]interface{} {
+	return map[string]interface{}{
+		"rbook": map[string]interface{}{
+			"seqno": e.Seqno,
+			"tm":    e.Tm.Format(time.RFC3339Nano),
+		},
+	}
+}
+
+// exportIpynb implements rbook -export-ipynb: write the book at
+// bookpath as a Jupyter notebook for the IR kernel. Commands become
+// code cells, numbered by their command line; console output and
+// plots become the outputs of the code cell before them; comments
+// become markdown cells. Each cell's metadata keeps the seqno and
+// original timestamp.
+func (c *RbookConfig) exportIpynb(bookpath string) (ok bool) {
+	out := c.exportOutPath(bookpath, ".ipynb")
+
+	ov, err := scanOverlays(bookpath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -export-ipynb: could not read book '%v': '%v'\n", bookpath, err)
+		return false
+	}
+	r, err := OpenBookReader(bookpath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -export-ipynb: %v\n", err)
+		return false
+	}
+	defer r.Close()
+
+	book := r.Book
+	nb := &ipynbNotebook{
+		Metadata: map[string]interface{}{
+			"kernelspec": map[string]interface{}{
+				"display_name": "R",
+				"language":     "R",
+				"name":         "ir",
+			},
+			"language_info": map[string]interface{}{
+				"name": "R",
+			},
+			"rbook": map[string]interface{}{
+				"bookID":   book.BookID,
+				"user":     book.User,
+				"host":     book.Host,
+				"path":     book.Path,
+				"createTm": book.CreateTm.Format(time.RFC3339Nano),
+			},
+		},
+		NBFormat:      4,
+		NBFormatMinor: 5,
+	}
+
+	// the code cell that output goes to.
+	var code *ipynbCodeCell
+	codeCell := func(e *HashRElem, count *int, source []string) *ipynbCodeCell {
+		cell := &ipynbCodeCell{
+			CellType:       "code",
+			ID:             fmt.Sprintf("seqno-%v", e.Seqno),
+			ExecutionCount: count,
+			Metadata:       rbookCellMeta(e),
+			Source:         ipynbLines(source),
+			Outputs:        []*ipynbOutput{},
+		}
+		nb.Cells = append(nb.Cells, cell)
+		return cell
+	}
+	// notes go in their own markdown cell, after the
+	// cell with what they are on, and its outputs.
+	var notes []*exportNote
+	flushNotes := func() {
+		for _, note := range notes {
+			nb.Cells = append(nb.Cells, &ipynbMarkdownCell{
+				CellType: "markdown",
+				ID:       fmt.Sprintf("seqno-%v", note.elem.Seqno),
+				Metadata: rbookCellMeta(note.elem),
+				Source:   ipynbLines([]string{fmt.Sprintf("> *Later note, %v:* %v", note.elem.Tm.In(Chicago).Format(RFC3339MicroNumericTZ), note.note)}),
+			})
+		}
+		notes = nil
+	}
+
+	lastCommandLineNum := 0
+	for {
+		e, err := r.Next()
+		if err == io.EOF || err == TruncatedFrame {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rbook -export-ipynb: bad frame at offset %v: '%v'\n", r.Offset(), err)
+			return false
+		}
+		if e.BeginCommandLineNum > 0 {
+			if e.BeginCommandLineNum == lastCommandLineNum {
+				continue // a duplicate, as -dump skips.
+			}
+			lastCommandLineNum = e.BeginCommandLineNum
+		}
+		d, err := decodeElem(e)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rbook -export-ipynb: %v\n", err)
+			return false
+		}
+		if d == nil {
+			continue
+		}
+
+		switch e.Typ {
+		case Command:
+			flushNotes()
+			count := e.BeginCommandLineNum
+			code = codeCell(e, &count, d.Command)
+
+		case Console:
+			if ov.hidden[e.Seqno] {
+				break
+			}
+			if code == nil {
+				code = codeCell(e, nil, nil)
+			}
+			// as the IR kernel would show it: without our ## prefix.
+			text := make([]string, len(d.Console))
+			for i, line := range d.Console {
+				text[i] = strings.TrimPrefix(line, "## ") + "\n"
+			}
+			name := "stdout"
+			if d.Stream == "stderr" {
+				name = "stderr"
+			}
+			code.Outputs = append(code.Outputs, &ipynbOutput{
+				OutputType: "stream",
+				Name:       name,
+				Text:       text,
+			})
+
+		case Error:
+			flushNotes()
+			// never run, as far as the notebook knows.
+			code = codeCell(e, nil, d.ErrorCommand)
+			code.Outputs = append(code.Outputs, &ipynbOutput{
+				OutputType: "error",
+				Ename:      "Error",
+				Evalue:     strings.Join(d.Error, "\n"),
+				Traceback:  append(append([]string{}, d.Error...), tracebackLines(d.Traceback)...),
+			})
+
+		case Comment:
+			flushNotes()
+			code = nil
+			lines := make([]string, len(d.Comment))
+			for i, line := range d.Comment {
+				lines[i] = strings.TrimSpace(strings.TrimLeft(line, "#"))
+			}
+			nb.Cells = append(nb.Cells, &ipynbMarkdownCell{
+				CellType: "markdown",
+				ID:       fmt.Sprintf("seqno-%v", e.Seqno),
+				Metadata: rbookCellMeta(e),
+				Source:   ipynbLines(lines),
+			})
+
+		case Image:
+			by, err := book.imageBytes(e)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "rbook -export-ipynb: image for seqno %v: '%v'\n", e.Seqno, err)
+				return false
+			}
+			if code == nil {
+				code = codeCell(e, nil, nil)
+			}
+			meta := rbookCellMeta(e)
+			meta["rbook"].(map[string]interface{})["imagePath"] = e.ImagePath
+			code.Outputs = append(code.Outputs, &ipynbOutput{
+				OutputType: "display_data",
+				Data: map[string]interface{}{
+					"image/png":  base64.StdEncoding.EncodeToString(by),
+					"text/plain": []string{"plot without title"},
+				},
+				Metadata: meta,
+			})
+		}
+		notes = append(notes, ov.notes[e.Seqno]...)
+	}
+	flushNotes()
+
+	by, err := json.MarshalIndent(nb, "", " ")
+	panicOn(err)
+
+	fd, err := createAside(out)
+	if err == nil {
+		_, err = fd.Write(append(by, '\n'))
+		if err != nil {
+			fd.Close()
+			os.Remove(fd.Name())
+		} else {
+			err = finishAside(fd, out)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -export-ipynb: %v\n", err)
+		return false
+	}
+	fmt.Printf("rbook -export-ipynb: wrote %v cells of '%v' to '%v'\n", len(nb.Cells), bookpath, out)
+	return true
+}
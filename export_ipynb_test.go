@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestExportIpynb(t *testing.T) {
+
+	cv.Convey("rbook -export-ipynb should write an nbformat v4 notebook: code cells numbered by command line with stream and image/png outputs, markdown cells for comments, and timestamps in cell metadata", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-export-ipynb")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "my.rbook")
+		outPath := filepath.Join(dir, "out.ipynb")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		pngBy := writeExportTestBook(path)
+		book, err := loadBookReadOnly(path)
+		panicOn(err)
+
+		cfg := &RbookConfig{Out: outPath}
+		cv.So(cfg.exportIpynb(path), cv.ShouldBeTrue)
+
+		var nb struct {
+			Cells []struct {
+				CellType       string `json:"cell_type"`
+				ID             string `json:"id"`
+				ExecutionCount *int   `json:"execution_count"`
+				Metadata       struct {
+					Rbook struct {
+						Seqno int    `json:"seqno"`
+						Tm    string `json:"tm"`
+					} `json:"rbook"`
+				} `json:"metadata"`
+				Source  []string `json:"source"`
+				Outputs []struct {
+					OutputType string                 `json:"output_type"`
+					Name       string                 `json:"name"`
+					Text       []string               `json:"text"`
+					Data       map[string]interface{} `json:"data"`
+				} `json:"outputs"`
+			} `json:"cells"`
+			Metadata struct {
+				Kernelspec struct {
+					Name string `json:"name"`
+				} `json:"kernelspec"`
+			} `json:"metadata"`
+			NBFormat int `json:"nbformat"`
+		}
+		panicOn(json.Unmarshal(mustReadFile(outPath), &nb))
+		cv.So(nb.NBFormat, cv.ShouldEqual, 4)
+		cv.So(nb.Metadata.Kernelspec.Name, cv.ShouldEqual, "ir")
+
+		// x <- ..., [later note], # comment, print(letters), plot(x)
+		cv.So(len(nb.Cells), cv.ShouldEqual, 5)
+
+		c0 := nb.Cells[0]
+		cv.So(c0.CellType, cv.ShouldEqual, "code")
+		cv.So(*c0.ExecutionCount, cv.ShouldEqual, 1)
+		cv.So(c0.Source[0], cv.ShouldEqual, "x <- c(1,2,3)")
+		cv.So(c0.Outputs[0].OutputType, cv.ShouldEqual, "stream")
+		cv.So(c0.Outputs[0].Text[0], cv.ShouldEqual, "[1] 1 2 3\n")
+		cv.So(c0.Metadata.Rbook.Tm, cv.ShouldEqual, book.elems[0].Tm.Format("2006-01-02T15:04:05.999999999Z07:00"))
+
+		cv.So(nb.Cells[1].CellType, cv.ShouldEqual, "markdown")
+		cv.So(nb.Cells[1].Source[0], cv.ShouldContainSubstring, "x was <fine>")
+
+		cv.So(nb.Cells[2].CellType, cv.ShouldEqual, "markdown")
+		cv.So(nb.Cells[2].Source[0], cv.ShouldEqual, "why x < y & z")
+
+		// its output was hidden.
+		cv.So(*nb.Cells[3].ExecutionCount, cv.ShouldEqual, 2)
+		cv.So(len(nb.Cells[3].Outputs), cv.ShouldEqual, 0)
+
+		c4 := nb.Cells[4]
+		cv.So(*c4.ExecutionCount, cv.ShouldEqual, 3)
+		cv.So(c4.Outputs[0].OutputType, cv.ShouldEqual, "display_data")
+		cv.So(c4.Outputs[0].Data["image/png"], cv.ShouldEqual, base64.StdEncoding.EncodeToString(pngBy))
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// exportMarkdown implements rbook -export-rmd and -export-qmd: write
+// the book at bookpath as an R Markdown (or, if qmd, a Quarto)
+// document. Each command becomes a chunk, its console output the
+// chunk's output block (unless -export-no-output), comments become
+// prose, and plots are written to figures/ beside the document and
+// linked. The chunks are marked not to be evaluated, so knitting
+// shows the session as it was recorded.
+func (c *RbookConfig) exportMarkdown(bookpath string, qmd bool) (ok bool) {
+	tool, ext := "-export-rmd", ".Rmd"
+	if qmd {
+		tool, ext = "-export-qmd", ".qmd"
+	}
+	out := c.exportOutPath(bookpath, ext)
+	figdir := filepath.Join(filepath.Dir(out), "figures")
+
+	ov, err := scanOverlays(bookpath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook %v: could not read book '%v': '%v'\n", tool, bookpath, err)
+		return false
+	}
+	r, err := OpenBookReader(bookpath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook %v: %v\n", tool, err)
+		return false
+	}
+	defer r.Close()
+
+	fd, err := createAside(out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook %v: %v\n", tool, err)
+		return false
+	}
+	fail := func(err error) bool {
+		fmt.Fprintf(os.Stderr, "rbook %v: %v\n", tool, err)
+		fd.Close()
+		os.Remove(fd.Name())
+		return false
+	}
+	w := bufio.NewWriter(fd)
+	writeMarkdownHead(w, bookpath, r.Book, qmd)
+
+	lastCommandLineNum := 0
+	nchunk, nfig := 0, 0
+	for {
+		e, err := r.Next()
+		if err == io.EOF || err == TruncatedFrame {
+			break
+		}
+		if err != nil {
+			return fail(fmt.Errorf("bad frame at offset %v: '%v'", r.Offset(), err))
+		}
+		if e.BeginCommandLineNum > 0 {
+			if e.BeginCommandLineNum == lastCommandLineNum {
+				continue // a duplicate, as -dump skips.
+			}
+			lastCommandLineNum = e.BeginCommandLineNum
+		}
+		d, err := decodeElem(e)
+		if err != nil {
+			return fail(err)
+		}
+		if d == nil {
+			continue
+		}
+
+		switch e.Typ {
+		case Command:
+			// labelled by seqno, which unlike the line number is unique.
+			fmt.Fprintf(w, "```{r seqno-%v}\n", e.Seqno)
+			for _, line := range d.Command {
+				fmt.Fprintf(w, "%v\n", line)
+			}
+			fmt.Fprintf(w, "```\n\n")
+			nchunk++
+
+		case Console:
+			if c.ExportNoOutput || ov.hidden[e.Seqno] {
+				break
+			}
+			// as knitr shows output: prefixed with ##, as ours already is.
+			fmt.Fprintf(w, "```\n")
+			for _, line := range d.Console {
+				fmt.Fprintf(w, "%v\n", line)
+			}
+			fmt.Fprintf(w, "```\n\n")
+
+		case Error:
+			// not run again on knitting; shown as knitr shows an error.
+			fmt.Fprintf(w, "```{r seqno-%v, eval=FALSE}\n", e.Seqno)
+			for _, line := range d.ErrorCommand {
+				fmt.Fprintf(w, "%v\n", line)
+			}
+			fmt.Fprintf(w, "```\n\n```\n")
+			for _, line := range d.Error {
+				fmt.Fprintf(w, "## %v\n", line)
+			}
+			for _, line := range tracebackLines(d.Traceback) {
+				fmt.Fprintf(w, "## %v\n", line)
+			}
+			fmt.Fprintf(w, "```\n\n")
+			nchunk++
+
+		case Comment:
+			for _, line := range d.Comment {
+				fmt.Fprintf(w, "%v\n", strings.TrimSpace(strings.TrimLeft(line, "#")))
+			}
+			fmt.Fprintf(w, "\n")
+
+		case Image:
+			by, err := r.Book.imageBytes(e)
+			if err != nil {
+				return fail(fmt.Errorf("image for seqno %v: '%s'", e.Seqno, err))
+			}
+			name := fmt.Sprintf("fig-%04d.png", e.Seqno)
+			err = os.MkdirAll(figdir, 0777)
+			if err == nil {
+				err = ioutil.WriteFile(filepath.Join(figdir, name), by, 0660)
+			}
+			if err != nil {
+				return fail(err)
+			}
+			fmt.Fprintf(w, "![%v](figures/%v)\n\n", filepath.Base(e.ImagePath), name)
+			nfig++
+		}
+
+		for _, note := range ov.notes[e.Seqno] {
+			fmt.Fprintf(w, "> *Later note, %v:* %v\n\n", note.elem.Tm.In(Chicago).Format(RFC3339MicroNumericTZ), note.note)
+		}
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = finishAside(fd, out)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook %v: %v\n", tool, err)
+		return false
+	}
+	fmt.Printf("rbook %v: wrote %v chunks and %v figures of '%v' to '%v'\n", tool, nchunk, nfig, bookpath, out)
+	return true
+}
+
+func writeMarkdownHead(w io.Writer, bookpath string, book *HashRBook, qmd bool) {
+	created := book.CreateTm.In(Chicago).Format(RFC3339MicroNumericTZ)
+	fmt.Fprintf(w, "---\ntitle: %q\ndate: %q\n", "rbook "+filepath.Base(bookpath), created)
+	if qmd {
+		fmt.Fprintf(w, "format: html\nexecute:\n  eval: false\n---\n\n")
+	} else {
+		fmt.Fprintf(w, "output: html_document\n---\n\n")
+		fmt.Fprintf(w, "```{r setup, include=FALSE}\nknitr::opts_chunk$set(eval = FALSE)\n```\n\n")
+	}
+	fmt.Fprintf(w, "<!-- %v@%v:%v BookID:%v -->\n\n", book.User, book.Host, book.Path, book.BookID)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestExportMarkdown(t *testing.T) {
+
+	cv.Convey("rbook -export-rmd and -export-qmd should write a chunk per command, output blocks, comments as prose, and plots into figures/", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-export-rmd")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "my.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		pngBy := writeExportTestBook(path)
+
+		outDir := filepath.Join(dir, "paper")
+		panicOn(os.Mkdir(outDir, 0777))
+		rmdPath := filepath.Join(outDir, "session.Rmd")
+		cfg := &RbookConfig{Out: rmdPath}
+		cv.So(cfg.exportMarkdown(path, false), cv.ShouldBeTrue)
+		doc := string(mustReadFile(rmdPath))
+
+		cv.So(doc, cv.ShouldContainSubstring, "knitr::opts_chunk$set(eval = FALSE)")
+		cv.So(doc, cv.ShouldContainSubstring, "```{r seqno-0}\nx <- c(1,2,3)\n```\n")
+		cv.So(doc, cv.ShouldContainSubstring, "```\n## [1] 1 2 3\n```\n")
+		cv.So(doc, cv.ShouldContainSubstring, "\nwhy x < y & z\n")
+		cv.So(doc, cv.ShouldContainSubstring, "![p.png](figures/fig-0007.png)")
+		cv.So(doc, cv.ShouldContainSubstring, "x was <fine>")
+		cv.So(strings.Count(doc, "print(letters)"), cv.ShouldEqual, 1)
+
+		// seqno 4 was hidden by an overlay.
+		cv.So(strings.Contains(doc, "## a b"), cv.ShouldBeFalse)
+
+		fig := mustReadFile(filepath.Join(outDir, "figures", "fig-0007.png"))
+		cv.So(bytes.Equal(fig, pngBy), cv.ShouldBeTrue)
+
+		qmdPath := filepath.Join(outDir, "session.qmd")
+		cfg = &RbookConfig{Out: qmdPath, ExportNoOutput: true}
+		cv.So(cfg.exportMarkdown(path, true), cv.ShouldBeTrue)
+		doc = string(mustReadFile(qmdPath))
+		cv.So(doc, cv.ShouldContainSubstring, "execute:\n  eval: false\n")
+		cv.So(doc, cv.ShouldContainSubstring, "```{r seqno-6}\nplot(x)\n```\n")
+		cv.So(strings.Contains(doc, "## [1] 1 2 3"), cv.ShouldBeFalse)
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// elemFilter picks out elements by time, seqno, and type, for
+// rbook -extract, -dump, and -dumpts. The zero value takes all.
+type elemFilter struct {
+	since time.Time // at or after, if set.
+	until time.Time // before, if set.
+
+	// seqnos from seqLo through seqHi, if hasSeqno.
+	hasSeqno     bool
+	seqLo, seqHi int
+
+	// the types to take, as a mask; 0 means all.
+	types HashRTyp
+}
+
+// filterTypeNames are the names -types takes.
+var filterTypeNames = map[string]HashRTyp{
+	"command": Command,
+	"console": Console,
+	"image":   Image,
+	"comment": Comment,
+	"note":    OverlayLaterNote,
+	"hide":    OverlayHideOutput,
+	"error":   Error,
+	"table":   Table,
+	"widget":  Widget,
+}
+
+// filterTimeLayouts are the forms -since and -until take. Those
+// without a zone are in Chicago time, as -dumpts shows them.
+var filterTimeLayouts = []string{
+	time.RFC3339Nano,
+	RFC3339MicroNumericTZ,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func parseFilterTime(s string) (time.Time, error) {
+	for _, layout := range filterTimeLayouts {
+		tm, err := time.ParseInLocation(layout, s, Chicago)
+		if err == nil {
+			return tm, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("could not read time '%v'; try 2023-09-12 15:04 or 2023-09-12T15:04:05-05:00", s)
+}
+
+// newElemFilter makes the filter for the -since, -until,
+// -seqno, and -types flags. It is nil if none were given.
+func newElemFilter(since, until, seqno, types string) (f *elemFilter, err error) {
+	if since == "" && until == "" && seqno == "" && types == "" {
+		return nil, nil
+	}
+	f = &elemFilter{}
+	if since != "" {
+		f.since, err = parseFilterTime(since)
+		if err != nil {
+			return nil, fmt.Errorf("-since: %v", err)
+		}
+	}
+	if until != "" {
+		f.until, err = parseFilterTime(until)
+		if err != nil {
+			return nil, fmt.Errorf("-until: %v", err)
+		}
+	}
+	if seqno != "" {
+		// a:b as in R, both ends included; either end may be left off.
+		lo, hi := seqno, seqno
+		if i := strings.IndexByte(seqno, ':'); i >= 0 {
+			lo, hi = seqno[:i], seqno[i+1:]
+		}
+		f.hasSeqno = true
+		f.seqLo, f.seqHi = 0, int(^uint(0)>>1)
+		if lo != "" {
+			f.seqLo, err = strconv.Atoi(lo)
+		}
+		if err == nil && hi != "" {
+			f.seqHi, err = strconv.Atoi(hi)
+		}
+		if err != nil || f.seqLo > f.seqHi {
+			return nil, fmt.Errorf("-seqno: want a range like 10:20, 10:, or :20; not '%v'", seqno)
+		}
+	}
+	if types != "" {
+		for _, name := range strings.Split(types, ",") {
+			ty, ok := filterTypeNames[strings.ToLower(strings.TrimSpace(name))]
+			if !ok {
+				return nil, fmt.Errorf("-types: unknown type '%v'; want some of command,console,comment,image,note,hide,error,table,widget", name)
+			}
+			f.types |= ty
+		}
+	}
+	return f, nil
+}
+
+// match is true if f takes e. A nil f takes everything.
+func (f *elemFilter) match(e *HashRElem) bool {
+	switch {
+	case f == nil:
+		return true
+	case !f.since.IsZero() && e.Tm.Before(f.since):
+		return false
+	case !f.until.IsZero() && !e.Tm.Before(f.until):
+		return false
+	case f.hasSeqno && (e.Seqno < f.seqLo || e.Seqno > f.seqHi):
+		return false
+	case f.types != 0 && f.types&e.Typ == 0:
+		return false
+	}
+	return true
+}
+
+// extractBook implements rbook -extract: write the elements of the
+// book at path that the filter takes to a new book at c.Out.
+// The new book has its own BookID, with ParentBookID naming the book
+// it came from. Seqnos are renumbered from 0 and the hash chain is
+// fresh, but command line numbers are kept, so they still match the
+// parent. Overlays on elements left out are left out too.
+func (c *RbookConfig) extractBook(path string) (ok bool) {
+	in, err := loadBookReadOnly(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -extract: could not read book '%v': '%v'\n", path, err)
+		return false
+	}
+	if in.FormatVersion > BookFormatVersion {
+		// it may hold seqnos we do not know how to renumber.
+		fmt.Fprintf(os.Stderr, "rbook -extract: '%v' is format version %v, newer than this rbook knows (%v). Use a newer rbook.\n", path, in.FormatVersion, BookFormatVersion)
+		return false
+	}
+	if FileExists(c.Out) {
+		fmt.Fprintf(os.Stderr, "rbook -extract: refusing to overwrite existing '%v'\n", c.Out)
+		return false
+	}
+
+	out := NewHashRBook(in.User, in.Host, c.Out)
+	out.ParentBookID = in.BookID
+	out.chainHead = out.chainAnchor()
+
+	old2new := make(map[int]int)
+	lastCommandLineNum := 0
+	for _, e := range in.elems {
+		if !c.filter.match(e) {
+			continue
+		}
+		if e.Typ == Command && e.BeginCommandLineNum > 0 {
+			if e.BeginCommandLineNum == lastCommandLineNum {
+				continue // a duplicate, as -dump skips.
+			}
+			lastCommandLineNum = e.BeginCommandLineNum
+		}
+		ne, kept := reseqElem(e, len(out.elems), old2new)
+		if !kept {
+			continue
+		}
+		if ne.Typ == Image {
+			by, err := in.imageBytes(e)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "rbook -extract: image for seqno %v: '%v'\n", e.Seqno, err)
+				return false
+			}
+			if ne.ImageHash != "" {
+				ne.ImageHash, err = out.blobs.put(by)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "rbook -extract: %v\n", err)
+					return false
+				}
+			} else {
+				ne.ImageBy = by
+			}
+		}
+		out.appendElem(ne)
+	}
+	fd := out.DeletePathAndReSaveFullBook(c.Out)
+	panicOn(fd.Close())
+
+	fmt.Printf("rbook -extract: wrote '%v' (BookID %v, from %v): %v of %v elements.\n", c.Out, out.BookID, in.BookID, len(out.elems), len(in.elems))
+	return true
+}
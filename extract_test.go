@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestExtract(t *testing.T) {
+
+	cv.Convey("rbook -extract should write a new book of just the elements picked out by type, seqno, or time, naming its parent; and -dump should take the same filters", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-extract")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "my.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		writeExportTestBook(path)
+		parent, err := loadBookReadOnly(path)
+		panicOn(err)
+
+		extract := func(out string, f *elemFilter) *HashRBook {
+			cfg := &RbookConfig{Out: out, filter: f}
+			cv.So(cfg.extractBook(path), cv.ShouldBeTrue)
+			res, ok := verifyFrames(out)
+			cv.So(ok, cv.ShouldBeTrue)
+			cv.So(res.checked, cv.ShouldEqual, res.nelem)
+			h, err := loadBookReadOnly(out)
+			panicOn(err)
+			cv.So(h.ParentBookID, cv.ShouldEqual, parent.BookID)
+			cv.So(h.BookID, cv.ShouldNotEqual, parent.BookID)
+			for i, e := range h.elems {
+				cv.So(e.Seqno, cv.ShouldEqual, i)
+			}
+			return h
+		}
+
+		f, err := newElemFilter("", "", "", "command,image")
+		panicOn(err)
+		h := extract(filepath.Join(dir, "figs.rbook"), f)
+		cv.So(len(h.elems), cv.ShouldEqual, 4)
+		cv.So(h.elems[3].Typ, cv.ShouldEqual, Image)
+		cv.So(len(h.elems[3].ImageBy), cv.ShouldBeGreaterThan, 0)
+		// command lines still match the parent's.
+		cv.So(h.elems[2].BeginCommandLineNum, cv.ShouldEqual, 3)
+
+		// seqno 9, the note, is on seqno 0; so it goes.
+		// seqno 8 hides seqno 4, which stays.
+		f, err = newElemFilter("", "", "3:9", "")
+		panicOn(err)
+		h = extract(filepath.Join(dir, "seq.rbook"), f)
+		cv.So(len(h.elems), cv.ShouldEqual, 5)
+		cv.So(h.elems[4].Typ, cv.ShouldEqual, OverlayHideOutput)
+		cv.So(h.elems[4].OverlayHideSeqno, cv.ShouldEqual, 1)
+		d, err := decodeElem(h.elems[1])
+		panicOn(err)
+		cv.So(d.Console, cv.ShouldResemble, []string{"## a b", "## c d"})
+
+		// by time.
+		t0 := time.Date(2023, 9, 12, 13, 0, 0, 0, Chicago)
+		at := func(min int) time.Time { return t0.Add(time.Duration(min) * time.Minute) }
+		var elems []*HashRElem
+		for i := 0; i < 6; i++ {
+			msg, n := prepCommandMessage("x <- "+strings.Repeat("1", i+1), i)
+			elems = append(elems, &HashRElem{Typ: Command, Tm: at(i * 10), CmdJSON: msg, BeginCommandLineNum: i + 1, NumCommandLines: n})
+		}
+		tmPath := filepath.Join(dir, "tm.rbook")
+		writeHostBook(tmPath, "rog", elems)
+
+		f, err = newElemFilter("2023-09-12 13:10", "2023-09-12T13:40:00-05:00", "", "")
+		panicOn(err)
+		cfg := &RbookConfig{Out: filepath.Join(dir, "afternoon.rbook"), filter: f}
+		cv.So(cfg.extractBook(tmPath), cv.ShouldBeTrue)
+		h, err = loadBookReadOnly(cfg.Out)
+		panicOn(err)
+		cv.So(len(h.elems), cv.ShouldEqual, 3)
+		cv.So(h.elems[0].Tm.Equal(at(10)), cv.ShouldBeTrue)
+		cv.So(h.elems[2].Tm.Equal(at(30)), cv.ShouldBeTrue)
+
+		// the same filter on -dump.
+		fd, err := ioutil.TempFile(dir, "dump")
+		panicOn(err)
+		cfg = &RbookConfig{filter: f}
+		cv.So(cfg.dumpBook(fd, tmPath), cv.ShouldBeTrue)
+		panicOn(fd.Close())
+		dump := string(mustReadFile(fd.Name()))
+		cv.So(dump, cv.ShouldContainSubstring, "\nx <- 11\n")
+		cv.So(dump, cv.ShouldContainSubstring, "\nx <- 1111\n")
+		cv.So(strings.Contains(dump, "\nx <- 1\n"), cv.ShouldBeFalse)
+		cv.So(strings.Contains(dump, "\nx <- 11111\n"), cv.ShouldBeFalse)
+
+		_, err = newElemFilter("", "", "9:3", "")
+		cv.So(err, cv.ShouldNotBeNil)
+		_, err = newElemFilter("", "", "", "command,plots")
+		cv.So(err, cv.ShouldNotBeNil)
+		_, err = newElemFilter("yesterday", "", "", "")
+		cv.So(err, cv.ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,297 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/glycerine/greenpack/msgp"
+)
+
+// BookFormatVersion is the on-disk format this rbook writes,
+// recorded in HashRBook.FormatVersion. The history:
+//
+//	0: no FormatVersion in the header. Books from before the
+//	   hash chain; elements may lack Checksum and PrevHash.
+//	   (Some have Checksum but not PrevHash; a few have both.)
+//	1: FormatVersion in the header; every element has its
+//	   Checksum and PrevHash. Images may be in the -blobs store.
+//	2: Error elements, for a command that failed.
+//	3: Table elements, the values of a printed data.frame.
+//	4: Widget elements, the pages of printed htmlwidgets.
+//	5: the header Checksum at zid 8, rather than zid 5, which
+//	   was also the zid of the (unserialized) elems.
+//
+// New fields get new, higher, zids; an older rbook skips fields
+// it does not know on reading, and keeps them (see unknownFields)
+// so that writing the book back out does not lose them. Likewise
+// for element types it does not know. Bump BookFormatVersion
+// when a book would need -upgrade to use a change.
+const BookFormatVersion = 5
+
+// chainFormatVersion is the first BookFormatVersion whose books
+// have a Checksum on the header and on every element, and a
+// PrevHash on every element. Only older books may lack them.
+const chainFormatVersion = 1
+
+// unknownFields holds the map entries of a HashRElem or HashRBook
+// that were written by a newer rbook, and that we do not know.
+// We write them back out after our own fields, unchanged. Since
+// greenpack writes fields in zid order, and new fields get
+// higher zids, that gives back exactly the bytes the newer
+// rbook wrote; so its Checksum still matches.
+type unknownFields struct {
+	n   int
+	raw []byte
+}
+
+// keep finds and keeps the entries of the msgpack map body
+// whose zid is not among the first nfield; those are the
+// fields of a newer rbook.
+func (u *unknownFields) keep(body []byte, nfield int) error {
+	u.n = 0
+	u.raw = nil
+	var nbs msgp.NilBitsStack
+	sz, rest, err := nbs.ReadMapHeaderBytes(body)
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < sz; i++ {
+		beg := rest
+		key, after, err := nbs.ReadStringBytes(rest)
+		if err != nil {
+			return err
+		}
+		rest, err = msgp.Skip(after)
+		if err != nil {
+			return err
+		}
+		if zid, ok := zidOf(key); !ok || zid >= nfield {
+			u.raw = append(u.raw, beg[:len(beg)-len(rest)]...)
+			u.n++
+		}
+	}
+	return nil
+}
+
+// zidOf returns the NN of a greenpack key "name_zidNN_kind".
+func zidOf(key string) (zid int, ok bool) {
+	i := strings.LastIndex(key, "_zid")
+	if i < 0 {
+		return 0, false
+	}
+	digits := key[i+4:]
+	if j := strings.IndexByte(digits, '_'); j >= 0 {
+		digits = digits[:j]
+	}
+	zid, err := strconv.Atoi(digits)
+	return zid, err == nil
+}
+
+// appendTo adds the kept entries to the end of the msgpack map body.
+func (u *unknownFields) appendTo(body []byte) ([]byte, error) {
+	if u.n == 0 {
+		return body, nil
+	}
+	var nbs msgp.NilBitsStack
+	sz, rest, err := nbs.ReadMapHeaderBytes(body)
+	if err != nil {
+		return nil, err
+	}
+	out := msgp.AppendMapHeader(make([]byte, 0, len(body)+len(u.raw)+4), sz+uint32(u.n))
+	out = append(out, rest...)
+	return append(out, u.raw...), nil
+}
+
+// marshalAll is MarshalMsg plus any unknown fields we kept.
+func (e *HashRElem) marshalAll() ([]byte, error) {
+	b, err := e.MarshalMsg(nil)
+	if err != nil {
+		return nil, err
+	}
+	return e.unknown.appendTo(b)
+}
+
+// marshalAll is MarshalMsg plus any unknown fields we kept. A
+// header from before format version 5 keeps its Checksum at the
+// old zid 5, so that the book's bytes, and its checksums, stay
+// as they were until -upgrade.
+func (book *HashRBook) marshalAll() ([]byte, error) {
+	if book.FormatVersion >= 5 || book.Checksum == "" {
+		b, err := book.MarshalMsg(nil)
+		if err != nil {
+			return nil, err
+		}
+		return book.unknown.appendTo(b)
+	}
+	sum := book.Checksum
+	book.Checksum = ""
+	b, err := book.MarshalMsg(nil)
+	book.Checksum = sum
+	if err != nil {
+		return nil, err
+	}
+	b, err = insertField(b, 5, msgp.AppendString(msgp.AppendString(nil, "checksum_zid05_str"), sum))
+	if err != nil {
+		return nil, err
+	}
+	return book.unknown.appendTo(b)
+}
+
+// insertField adds entry, a key and its value, to the msgpack map
+// body as the field at zid: before the first field of a higher zid.
+func insertField(body []byte, zid int, entry []byte) ([]byte, error) {
+	var nbs msgp.NilBitsStack
+	sz, rest, err := nbs.ReadMapHeaderBytes(body)
+	if err != nil {
+		return nil, err
+	}
+	fields := body[len(body)-len(rest):]
+	at := len(fields)
+	for i := uint32(0); i < sz; i++ {
+		key, after, err := nbs.ReadStringBytes(rest)
+		if err != nil {
+			return nil, err
+		}
+		if z, ok := zidOf(key); ok && z > zid {
+			at = len(fields) - len(rest)
+			break
+		}
+		rest, err = msgp.Skip(after)
+		if err != nil {
+			return nil, err
+		}
+	}
+	out := msgp.AppendMapHeader(make([]byte, 0, len(body)+len(entry)+4), sz+1)
+	out = append(out, fields[:at]...)
+	out = append(out, entry...)
+	return append(out, fields[at:]...), nil
+}
+
+// keepUnknown keeps the fields of body, the msgpack e was just
+// unmarshalled from, that we do not know. fieldsNotEmpty(nil)
+// gives how many fields we do know; their zids run from 0 up.
+func (e *HashRElem) keepUnknown(body []byte) error {
+	return e.unknown.keep(body, int(e.fieldsNotEmpty(nil)))
+}
+
+// keepUnknown is as for HashRElem, but zid 5 of the header is
+// retired: it was the Checksum's before format version 5, so our
+// zids run one past our fields. A Checksum at zid 5 we read as
+// our own, and marshalAll puts it back there.
+func (book *HashRBook) keepUnknown(body []byte) error {
+	err := book.unknown.keep(body, int(book.fieldsNotEmpty(nil))+1)
+	if err != nil || book.Checksum != "" {
+		return err
+	}
+	book.Checksum, err = stringField(body, "checksum_zid05_str")
+	return err
+}
+
+// stringField returns the string at key in the msgpack map body;
+// "" if there is none.
+func stringField(body []byte, key string) (string, error) {
+	var nbs msgp.NilBitsStack
+	sz, rest, err := nbs.ReadMapHeaderBytes(body)
+	if err != nil {
+		return "", err
+	}
+	for i := uint32(0); i < sz; i++ {
+		k, after, err := nbs.ReadStringBytes(rest)
+		if err != nil {
+			return "", err
+		}
+		if k == key {
+			v, _, err := nbs.ReadStringBytes(after)
+			return v, err
+		}
+		rest, err = msgp.Skip(after)
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// knownTyp is true for the element types this rbook knows.
+func knownTyp(ty HashRTyp) bool {
+	switch ty {
+	case Command, Console, Image, Comment, OverlayLaterNote, OverlayHideOutput,
+		Error, Table, Widget:
+		return true
+	}
+	return false
+}
+
+// upgradeBook implements rbook -upgrade: rewrite the book at path in
+// the current format. Every element gets its Checksum and PrevHash,
+// and the header its FormatVersion; seqnos and BookID are kept. The
+// original is kept beside it as <book>.pre-upgrade.
+func (c *RbookConfig) upgradeBook(path string) (ok bool) {
+
+	// don't rewrite a book out from under a running session.
+	udlock, err := NewUDLock(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -upgrade: '%v' is in use: '%v'\n", path, err)
+		return false
+	}
+	defer udlock.Close()
+
+	in, err := loadBookReadOnly(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -upgrade: could not read book '%v': '%v'\n", path, err)
+		return false
+	}
+	if in.FormatVersion > BookFormatVersion {
+		fmt.Fprintf(os.Stderr, "rbook -upgrade: '%v' is format version %v, newer than this rbook knows (%v). Use a newer rbook.\n", path, in.FormatVersion, BookFormatVersion)
+		return false
+	}
+	if in.FormatVersion == BookFormatVersion {
+		fmt.Printf("rbook -upgrade: '%v' is already at format version %v.\n", path, BookFormatVersion)
+		return true
+	}
+	fromVersion := in.FormatVersion
+
+	in.FormatVersion = BookFormatVersion
+	in.chainHead = in.chainAnchor()
+	elems := in.elems
+	in.elems = nil
+	in.path2image = make(map[string]*HashRElem)
+	for _, e := range elems {
+		e.Checksum = ""
+		e.PrevHash = ""
+		in.appendElem(e)
+	}
+
+	tmp := path + ".upgrade.tmp"
+	fd, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0660)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -upgrade: %v\n", err)
+		return false
+	}
+	by, err := in.SaveToSlice()
+	panicOn(err)
+	_, err = fd.Write(by)
+	panicOn(err)
+	for _, e := range in.elems {
+		by, err := e.SaveToSlice()
+		panicOn(err)
+		_, err = fd.Write(by)
+		panicOn(err)
+	}
+	panicOn(fd.Sync())
+	panicOn(fd.Close())
+
+	backup := path + ".pre-upgrade"
+	panicOn(os.Rename(path, backup))
+	panicOn(os.Rename(tmp, path))
+
+	// offsets changed; have the next ReadBook rebuild the index.
+	os.Remove(indexPathFor(path))
+
+	fmt.Printf("rbook -upgrade: '%v' (BookID %v) upgraded from format version %v to %v; %v elements. The original is kept as '%v'.\n", path, in.BookID, fromVersion, BookFormatVersion, len(in.elems), backup)
+	return true
+}
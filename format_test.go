@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	"github.com/glycerine/greenpack/msgp"
+)
+
+// The testdata/book-*.rbook files hold the same seven elements
+// (a command, its console output, a comment, an image, another
+// command, a later note, and a hide) as written by each historical
+// rbook:
+//
+//	book-format0-nochecksum.rbook  before per-frame checksums
+//	book-format0-checksum.rbook    checksums, but no PrevHash chain
+//	book-format0-chained.rbook     chained, but no FormatVersion
+//	book-format1.rbook             BookFormatVersion 1
+//	book-format2.rbook             2, Error elements known
+//	book-format3.rbook             3, Table elements known
+//	book-format4.rbook             4, Widget elements known
+//	book-format5.rbook             5, header Checksum at zid 8
+//
+// testdata/book.dump is what -dump says about every one of them.
+var goldenBooks = []string{
+	"book-format0-nochecksum.rbook",
+	"book-format0-checksum.rbook",
+	"book-format0-chained.rbook",
+	"book-format1.rbook",
+	"book-format2.rbook",
+	"book-format3.rbook",
+	"book-format4.rbook",
+	"book-format5.rbook",
+}
+
+// dumpToString returns the -dump of the book at path, as if
+// written by jaten on host rog, as the golden books were. The
+// dump names the book, so we dump it from its own directory.
+func dumpToString(path string) string {
+	saveUser, saveHost := username, hostname
+	username, hostname = "jaten", "rog"
+	defer func() { username, hostname = saveUser, saveHost }()
+
+	cwd, err := os.Getwd()
+	panicOn(err)
+	panicOn(os.Chdir(filepath.Dir(path)))
+	defer os.Chdir(cwd)
+
+	fd, err := ioutil.TempFile("", "rbook-dump")
+	panicOn(err)
+	defer os.Remove(fd.Name())
+	cfg := &RbookConfig{}
+	if !cfg.dumpBook(fd, filepath.Base(path)) {
+		panic("dumpBook failed on " + path)
+	}
+	panicOn(fd.Close())
+	return string(mustReadFile(fd.Name()))
+}
+
+func TestGoldenFormats(t *testing.T) {
+
+	cv.Convey("every historical book format should load, dump the same as testdata/book.dump, and -upgrade to exactly the current format", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-format")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		golden := string(mustReadFile(filepath.Join(cwd, "testdata", "book.dump")))
+		current := mustReadFile(filepath.Join(cwd, "testdata", goldenBooks[len(goldenBooks)-1]))
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		cfg := &RbookConfig{}
+		for i, name := range goldenBooks {
+			sub := filepath.Join(dir, name+".d")
+			panicOn(os.Mkdir(sub, 0777))
+			path := filepath.Join(sub, "my.rbook.rog")
+			orig := mustReadFile(filepath.Join(cwd, "testdata", name))
+			panicOn(ioutil.WriteFile(path, orig, 0660))
+
+			h, err := loadBookReadOnly(path)
+			panicOn(err)
+			cv.So(h.BookID, cv.ShouldEqual, "GoldenBookID0123456789ab")
+			cv.So(len(h.elems), cv.ShouldEqual, 7)
+			cv.So(h.elems[3].Typ, cv.ShouldEqual, Image)
+			cv.So(len(h.elems[3].ImageBy), cv.ShouldBeGreaterThan, 0)
+			cv.So(h.elems[6].OverlayHideSeqno, cv.ShouldEqual, 1)
+
+			last := i == len(goldenBooks)-1
+			cv.So(h.FormatVersion == BookFormatVersion, cv.ShouldEqual, last)
+			cv.So(dumpToString(path), cv.ShouldEqual, golden)
+
+			if name != "book-format0-nochecksum.rbook" {
+				cv.So(cfg.verifyBook(path), cv.ShouldBeTrue)
+			}
+
+			cv.So(cfg.upgradeBook(path), cv.ShouldBeTrue)
+			cv.So(bytes.Equal(mustReadFile(path), current), cv.ShouldBeTrue)
+			cv.So(cfg.verifyBook(path), cv.ShouldBeTrue)
+			if last {
+				// already current: left alone.
+				cv.So(FileExists(path+".pre-upgrade"), cv.ShouldBeFalse)
+			} else {
+				cv.So(bytes.Equal(mustReadFile(path+".pre-upgrade"), orig), cv.ShouldBeTrue)
+			}
+		}
+	})
+}
+
+func TestFutureFormat(t *testing.T) {
+
+	cv.Convey("a book from a newer rbook, with an unknown element type and unknown fields, should load and verify, skip what it cannot show, keep the unknowns byte for byte on rewrite, and refuse -upgrade", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-future")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "future.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		// as a newer rbook would write them: a header field
+		// and an element field we do not know, at new zids.
+		newField := func(key, val string) unknownFields {
+			var u unknownFields
+			u.raw = msgp.AppendString(msgp.AppendString(nil, key), val)
+			u.n = 1
+			return u
+		}
+
+		h := NewHashRBook("tester", "testhost", path)
+		h.FormatVersion = BookFormatVersion + 1
+		h.unknown = newField("kernel_zid09_str", "julia")
+		h.chainHead = h.chainAnchor()
+
+		msg, n := prepCommandMessage("x <- 1", 0)
+		elems := []*HashRElem{
+			{Typ: Command, Seqno: 0, CmdJSON: msg, BeginCommandLineNum: 1, NumCommandLines: n},
+			{Typ: HashRTyp(1 << 20), Seqno: 1, unknown: newField("widgetJSON_zid40_str", `{"w":1}`)},
+			{Typ: Console, Seqno: 2, ConsoleJSON: prepConsoleMessage(`["## [1] 1"]`, 2), unknown: newField("mime_zid41_str", "text/plain")},
+		}
+		var buf bytes.Buffer
+		by, err := h.SaveToSlice()
+		panicOn(err)
+		buf.Write(by)
+		for _, e := range elems {
+			e.Tm = time.Now()
+			h.appendElem(e)
+			by, err := e.SaveToSlice()
+			panicOn(err)
+			buf.Write(by)
+		}
+		orig := buf.Bytes()
+		panicOn(ioutil.WriteFile(path, orig, 0660))
+
+		cfg := &RbookConfig{}
+		cv.So(cfg.verifyBook(path), cv.ShouldBeTrue)
+		cv.So(HashRTyp(1<<20).String(), cv.ShouldEqual, "HashRTyp(1048576)")
+
+		got, appendFD, err := ReadBook("tester", "testhost", path)
+		panicOn(err)
+		appendFD.Close()
+		cv.So(got.FormatVersion, cv.ShouldEqual, BookFormatVersion+1)
+		cv.So(len(got.elems), cv.ShouldEqual, 3)
+		cv.So(got.elems[1].Typ, cv.ShouldEqual, HashRTyp(1<<20))
+		cv.So(len(got.elems[1].msg), cv.ShouldEqual, 0)
+		cv.So(got.elems[2].unknown.n, cv.ShouldEqual, 1)
+
+		// nothing to show for the unknown type.
+		pg := got.pageBefore(-1, 0)
+		cv.So(len(pg.msgs), cv.ShouldEqual, 2)
+
+		// rewriting keeps every unknown byte, so checksums still match.
+		appendFD = got.DeletePathAndReSaveFullBook(path)
+		appendFD.Close()
+		cv.So(bytes.Equal(mustReadFile(path), orig), cv.ShouldBeTrue)
+		cv.So(cfg.verifyBook(path), cv.ShouldBeTrue)
+
+		cv.So(cfg.upgradeBook(path), cv.ShouldBeFalse)
+		cv.So(bytes.Equal(mustReadFile(path), orig), cv.ShouldBeTrue)
+	})
+}
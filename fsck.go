@@ -0,0 +1,124 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/glycerine/greenpack/msgp"
+)
+
+// countingReader counts the bytes read through it, so
+// we can know where in the file each frame begins.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.n += int64(n)
+	return
+}
+
+// bookFrameScanner reads the frames of a book in order,
+// while tracking the byte offset of the next unread frame.
+type bookFrameScanner struct {
+	cr  *countingReader
+	mpr *msgp.Reader
+}
+
+func newBookFrameScanner(r io.Reader) *bookFrameScanner {
+	cr := &countingReader{r: r}
+	return &bookFrameScanner{
+		cr:  cr,
+		mpr: msgp.NewReader(cr),
+	}
+}
+
+// offset of the next frame, from the start of the file.
+// The msgp.Reader reads ahead, so discount what it has buffered.
+func (s *bookFrameScanner) offset() int64 {
+	return s.cr.n - int64(s.mpr.Buffered())
+}
+
+// repairTornTail handles a book whose last frame was only partially
+// written, as happens when rbook dies in the middle of an append.
+// The bytes from goodOffset to the end of the file are copied aside
+// to path.torn (or path.torn.1, path.torn.2, ...) and then the
+// book is truncated back to goodOffset, the end of the last whole frame,
+// so that appending can continue.
+func repairTornTail(appendFD *os.File, path string, goodOffset, sz int64) (err error) {
+
+	tornPath := path + ".torn"
+	for i := 1; FileExists(tornPath); i++ {
+		tornPath = fmt.Sprintf("%v.torn.%v", path, i)
+	}
+
+	torn := make([]byte, sz-goodOffset)
+	_, err = appendFD.ReadAt(torn, goodOffset)
+	if err != nil {
+		return fmt.Errorf("repairTornTail() could not read torn bytes from '%v': '%v'", path, err)
+	}
+	err = ioutil.WriteFile(tornPath, torn, 0660)
+	if err != nil {
+		return fmt.Errorf("repairTornTail() could not save torn bytes to '%v': '%v'", tornPath, err)
+	}
+
+	err = appendFD.Truncate(goodOffset)
+	if err != nil {
+		return fmt.Errorf("repairTornTail() could not truncate '%v' to %v bytes: '%v'", path, goodOffset, err)
+	}
+	err = appendFD.Sync()
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("rbook: book '%v' had a torn last record (crash during write?). Truncated from %v back to %v bytes; the %v discarded bytes were saved in '%v'.", path, sz, goodOffset, sz-goodOffset, tornPath)
+	fmt.Fprintf(os.Stderr, "%v\n", msg)
+	vvlog(msg)
+	return nil
+}
+
+// fsckBook checks every frame in the book at path, and prints
+// the offset of each. It never modifies the book. Returns
+// true if the book is whole.
+func (c *RbookConfig) fsckBook(path string) (ok bool) {
+
+	r, err := OpenBookReader(path)
+	if err != nil {
+		fmt.Printf("BAD: %v\n", err)
+		return false
+	}
+	defer r.Close()
+
+	sz, err := FileSize(path)
+	panicOn(err)
+
+	h := r.Book
+	fmt.Printf("frame header   offset %12d  BookID:%v created %v\n", 0, h.BookID, h.CreateTm.In(Chicago).Format(RFC3339MicroNumericTZ))
+
+	nframe := 0
+	for {
+		e, err := r.Next()
+		beg := r.Offset()
+		if err == io.EOF {
+			break
+		}
+		if err == TruncatedFrame {
+			fmt.Printf("TORN last frame at offset %v: %v bytes past the last whole frame would be discarded on next open.\n", beg, sz-beg)
+			return false
+		}
+		if err != nil {
+			fmt.Printf("BAD frame %v at offset %v: '%v'\n", nframe, beg, err)
+			return false
+		}
+		fmt.Printf("frame %6d   offset %12d  len %10d  seqno %6d  %v\n", nframe, beg, r.scan.offset()-beg, e.Seqno, e.Typ)
+		nframe++
+	}
+	fmt.Printf("ok: '%v' has a header and %v element frames in %v bytes.\n", path, nframe, sz)
+	return true
+}
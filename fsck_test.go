@@ -0,0 +1,185 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+// writeTestBook makes a small book at path with n Command elements.
+func writeTestBook(path string, n int) {
+	h, appendFD, err := ReadBook("tester", "testhost", path)
+	panicOn(err)
+	for i := 0; i < n; i++ {
+		msg, numlines := prepCommandMessage("x <- 1", i)
+		e := &HashRElem{
+			Typ:                 Command,
+			Tm:                  time.Now(),
+			Seqno:               i,
+			CmdJSON:             msg,
+			BeginCommandLineNum: i + 1,
+			NumCommandLines:     numlines,
+		}
+		h.mut.Lock()
+		h.appendElem(e)
+		h.mut.Unlock()
+		by, err := e.SaveToSlice()
+		panicOn(err)
+		_, err = appendFD.Write(by)
+		panicOn(err)
+	}
+	panicOn(appendFD.Close())
+}
+
+func TestTornTailRecovery(t *testing.T) {
+
+	cv.Convey("ReadBook should truncate a torn last record back to the last whole frame, saving the torn bytes to .torn, and -fsck should report it without changing anything", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-fsck")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "torn.rbook")
+
+		// keep the .rbook.vvlog out of the source tree.
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		writeTestBook(path, 3)
+		goodSize, err := FileSize(path)
+		panicOn(err)
+
+		// simulate a crash half way through the append of a 4th element.
+		msg, _ := prepCommandMessage("y <- 2", 3)
+		e := &HashRElem{Typ: Command, Tm: time.Now(), Seqno: 3, CmdJSON: msg}
+		by, err := e.SaveToSlice()
+		panicOn(err)
+		fd, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0660)
+		panicOn(err)
+		_, err = fd.Write(by[:len(by)/2])
+		panicOn(err)
+		fd.Close()
+
+		cfg := &RbookConfig{}
+		cv.So(cfg.fsckBook(path), cv.ShouldBeFalse)
+		sz, err := FileSize(path)
+		panicOn(err)
+		cv.So(sz, cv.ShouldEqual, goodSize+int64(len(by)/2))
+
+		h, appendFD, err := ReadBook("tester", "testhost", path)
+		panicOn(err)
+		cv.So(len(h.elems), cv.ShouldEqual, 3)
+		sz, err = FileSize(path)
+		panicOn(err)
+		cv.So(sz, cv.ShouldEqual, goodSize)
+
+		torn, err := ioutil.ReadFile(path + ".torn")
+		panicOn(err)
+		cv.So(len(torn), cv.ShouldEqual, len(by)/2)
+
+		// and we can keep appending.
+		_, err = appendFD.Write(by)
+		panicOn(err)
+		appendFD.Close()
+		cv.So(cfg.fsckBook(path), cv.ShouldBeTrue)
+
+		h, appendFD, err = ReadBook("tester", "testhost", path)
+		panicOn(err)
+		appendFD.Close()
+		cv.So(len(h.elems), cv.ShouldEqual, 4)
+	})
+
+	cv.Convey("a single stray byte after the last frame is a torn tail too", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-fsck")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "stray.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		writeTestBook(path, 2)
+		goodSize, err := FileSize(path)
+		panicOn(err)
+
+		fd, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0660)
+		panicOn(err)
+		_, err = fd.Write([]byte{bin32})
+		panicOn(err)
+		fd.Close()
+
+		h, appendFD, err := ReadBook("tester", "testhost", path)
+		panicOn(err)
+		appendFD.Close()
+		cv.So(len(h.elems), cv.ShouldEqual, 2)
+		sz, err := FileSize(path)
+		panicOn(err)
+		cv.So(sz, cv.ShouldEqual, goodSize)
+	})
+
+	cv.Convey("a middle frame whose length runs past the end of the file is corruption, not a torn tail: ReadBook refuses the book, and leaves it as it is", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-fsck")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "corrupt.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		// long enough commands that each frame has a bin16 length.
+		var elems []*HashRElem
+		for i := 0; i < 3; i++ {
+			msg, n := prepCommandMessage("x <- '"+strings.Repeat("a", 300)+"'", i)
+			elems = append(elems, &HashRElem{Typ: Command, Tm: time.Now(), CmdJSON: msg, BeginCommandLineNum: i + 1, NumCommandLines: n})
+		}
+		writeHostBook(path, "testhost", elems)
+		h, appendFD, err := ReadBook("tester", "testhost", path)
+		panicOn(err)
+		appendFD.Close()
+		off := h.index[1].Offset
+		fd, err := os.OpenFile(path, os.O_RDWR, 0660)
+		panicOn(err)
+		hdr := make([]byte, 3)
+		_, err = fd.ReadAt(hdr, off)
+		panicOn(err)
+		cv.So(hdr[0], cv.ShouldEqual, byte(bin16))
+		_, err = fd.WriteAt([]byte{0xff, 0xff}, off+1)
+		panicOn(err)
+		fd.Close()
+		sz, err := FileSize(path)
+		panicOn(err)
+
+		_, _, err = ReadBook("tester", "testhost", path)
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(err.Error(), cv.ShouldContainSubstring, "CorruptFrameLength")
+		sz2, err := FileSize(path)
+		panicOn(err)
+		cv.So(sz2, cv.ShouldEqual, sz)
+		cv.So(FileExists(path+".torn"), cv.ShouldBeFalse)
+
+		cfg := &RbookConfig{}
+		cv.So(cfg.fsckBook(path), cv.ShouldBeFalse)
+
+		// the same at the very end, past the last frame, is also
+		// a bad length, not a torn append.
+		cv.So(tornFrame(nil), cv.ShouldBeTrue)
+		by, err := elems[0].SaveToSlice()
+		panicOn(err)
+		_, _, nheader, err := UnframeBinMsgpack(by)
+		panicOn(err)
+		cv.So(tornFrame(by[nheader:]), cv.ShouldBeFalse)
+		cv.So(tornFrame(by[nheader:len(by)-1]), cv.ShouldBeTrue)
+	})
+}
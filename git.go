@@ -0,0 +1,402 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Books are binary, so to git they are opaque: a diff says only
+// that they differ, and two clones that both appended give a
+// conflict on every pull. To teach git about them, in the repo:
+//
+//	.gitattributes:
+//	    *.rbook*      diff=rbook merge=rbook
+//	    *.rbook*.idx  !diff !merge
+//	    *.rbook*.rsh  !diff !merge
+//
+//	git config diff.rbook.textconv 'rbook -textconv'
+//	git config merge.rbook.name 'rbook append-only merge'
+//	git config merge.rbook.driver 'rbook -merge-driver %O %A %B'
+//
+// The * after .rbook picks up the my.rbook.<hostname> books; the
+// !diff lines hand the companion files back to git's own diff and
+// merge. -textconv shows any other file that is not a book as is.
+
+// textconvBook implements rbook -textconv: write to w a plain
+// text form of the book at path for git diff. Each element is one
+// stanza, headed by its seqno, type, and time; so appending to the
+// book only ever appends to the text, and a change to history
+// shows as a change to just the stanzas involved. Images show as
+// the checksum of their bytes, the same whether inline or in the
+// blob store.
+func (c *RbookConfig) textconvBook(w io.Writer, path string) (ok bool) {
+	r, err := OpenBookReader(path)
+	if err != nil {
+		// not a book: a companion file, say; or empty,
+		// as git gives us for a book just added.
+		fd, err2 := os.Open(path)
+		if err2 != nil {
+			fmt.Fprintf(os.Stderr, "rbook -textconv: %v\n", err2)
+			return false
+		}
+		defer fd.Close()
+		_, err2 = io.Copy(w, fd)
+		return err2 == nil
+	}
+	defer r.Close()
+
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	h := r.Book
+	fmt.Fprintf(bw, "rbook BookID %v, format version %v\n", h.BookID, h.FormatVersion)
+	fmt.Fprintf(bw, "created %v by %v on %v\n", h.CreateTm.In(Chicago).Format(RFC3339MicroNumericTZ), h.User, h.Host)
+	if h.ParentBookID != "" {
+		fmt.Fprintf(bw, "extracted from BookID %v\n", h.ParentBookID)
+	}
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return true
+		}
+		if err == TruncatedFrame {
+			fmt.Fprintf(bw, "\n(torn last frame at offset %v)\n", r.Offset())
+			return true
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rbook -textconv: bad frame at offset %v: '%v'\n", r.Offset(), err)
+			return false
+		}
+		err = writeTextconvElem(bw, e)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rbook -textconv: %v\n", err)
+			return false
+		}
+	}
+}
+
+// writeTextconvElem writes the -textconv stanza for e.
+func writeTextconvElem(w io.Writer, e *HashRElem) error {
+	tm := e.Tm.In(Chicago).Format(RFC3339MicroNumericTZ)
+	from := ""
+	if e.SourceHost != "" {
+		from = " from " + e.SourceHost
+	}
+	switch e.Typ {
+	case OverlayHideOutput:
+		fmt.Fprintf(w, "\n[%04d] hide output of [%04d] %v%v\n", e.Seqno, e.OverlayHideSeqno, tm, from)
+		return nil
+	case OverlayLaterNote:
+		note := &overlayNoteJSON{}
+		err := decodeLenPrefixed(e.OverlayNoteJSON, note)
+		if err != nil {
+			return fmt.Errorf("note at seqno %v: '%v'", e.Seqno, err)
+		}
+		fmt.Fprintf(w, "\n[%04d] note on [%04d] %v%v\n", e.Seqno, note.OverlayOnSeqno, tm, from)
+		for _, line := range strings.Split(note.OverlayNote, "\n") {
+			fmt.Fprintf(w, "    %v\n", line)
+		}
+		return nil
+	case Image:
+		sum := e.ImageHash
+		if sum == "" {
+			sum = checksumOf(e.ImageBy)
+		}
+		fmt.Fprintf(w, "\n[%04d] image %v %v%v\n    %v\n", e.Seqno, e.ImagePath, tm, from, sum)
+		if len(e.ImageSVG) > 0 {
+			fmt.Fprintf(w, "    svg %v\n", checksumOf(e.ImageSVG))
+		}
+		if len(e.ImagePDF) > 0 {
+			fmt.Fprintf(w, "    pdf %v\n", checksumOf(e.ImagePDF))
+		}
+		if len(e.PlotRDS) > 0 {
+			fmt.Fprintf(w, "    recordPlot %v\n", checksumOf(e.PlotRDS))
+		}
+		return nil
+	}
+
+	d, err := decodeElem(e)
+	if err != nil {
+		return err
+	}
+	var lines []string
+	switch e.Typ {
+	case Command:
+		fmt.Fprintf(w, "\n[%04d] command line [%03d] %v%v\n", e.Seqno, e.BeginCommandLineNum, tm, from)
+		lines = d.Command
+	case Console:
+		stream := ""
+		if d.Stream != "" {
+			stream = " " + d.Stream
+		}
+		fmt.Fprintf(w, "\n[%04d] console%v %v%v\n", e.Seqno, stream, tm, from)
+		lines = d.Console
+	case Comment:
+		fmt.Fprintf(w, "\n[%04d] comment %v%v\n", e.Seqno, tm, from)
+		lines = d.Comment
+	case Error:
+		fmt.Fprintf(w, "\n[%04d] error %v%v\n", e.Seqno, tm, from)
+		for _, line := range d.ErrorCommand {
+			lines = append(lines, "! "+line)
+		}
+		lines = append(lines, d.Error...)
+		lines = append(lines, tracebackLines(d.Traceback)...)
+	case Table:
+		if d.Table == nil {
+			return nil
+		}
+		fmt.Fprintf(w, "\n[%04d] table %v%v\n", e.Seqno, tm, from)
+		lines = []string{d.Table.Summary()}
+	case Widget:
+		fmt.Fprintf(w, "\n[%04d] widget %v %v%v\n", e.Seqno, d.WidgetClass, tm, from)
+		lines = []string{e.WidgetHash}
+	default:
+		// from a newer rbook.
+		fmt.Fprintf(w, "\n[%04d] %v %v%v\n", e.Seqno, e.Typ, tm, from)
+		return nil
+	}
+	for _, line := range lines {
+		fmt.Fprintf(w, "    %v\n", line)
+	}
+	return nil
+}
+
+// sameElem reports whether a and b are the same element of
+// the same book. The Checksum covers PrevHash, so with the
+// hash chain, equal Checksums mean equal histories too.
+func sameElem(a, b *HashRElem) bool {
+	if a.Checksum != "" || b.Checksum != "" {
+		return a.Checksum == b.Checksum
+	}
+	// from before we had checksums.
+	return a.Typ == b.Typ && a.Seqno == b.Seqno && a.Tm.Equal(b.Tm) &&
+		bytes.Equal(a.msg, b.msg) &&
+		a.OverlayNoteJSON == b.OverlayNoteJSON &&
+		a.OverlayHideSeqno == b.OverlayHideSeqno &&
+		a.ImagePath == b.ImagePath && a.ImageHash == b.ImageHash &&
+		bytes.Equal(a.ImageBy, b.ImageBy)
+}
+
+// mergeDriverKey identifies an element apart from its seqno and
+// chain, so the same element appended on both sides is kept once.
+func mergeDriverKey(e *HashRElem) string {
+	return fmt.Sprintf("%v/%v/%v", e.Typ, e.Tm.UnixNano(), e.ImagePath)
+}
+
+// mergeDriverStats counts what mergeDriver did.
+type mergeDriverStats struct {
+	prefix int // elements in common.
+	ours   int // elements only ours appended.
+	theirs int // elements only theirs appended, now after ours.
+	dup    int // elements both sides appended.
+}
+
+// mergeDriver does a three-way merge, as a git merge driver, of
+// the book at ours with the book at theirs, given base, their
+// common ancestor. Books are append-only, so base should be a
+// prefix of both. The merge is ours, unchanged, followed by
+// what theirs appended and we did not, renumbered and chained on
+// after ours. The result is written to a temp file beside ours,
+// then renamed over it; so ours keeps its own history as the
+// prefix, and is left whole if writing fails. We hold the book
+// lock on ours throughout, so no live rbook is appending to it.
+// err is set if the books cannot be merged this way; say, because
+// one was -compact-ed, or they are different books. Then ours is
+// left alone, and git reports a conflict.
+func mergeDriver(base, ours, theirs string) (st *mergeDriverStats, err error) {
+	st = &mergeDriverStats{}
+
+	udlock, err := NewUDLock(ours)
+	if err != nil {
+		return nil, fmt.Errorf("ours is in use: '%v'", err)
+	}
+	defer udlock.Close()
+
+	// read ours to its end, noting the chain head there.
+	r, err := OpenBookReader(ours)
+	if err != nil {
+		return nil, fmt.Errorf("could not read ours: '%v'", err)
+	}
+	a := r.Book
+	a.chainHead = a.chainAnchor()
+	for {
+		var e *HashRElem
+		e, err = r.Next()
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			r.Close()
+			if err == TruncatedFrame {
+				return nil, fmt.Errorf("ours has a torn last frame; run rbook -fsck on it first")
+			}
+			return nil, fmt.Errorf("ours has a bad frame at offset %v: '%v'", r.Offset(), err)
+		}
+		a.chainHead, err = chainLink(a.chainHead, e, true, a.FormatVersion < chainFormatVersion)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("ours: %v", err)
+		}
+		a.elems = append(a.elems, e)
+	}
+	r.Close()
+
+	b, err := loadBookReadOnly(theirs)
+	if err != nil {
+		return nil, fmt.Errorf("could not read theirs: '%v'", err)
+	}
+	if b.BookID != a.BookID {
+		return nil, fmt.Errorf("ours (BookID %v) and theirs (BookID %v) are different books", a.BookID, b.BookID)
+	}
+	if b.FormatVersion > BookFormatVersion {
+		// it may hold seqnos we do not know how to renumber.
+		return nil, fmt.Errorf("theirs is format version %v, newer than this rbook knows (%v). Use a newer rbook.", b.FormatVersion, BookFormatVersion)
+	}
+
+	n := 0
+	for n < len(a.elems) && n < len(b.elems) && sameElem(a.elems[n], b.elems[n]) {
+		n++
+	}
+	st.prefix = n
+
+	if sz, _ := FileSize(base); sz > 0 {
+		o, err := loadBookReadOnly(base)
+		if err != nil {
+			return nil, fmt.Errorf("could not read base: '%v'", err)
+		}
+		if o.BookID != a.BookID {
+			return nil, fmt.Errorf("base (BookID %v) is a different book from ours (BookID %v)", o.BookID, a.BookID)
+		}
+		if len(o.elems) > n {
+			return nil, fmt.Errorf("history was rewritten: ours and theirs differ at seqno %v, before the end of base (%v elements)", n, len(o.elems))
+		}
+		for i, e := range o.elems {
+			if !sameElem(e, a.elems[i]) {
+				return nil, fmt.Errorf("history was rewritten: base and ours differ at seqno %v", e.Seqno)
+			}
+		}
+	}
+
+	// what both appended, by what it was in theirs -> its seqno in ours.
+	old2new := make(map[int]int)
+	for _, e := range a.elems[:n] {
+		old2new[e.Seqno] = e.Seqno
+	}
+	oursAppended := make(map[string]int)
+	for _, e := range a.elems[n:] {
+		oursAppended[mergeDriverKey(e)] = e.Seqno
+	}
+	st.ours = len(a.elems) - n
+
+	var add []*HashRElem
+	lastCommandLineNum := getLastCommandLineNum(a)
+	lastBegin := 0
+	for _, e := range b.elems[n:] {
+		if seqno, ok := oursAppended[mergeDriverKey(e)]; ok {
+			old2new[e.Seqno] = seqno
+			st.dup++
+			continue
+		}
+		if e.Typ == Command && e.BeginCommandLineNum > 0 {
+			if e.BeginCommandLineNum == lastBegin {
+				st.dup++
+				continue
+			}
+			lastBegin = e.BeginCommandLineNum
+		}
+		ne, kept := reseqElem(e, len(a.elems), old2new)
+		if !kept {
+			continue
+		}
+		if ne.Typ == Command {
+			ne.BeginCommandLineNum = lastCommandLineNum + 1
+			lastCommandLineNum += ne.NumCommandLines
+		}
+		a.appendElem(ne)
+		add = append(add, ne)
+	}
+	st.theirs = len(add)
+	if len(add) == 0 {
+		return st, nil
+	}
+
+	err = appendByRename(ours, add)
+	if err != nil {
+		return nil, fmt.Errorf("writing ours: '%v'", err)
+	}
+	return st, nil
+}
+
+// appendByRename gives the book at path the frames of add after
+// its own: it writes both to a temp file in the same directory,
+// and renames that over path. On error, path is as it was.
+func appendByRename(path string, add []*HashRElem) (err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	fd, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".merge")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			fd.Close()
+			os.Remove(fd.Name())
+		}
+	}()
+	_, err = io.Copy(fd, in)
+	if err != nil {
+		return err
+	}
+	for _, e := range add {
+		var by []byte
+		by, err = e.SaveToSlice()
+		if err != nil {
+			return err
+		}
+		_, err = fd.Write(by)
+		if err != nil {
+			return err
+		}
+	}
+	err = fd.Chmod(fi.Mode())
+	if err != nil {
+		return err
+	}
+	err = fd.Sync()
+	if err != nil {
+		return err
+	}
+	err = fd.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(fd.Name(), path)
+}
+
+// mergeDriverCmd implements rbook -merge-driver %O %A %B, for git.
+func (c *RbookConfig) mergeDriverCmd() (ok bool) {
+	base, ours, theirs := c.MergeFrom[0], c.MergeFrom[1], c.MergeFrom[2]
+	st, err := mergeDriver(base, ours, theirs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -merge-driver: cannot merge: %v\n", err)
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "rbook -merge-driver: %v elements in common; kept the %v we appended, and added the %v they appended (%v were on both sides).\n", st.prefix, st.ours, st.theirs, st.dup)
+	return true
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestTextconv(t *testing.T) {
+
+	cv.Convey("rbook -textconv should write a book as stable plain text for git diff, where appending to the book only appends to the text", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-textconv")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "my.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		pngBy := writeExportTestBook(path)
+
+		cfg := &RbookConfig{}
+		var before bytes.Buffer
+		cv.So(cfg.textconvBook(&before, path), cv.ShouldBeTrue)
+		text := before.String()
+		cv.So(text, cv.ShouldContainSubstring, "\n[0000] command line [001] ")
+		cv.So(text, cv.ShouldContainSubstring, "\n    x <- c(1,2,3)\n")
+		cv.So(text, cv.ShouldContainSubstring, "\n    ## [1] 1 2 3\n")
+		cv.So(text, cv.ShouldContainSubstring, "\n    "+checksumOf(pngBy)+"\n")
+		cv.So(text, cv.ShouldContainSubstring, "\n[0008] hide output of [0004] ")
+		cv.So(text, cv.ShouldContainSubstring, "\n[0009] note on [0000] ")
+		cv.So(text, cv.ShouldContainSubstring, "\n    x was <fine>\n")
+
+		// the same again.
+		var again bytes.Buffer
+		cv.So(cfg.textconvBook(&again, path), cv.ShouldBeTrue)
+		cv.So(again.String(), cv.ShouldEqual, text)
+
+		msg, n := prepCommandMessage("y <- 2", 10)
+		writeHostBook(path, "rog", []*HashRElem{{Typ: Command, Tm: time.Now(), CmdJSON: msg, BeginCommandLineNum: 4, NumCommandLines: n}})
+		var after bytes.Buffer
+		cv.So(cfg.textconvBook(&after, path), cv.ShouldBeTrue)
+		cv.So(strings.HasPrefix(after.String(), text), cv.ShouldBeTrue)
+		cv.So(after.String()[len(text):], cv.ShouldContainSubstring, "\n    y <- 2\n")
+
+		// other files pass through as they are.
+		var idx bytes.Buffer
+		cv.So(cfg.textconvBook(&idx, indexPathFor(path)), cv.ShouldBeTrue)
+		cv.So(idx.String(), cv.ShouldEqual, string(mustReadFile(indexPathFor(path))))
+	})
+}
+
+func TestMergeDriver(t *testing.T) {
+
+	cv.Convey("rbook -merge-driver should merge two clones of a book that each appended, keeping ours as is and adding theirs after; and should refuse when history was rewritten", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-merge-driver")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		t0 := time.Date(2023, 9, 12, 5, 0, 0, 0, Chicago)
+		at := func(min int) time.Time { return t0.Add(time.Duration(min) * time.Minute) }
+		cmd := func(min, seqno, line int, code string) *HashRElem {
+			msg, n := prepCommandMessage(code, seqno)
+			return &HashRElem{Typ: Command, Tm: at(min), CmdJSON: msg, BeginCommandLineNum: line, NumCommandLines: n}
+		}
+		cp := func(from, to string) {
+			panicOn(ioutil.WriteFile(to, mustReadFile(from), 0660))
+		}
+
+		base := filepath.Join(dir, "base.rbook")
+		writeHostBook(base, "rog", []*HashRElem{
+			cmd(0, 0, 1, "a <- 1"), // 0
+			{Typ: Console, Tm: at(1), ConsoleJSON: prepConsoleMessage(`["## [1] 1"]`, 1)}, // 1
+		})
+		ours := filepath.Join(dir, "ours.rbook")
+		theirs := filepath.Join(dir, "theirs.rbook")
+		cp(base, ours)
+		cp(base, theirs)
+
+		both := &HashRElem{Typ: Comment, Tm: at(9), CommentJSON: prepCommentMessage("#both", 3)}
+		writeHostBook(ours, "rog", []*HashRElem{
+			cmd(2, 2, 2, "o <- 2"), // 2
+			both,                   // 3
+		})
+		both2 := *both
+		both2.CommentJSON = prepCommentMessage("#both", 4)
+		writeHostBook(theirs, "rog", []*HashRElem{
+			cmd(3, 2, 2, "t <- 3"), // 2
+			{Typ: OverlayHideOutput, Tm: at(4), OverlayHideSeqno: 1, OverlayHideSeqnoJSON: prepOverlayHideOutput(3, 1)}, // 3
+			&both2, // 4
+			{Typ: OverlayLaterNote, Tm: at(10), OverlayNoteJSON: prepOverlayLaterNoteMessage("about t", 5, 2)}, // 5
+		})
+		oursBy := mustReadFile(ours)
+
+		// as git would call us.
+		cfg := &RbookConfig{}
+		fs := flag.NewFlagSet("rbook", flag.ContinueOnError)
+		cfg.DefineFlags(fs)
+		panicOn(fs.Parse([]string{"-merge-driver", base, ours, theirs}))
+		panicOn(cfg.FinishConfig(fs))
+		cv.So(cfg.RbookFilePath, cv.ShouldEqual, ours)
+		cv.So(cfg.runOfflineTool(cfg.RbookFilePath), cv.ShouldBeTrue)
+
+		by := mustReadFile(ours)
+		cv.So(bytes.HasPrefix(by, oursBy), cv.ShouldBeTrue)
+		res, ok := verifyFrames(ours)
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(res.checked, cv.ShouldEqual, 7)
+
+		m, err := loadBookReadOnly(ours)
+		panicOn(err)
+		cv.So(len(m.elems), cv.ShouldEqual, 7)
+		for i, e := range m.elems {
+			cv.So(e.Seqno, cv.ShouldEqual, i)
+		}
+		d, err := decodeElem(m.elems[4])
+		panicOn(err)
+		cv.So(d.Command, cv.ShouldResemble, []string{"t <- 3"})
+		cv.So(d.Seqno, cv.ShouldEqual, 4)
+		cv.So(m.elems[4].BeginCommandLineNum, cv.ShouldEqual, 3)
+		cv.So(m.elems[5].Typ, cv.ShouldEqual, OverlayHideOutput)
+		cv.So(m.elems[5].OverlayHideSeqno, cv.ShouldEqual, 1)
+		note := &overlayNoteJSON{}
+		panicOn(decodeLenPrefixed(m.elems[6].OverlayNoteJSON, note))
+		cv.So(note.OverlayOnSeqno, cv.ShouldEqual, 4)
+
+		// merging again adds nothing.
+		st, err := mergeDriver(base, ours, theirs)
+		panicOn(err)
+		cv.So(st.theirs, cv.ShouldEqual, 0)
+		cv.So(bytes.Equal(mustReadFile(ours), by), cv.ShouldBeTrue)
+
+		// it refuses while a live rbook has ours.
+		lock, err := NewUDLock(ours)
+		panicOn(err)
+		_, err = mergeDriver(base, ours, theirs)
+		lock.Close()
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(bytes.Equal(mustReadFile(ours), by), cv.ShouldBeTrue)
+		left, err := filepath.Glob(ours + ".merge*")
+		panicOn(err)
+		cv.So(len(left), cv.ShouldEqual, 0)
+
+		// a compacted theirs has rewritten history.
+		compacted := filepath.Join(dir, "compacted.rbook")
+		cv.So((&RbookConfig{Out: compacted, CompactHide: true}).compactBook(theirs), cv.ShouldBeTrue)
+		_, err = mergeDriver(base, ours, compacted)
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(bytes.Equal(mustReadFile(ours), by), cv.ShouldBeTrue)
+
+		// as is a different book.
+		other := filepath.Join(dir, "other.rbook")
+		writeHostBook(other, "rog", []*HashRElem{cmd(0, 0, 1, "a <- 1")})
+		_, err = mergeDriver(base, ours, other)
+		cv.So(err, cv.ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,245 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// grepHit is one line of a book matched by rbook -grep, or by
+// /search on the live page.
+type grepHit struct {
+	Book  string `json:"book,omitempty"`
+	Seqno int    `json:"seqno"`
+
+	// the command line number: of the line itself, for a command;
+	// else of the command it followed.
+	Line int `json:"line"`
+
+	Tm   time.Time `json:"tm"`
+	Typ  string    `json:"typ"` // command, console, comment, error, table, or widget.
+	Text string    `json:"text"`
+}
+
+// grepper matches the commands, console output, comments, and
+// errors of a book's elements, in order, against re; and tables
+// by their Summary(), and widgets by their class, as -textconv
+// shows them.
+type grepper struct {
+	re   *regexp.Regexp
+	book string
+
+	line      int // command line number in effect.
+	lastBegin int // to skip duplicate commands, as -dump does.
+}
+
+// grep returns the hits in e.
+func (g *grepper) grep(e *HashRElem) (hits []*grepHit, err error) {
+	var typ string
+	switch e.Typ {
+	case Command:
+		if e.BeginCommandLineNum > 0 {
+			if e.BeginCommandLineNum == g.lastBegin {
+				return nil, nil
+			}
+			g.lastBegin = e.BeginCommandLineNum
+			g.line = e.BeginCommandLineNum
+		}
+		typ = "command"
+	case Console:
+		typ = "console"
+	case Comment:
+		typ = "comment"
+	case Error:
+		typ = "error"
+	case Table:
+		typ = "table"
+	case Widget:
+		typ = "widget"
+	default:
+		return nil, nil
+	}
+	d, err := decodeElem(e)
+	if err != nil || d == nil {
+		return nil, err
+	}
+	lines := d.Console
+	switch e.Typ {
+	case Command:
+		lines = d.Command
+	case Comment:
+		lines = d.Comment
+	case Error:
+		lines = append(append(append([]string{}, d.ErrorCommand...), d.Error...), tracebackLines(d.Traceback)...)
+	case Table:
+		lines = nil
+		if d.Table != nil {
+			lines = []string{d.Table.Summary()}
+		}
+	case Widget:
+		lines = []string{d.WidgetClass}
+	}
+	for i, text := range lines {
+		if !g.re.MatchString(text) {
+			continue
+		}
+		hit := &grepHit{Book: g.book, Seqno: e.Seqno, Line: g.line, Tm: e.Tm, Typ: typ, Text: text}
+		if e.Typ == Command && e.BeginCommandLineNum > 0 {
+			hit.Line = e.BeginCommandLineNum + i
+		}
+		hits = append(hits, hit)
+	}
+	return
+}
+
+// String gives the hit as rbook -grep prints it.
+func (hit *grepHit) String() string {
+	return fmt.Sprintf("%v  seqno %v  [%03d]  %v  %v: %v", hit.Book, hit.Seqno, hit.Line, hit.Tm.In(Chicago).Format(RFC3339MicroNumericTZ), hit.Typ, hit.Text)
+}
+
+// grepBookPaths returns the books at paths, looking through any
+// directories for them. A book is any file with .rbook in its name
+// that reads as one, so companion files (.idx, .rsh, and the like)
+// are passed over; as are .pre-upgrade copies, and .git.
+func grepBookPaths(paths []string) (books []string) {
+	isBook := func(path string) bool {
+		r, err := OpenBookReader(path)
+		if err != nil {
+			return false
+		}
+		r.Close()
+		return true
+	}
+	for _, path := range paths {
+		if !DirExists(path) {
+			books = append(books, path)
+			continue
+		}
+		var found []string
+		filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return nil // unreadable; skip it.
+			}
+			name := fi.Name()
+			if fi.IsDir() {
+				if p != path && (name == ".git" || strings.HasSuffix(name, ".blobs") || strings.HasSuffix(name, ".plots")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.Contains(name, ".rbook") && !strings.HasSuffix(name, ".pre-upgrade") && isBook(p) {
+				found = append(found, p)
+			}
+			return nil
+		})
+		sort.Strings(found)
+		books = append(books, found...)
+	}
+	return
+}
+
+// grepBook writes to w the hits for re in the book at path,
+// reading it one element at a time. nhit counts them.
+func grepBook(w io.Writer, re *regexp.Regexp, path string) (nhit int, err error) {
+	r, err := OpenBookReader(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	g := &grepper{re: re, book: path}
+	for {
+		e, err := r.Next()
+		if err == io.EOF || err == TruncatedFrame {
+			return nhit, nil
+		}
+		if err != nil {
+			return nhit, fmt.Errorf("'%v': bad frame at offset %v: '%v'", path, r.Offset(), err)
+		}
+		hits, err := g.grep(e)
+		if err != nil {
+			return nhit, fmt.Errorf("'%v': %v", path, err)
+		}
+		for _, hit := range hits {
+			fmt.Fprintln(w, hit)
+		}
+		nhit += len(hits)
+	}
+}
+
+// grepCmd implements rbook -grep pattern [books or directories...].
+// As with grep, ok is false if nothing matched.
+func (c *RbookConfig) grepCmd(w io.Writer) (ok bool) {
+	re, err := regexp.Compile(c.Grep)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -grep: bad pattern: '%v'\n", err)
+		return false
+	}
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	nhit := 0
+	for _, path := range grepBookPaths(c.GrepIn) {
+		n, err := grepBook(bw, re, path)
+		nhit += n
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rbook -grep: %v\n", err)
+		}
+	}
+	return nhit > 0
+}
+
+// maxSearchHits caps the hits /search returns.
+const maxSearchHits = 500
+
+// searchResult is what /search returns, as json.
+type searchResult struct {
+	Query string     `json:"query"`
+	Hits  []*grepHit `json:"hits"`
+
+	// More is true if there were more than maxSearchHits.
+	More bool `json:"more"`
+}
+
+// serveSearch answers /search?q=pattern with the hits for the
+// pattern, as for rbook -grep, in the live book b; the browser
+// can then jump to each hit's seqno.
+func serveSearch(w http.ResponseWriter, r *http.Request, b *HashRBook) {
+	q := r.URL.Query().Get("q")
+	re, err := regexp.Compile(q)
+	if err != nil || q == "" {
+		http.Error(w, fmt.Sprintf("bad search pattern '%v': %v", q, err), http.StatusBadRequest)
+		return
+	}
+	res := &searchResult{Query: q, Hits: []*grepHit{}}
+	g := &grepper{re: re}
+
+	b.mut.Lock()
+	for _, e := range b.elems {
+		hits, err := g.grep(e)
+		if err != nil {
+			vv("serveSearch: %v", err)
+			continue
+		}
+		res.Hits = append(res.Hits, hits...)
+		if len(res.Hits) > maxSearchHits {
+			res.Hits = res.Hits[:maxSearchHits]
+			res.More = true
+			break
+		}
+	}
+	b.mut.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestGrep(t *testing.T) {
+
+	cv.Convey("rbook -grep should find a pattern in the commands, console output, and comments of the books under a directory, giving book, seqno, command line, and time; /search should do the same for the live book", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-grep")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		panicOn(os.MkdirAll(filepath.Join(dir, "proj1"), 0777))
+		panicOn(os.MkdirAll(filepath.Join(dir, "proj2"), 0777))
+		book1 := filepath.Join(dir, "proj1", "my.rbook.rog")
+		book2 := filepath.Join(dir, "proj2", "my.rbook")
+		writeExportTestBook(book1)
+		writeExportTestBook(book2)
+		cv.So(FileExists(indexPathFor(book1)), cv.ShouldBeTrue)
+
+		// as the flags would come.
+		cfg := &RbookConfig{}
+		fs := flag.NewFlagSet("rbook", flag.ContinueOnError)
+		cfg.DefineFlags(fs)
+		panicOn(fs.Parse([]string{"-grep", "letters", dir}))
+		panicOn(cfg.FinishConfig(fs))
+		cv.So(cfg.GrepIn, cv.ShouldResemble, []string{dir})
+		cv.So(cfg.offlineTool(), cv.ShouldEqual, "grep")
+
+		// the companion .idx files are not books.
+		cv.So(grepBookPaths(cfg.GrepIn), cv.ShouldResemble, []string{book1, book2})
+
+		var out bytes.Buffer
+		cv.So(cfg.grepCmd(&out), cv.ShouldBeTrue)
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		// the duplicate at seqno 5 is skipped.
+		cv.So(len(lines), cv.ShouldEqual, 2)
+		cv.So(lines[0], cv.ShouldStartWith, book1+"  seqno 3  [002]  ")
+		cv.So(lines[0], cv.ShouldEndWith, "  command: print(letters)")
+		cv.So(lines[1], cv.ShouldStartWith, book2+"  seqno 3  [002]  ")
+
+		// console and comments, with the command line they followed.
+		cfg = &RbookConfig{Grep: "c d|why", GrepIn: []string{book1}}
+		out.Reset()
+		cv.So(cfg.grepCmd(&out), cv.ShouldBeTrue)
+		lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+		cv.So(len(lines), cv.ShouldEqual, 2)
+		cv.So(lines[0], cv.ShouldContainSubstring, "  seqno 2  [001]  ")
+		cv.So(lines[0], cv.ShouldEndWith, "  comment: ### why x < y & z")
+		cv.So(lines[1], cv.ShouldContainSubstring, "  seqno 4  [002]  ")
+		cv.So(lines[1], cv.ShouldEndWith, "  console: ## c d")
+
+		// as grep, false on no hits.
+		cfg = &RbookConfig{Grep: "glmnet", GrepIn: []string{dir}}
+		out.Reset()
+		cv.So(cfg.grepCmd(&out), cv.ShouldBeFalse)
+		cv.So(out.Len(), cv.ShouldEqual, 0)
+
+		h, err := loadBookReadOnly(book1)
+		panicOn(err)
+		rec := httptest.NewRecorder()
+		serveSearch(rec, httptest.NewRequest("GET", "/search?q=print%5C(", nil), h)
+		cv.So(rec.Code, cv.ShouldEqual, 200)
+		res := &searchResult{}
+		panicOn(json.Unmarshal(rec.Body.Bytes(), res))
+		cv.So(res.Query, cv.ShouldEqual, `print\(`)
+		cv.So(len(res.Hits), cv.ShouldEqual, 1)
+		cv.So(res.Hits[0].Seqno, cv.ShouldEqual, 3)
+		cv.So(res.Hits[0].Line, cv.ShouldEqual, 2)
+		cv.So(res.Hits[0].Book, cv.ShouldEqual, "")
+		cv.So(res.More, cv.ShouldBeFalse)
+
+		rec = httptest.NewRecorder()
+		serveSearch(rec, httptest.NewRequest("GET", "/search?q=print(", nil), h)
+		cv.So(rec.Code, cv.ShouldEqual, 400)
+	})
+}
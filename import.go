@@ -0,0 +1,387 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// rbook -import turns older work into a book. It reads three kinds
+// of file:
+//
+//   - an R script or .Rhistory: split into top-level expressions;
+//     `## ` lines become Console output and `# ` lines Comments;
+//   - an .rsh script that rbook wrote: as above, but the
+//     `## command line [NNN]: <time>` markers give the timestamps;
+//   - an ESS *R* buffer transcript: `> ` and `+ ` prompts mark
+//     the commands, and what follows them is their output.
+//
+// As at the live prompt, a string literal starting with "# or ";
+// is a Comment too.
+
+// importPiece is one element to be, found in the imported file.
+type importPiece struct {
+	typ   HashRTyp
+	lines []string
+	tm    time.Time
+
+	// for Image
+	imagePath string
+}
+
+var (
+	importMarkerRegex = regexp.MustCompile(`^\s*## command line \[(\d+)\]: (\S+)`)
+	importImageRegex  = regexp.MustCompile(`^\s*##img=readPNG\('(.*)'\)`)
+)
+
+// importer splits the lines of a file into importPieces.
+type importer struct {
+	pieces []*importPiece
+	tm     time.Time
+}
+
+func (im *importer) add(typ HashRTyp, line string) {
+	n := len(im.pieces)
+	if n > 0 && im.pieces[n-1] != nil && im.pieces[n-1].typ == typ {
+		// one Console, or one Comment, per run of lines.
+		im.pieces[n-1].lines = append(im.pieces[n-1].lines, line)
+		return
+	}
+	im.pieces = append(im.pieces, &importPiece{typ: typ, lines: []string{line}, tm: im.tm})
+}
+
+// end stops the run of Console or Comment lines, so
+// the next such line starts a new element.
+func (im *importer) end() {
+	im.pieces = append(im.pieces, nil)
+}
+
+func (im *importer) command(lines []string) {
+	cmd := strings.TrimSpace(strings.Join(lines, "\n"))
+	if cmd == "" || isESSInjected(cmd) || isGarbage(cmd) {
+		return
+	}
+	if strings.HasPrefix(cmd, `"#`) || strings.HasPrefix(cmd, `";`) {
+		// a comment, as typed at the prompt; keep it in
+		// the escaped form that the prompt would give us.
+		im.pieces = append(im.pieces, &importPiece{typ: Comment, lines: []string{strings.Replace(cmd, "\n", `\n`, -1)}, tm: im.tm})
+		return
+	}
+	im.pieces = append(im.pieces, &importPiece{typ: Command, lines: []string{cmd}, tm: im.tm})
+}
+
+// commentText strips the leading #s from an R comment line.
+func commentText(line string) string {
+	return strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#"))
+}
+
+// splitScript splits an R script, .Rhistory, or rbook .rsh.
+func (im *importer) splitScript(lines []string) {
+	if i := rshHeaderEnd(lines); i > 0 {
+		lines = lines[i:]
+	}
+	var cur []string
+	var st rScanState
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if len(cur) > 0 {
+			cur = append(cur, line)
+			if st.scan(line) {
+				im.command(cur)
+				cur = nil
+			}
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			im.end()
+		case strings.HasPrefix(trimmed, "#!"):
+		case importMarkerRegex.MatchString(line):
+			m := importMarkerRegex.FindStringSubmatch(line)
+			tm, err := time.Parse(RFC3339MicroNumericTZ, m[2])
+			if err == nil {
+				im.tm = tm
+			}
+			im.end()
+		case importImageRegex.MatchString(line):
+			m := importImageRegex.FindStringSubmatch(line)
+			im.pieces = append(im.pieces, &importPiece{typ: Image, imagePath: m[1], tm: im.tm})
+		case trimmed == "##" || strings.HasPrefix(trimmed, "## "):
+			im.add(Console, strings.TrimPrefix(strings.TrimPrefix(trimmed, "##"), " "))
+		case strings.HasPrefix(trimmed, "#"):
+			im.add(Comment, commentText(trimmed))
+		default:
+			st = rScanState{}
+			if st.scan(line) {
+				im.command([]string{line})
+			} else {
+				cur = []string{line}
+			}
+		}
+	}
+	if len(cur) > 0 {
+		// an incomplete last expression; keep what there is.
+		im.command(cur)
+	}
+}
+
+// rshHeaderEnd returns the index of the first line after the
+// header that writeScriptHeader puts at the top of an .rsh
+// script, or 0 if lines do not start with one.
+func rshHeaderEnd(lines []string) int {
+	for i, line := range lines {
+		if i > 12 {
+			break
+		}
+		if line == "require(png)" {
+			return i + 1
+		}
+		if line != "" && !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "exec R ") {
+			break
+		}
+	}
+	return 0
+}
+
+// splitTranscript splits an ESS *R* buffer transcript.
+func (im *importer) splitTranscript(lines []string) {
+	var cur []string
+	inComment := false
+	flush := func() {
+		if len(cur) > 0 {
+			n := len(im.pieces)
+			im.command(cur)
+			// R echoes a comment string back: [1] "#..."; skip that.
+			inComment = len(im.pieces) > n && im.pieces[len(im.pieces)-1].typ == Comment
+			cur = nil
+		}
+	}
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+ ") || line == "+":
+			cur = append(cur, strings.TrimPrefix(strings.TrimPrefix(line, "+"), " "))
+		case strings.HasPrefix(line, "> ") || line == ">":
+			flush()
+			im.end()
+			cmd := strings.TrimPrefix(strings.TrimPrefix(line, ">"), " ")
+			if strings.HasPrefix(strings.TrimSpace(cmd), "#") {
+				im.add(Comment, commentText(cmd))
+				continue
+			}
+			cur = []string{cmd}
+		default:
+			flush()
+			if inComment || strings.TrimSpace(line) == "" {
+				continue
+			}
+			im.add(Console, line)
+		}
+	}
+	flush()
+}
+
+// isTranscript guesses whether lines are an ESS transcript:
+// the first line of code there starts with the > prompt.
+func isTranscript(lines []string) bool {
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		return strings.HasPrefix(line, "> ") || line == ">"
+	}
+	return false
+}
+
+// rScanState follows just enough R syntax across lines to
+// tell where a top-level expression ends: open brackets,
+// strings, and a trailing operator or if/for/while/function
+// header all mean the expression goes on to the next line.
+type rScanState struct {
+	open  []string // for each open bracket, the word before it, if any.
+	quote byte
+
+	// just closed the (...) of an if, for, while, or
+	// function, so a body must follow.
+	needBody bool
+}
+
+// scan takes the next line of an expression, and
+// reports whether the expression is now complete.
+func (st *rScanState) scan(line string) (complete bool) {
+	var last byte // last significant character, outside strings and comments.
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		if st.quote != 0 {
+			switch ch {
+			case '\\':
+				i++
+			case st.quote:
+				st.quote = 0
+				last = ch
+			}
+			continue
+		}
+		switch ch {
+		case '#':
+			i = len(line)
+			continue
+		case ' ', '\t', '\r':
+			continue
+		case '"', '\'', '`':
+			st.quote = ch
+		case '(', '[', '{':
+			word := ""
+			if ch == '(' {
+				j := i
+				for j > 0 && line[j-1] == ' ' {
+					j--
+				}
+				k := j
+				for k > 0 && isRWordChar(line[k-1]) {
+					k--
+				}
+				word = line[k:j]
+			}
+			st.open = append(st.open, word)
+		case ')', ']', '}':
+			if n := len(st.open); n > 0 {
+				word := st.open[n-1]
+				st.open = st.open[:n-1]
+				if ch == ')' && n == 1 {
+					switch word {
+					case "if", "for", "while", "function":
+						st.needBody = true
+						last = ch
+						continue
+					}
+				}
+			}
+		}
+		st.needBody = false
+		last = ch
+	}
+	if st.quote != 0 || len(st.open) > 0 || st.needBody {
+		return false
+	}
+	if last == 0 {
+		// a blank or comment line, within an expression
+		// that was left waiting for more.
+		return false
+	}
+	switch last {
+	case '+', '-', '*', '/', '^', ',', '<', '>', '=', '&', '|', '~', '!', ':', '%', '$', '@', '?':
+		return false
+	}
+	return true
+}
+
+func isRWordChar(ch byte) bool {
+	return ch == '_' || ch == '.' ||
+		(ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9')
+}
+
+// importBook implements rbook -import: build a new book at
+// the -o path from the R script, .Rhistory, .rsh, or ESS
+// transcript at path.
+func (c *RbookConfig) importBook(path string) (ok bool) {
+	out := c.Out
+	if FileExists(out) {
+		fmt.Fprintf(os.Stderr, "rbook -import: refusing to overwrite existing '%v'\n", out)
+		return false
+	}
+	by, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -import: %v\n", err)
+		return false
+	}
+	fi, err := os.Stat(path)
+	panicOn(err)
+
+	// without better, everything happened when the file was last written.
+	im := &importer{tm: fi.ModTime()}
+	lines := strings.Split(strings.Replace(string(by), "\r\n", "\n", -1), "\n")
+	kind := "script"
+	if isTranscript(lines) {
+		kind = "transcript"
+		im.splitTranscript(lines)
+	} else {
+		im.splitScript(lines)
+	}
+
+	h := NewHashRBook(username, hostname, out)
+	var elems []*HashRElem
+	lastCommandLineNum := 0
+	var nimg, nmissing int
+	for _, p := range im.pieces {
+		if p == nil {
+			continue
+		}
+		seqno := len(elems)
+		e := &HashRElem{Typ: p.typ, Tm: p.tm, Seqno: seqno}
+		switch p.typ {
+		case Command:
+			msg, numlines := prepCommandMessage(p.lines[0], seqno)
+			e.CmdJSON = msg
+			e.msg = []byte(msg)
+			e.BeginCommandLineNum = lastCommandLineNum + 1
+			e.NumCommandLines = numlines
+			lastCommandLineNum += numlines
+		case Console:
+			capture := make([]string, len(p.lines))
+			for i, line := range p.lines {
+				capture[i] = "## " + line
+			}
+			cj, err := json.Marshal(capture)
+			panicOn(err)
+			msg := prepConsoleMessage(string(cj), seqno)
+			e.ConsoleJSON = msg
+			e.msg = []byte(msg)
+		case Comment:
+			cmd := p.lines[0]
+			if !strings.HasPrefix(cmd, `"`) {
+				// from # lines; make the string literal
+				// form that prepCommentMessage expects.
+				cmd = `"#` + strings.Join(p.lines, `\n`) + `"`
+			}
+			msg := prepCommentMessage(cmd, seqno)
+			e.CommentJSON = msg
+			e.msg = []byte(msg)
+		case Image:
+			if !FileExists(p.imagePath) {
+				nmissing++
+				fmt.Fprintf(os.Stderr, "rbook -import: note: skipping plot '%v', which is no longer there.\n", p.imagePath)
+				continue
+			}
+			pathhash, imageBy := PathHash(p.imagePath)
+			e.ImageHost = hostname
+			e.ImagePath = p.imagePath
+			e.ImageBy = imageBy
+			e.ImagePathHash = pathhash
+			e.ImageJSON = prepImageMessage(p.imagePath, pathhash, seqno)
+			e.msg = []byte(e.ImageJSON)
+			nimg++
+		}
+		elems = append(elems, e)
+	}
+	if len(elems) > 0 && elems[0].Tm.Before(h.CreateTm) {
+		h.CreateTm = elems[0].Tm
+	}
+	h.chainHead = h.chainAnchor()
+	for _, e := range elems {
+		h.appendElem(e)
+	}
+
+	fd := h.DeletePathAndReSaveFullBook(out)
+	panicOn(fd.Close())
+
+	fmt.Printf("rbook -import: wrote '%v' (BookID %v) from %v '%v': %v elements, %v command lines, %v plots (%v missing).\n", out, h.BookID, kind, path, len(h.elems), lastCommandLineNum, nimg, nmissing)
+	return true
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+// importTestBook runs rbook -import on text, written to a file
+// named name in dir, and loads the book it makes.
+func importTestBook(dir, name, text string) *HashRBook {
+	src := filepath.Join(dir, name)
+	panicOn(ioutil.WriteFile(src, []byte(text), 0660))
+	out := src + ".rbook"
+	cfg := &RbookConfig{Out: out}
+	if !cfg.importBook(src) {
+		panic("importBook failed on " + src)
+	}
+	h, err := loadBookReadOnly(out)
+	panicOn(err)
+	return h
+}
+
+func decodedElems(h *HashRBook) (res []*DecodeJSON) {
+	for _, e := range h.elems {
+		d, err := decodeElem(e)
+		panicOn(err)
+		res = append(res, d)
+	}
+	return
+}
+
+func TestImport(t *testing.T) {
+
+	cv.Convey("rbook -import should split an R script into top-level commands, with ## lines as console output and # lines and \"# strings as comments", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-import")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		h := importTestBook(dir, "analysis.R", `# load the data
+x <- c(1,
+       2, 3)
+## [1] 1 2 3
+f <- function(a) {
+  # the { is not closed yet
+  a + 1
+}
+if (x[1] > 0)
+  print("yes # not a comment")
+"#why we stop here"
+y <- x %>%
+  sum
+`)
+		d := decodedElems(h)
+		cv.So(len(d), cv.ShouldEqual, 7)
+		cv.So(h.elems[0].Typ, cv.ShouldEqual, Comment)
+		cv.So(d[0].Comment[0], cv.ShouldEqual, "### load the data")
+		cv.So(h.elems[1].Typ, cv.ShouldEqual, Command)
+		cv.So(d[1].Command, cv.ShouldResemble, []string{"x <- c(1,", "       2, 3)"})
+		cv.So(h.elems[1].BeginCommandLineNum, cv.ShouldEqual, 1)
+		cv.So(h.elems[2].Typ, cv.ShouldEqual, Console)
+		cv.So(d[2].Console, cv.ShouldResemble, []string{"## [1] 1 2 3"})
+		cv.So(len(d[3].Command), cv.ShouldEqual, 4)
+		cv.So(h.elems[3].BeginCommandLineNum, cv.ShouldEqual, 3)
+		cv.So(d[4].Command, cv.ShouldResemble, []string{"if (x[1] > 0)", `  print("yes # not a comment")`})
+		cv.So(h.elems[5].Typ, cv.ShouldEqual, Comment)
+		cv.So(d[5].Comment[0], cv.ShouldEqual, "### why we stop here")
+		cv.So(d[6].Command, cv.ShouldResemble, []string{"y <- x %>%", "  sum"})
+
+		// the chain holds, as for any other book.
+		res, ok := verifyFrames(filepath.Join(dir, "analysis.R.rbook"))
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(res.checked, cv.ShouldEqual, 7)
+	})
+
+	cv.Convey("rbook -import of an .rsh script that rbook wrote should take the timestamps from its command line markers", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-import")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+
+		cur, err := os.Getwd()
+		panicOn(err)
+		rsh := dumpToString(filepath.Join(cur, "testdata", "book-format1.rbook"))
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cur)
+
+		h := importTestBook(dir, "my.rbook.rsh", rsh)
+		d := decodedElems(h)
+		cv.So(h.elems[0].Typ, cv.ShouldEqual, Command)
+		cv.So(d[0].Command, cv.ShouldResemble, []string{"x <- c(1,2,3)"})
+
+		want, err := time.Parse(RFC3339MicroNumericTZ, "2023-09-12T05:00:01.000000-05:00")
+		panicOn(err)
+		cv.So(h.elems[0].Tm.Equal(want), cv.ShouldBeTrue)
+		cv.So(h.CreateTm.Equal(want), cv.ShouldBeTrue)
+
+		var sawPlot bool
+		for i, e := range h.elems {
+			if e.Typ == Command && d[i].Command[0] == "plot(x)" {
+				sawPlot = true
+				want, err := time.Parse(RFC3339MicroNumericTZ, "2023-09-12T05:00:05.000000-05:00")
+				panicOn(err)
+				cv.So(e.Tm.Equal(want), cv.ShouldBeTrue)
+			}
+			// the header is not imported.
+			if e.Typ == Command {
+				cv.So(d[i].Command[0] == "require(png)", cv.ShouldBeFalse)
+			}
+		}
+		cv.So(sawPlot, cv.ShouldBeTrue)
+	})
+
+	cv.Convey("rbook -import of an ESS transcript should take commands from the > and + prompts, and what follows as their output", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-import")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+
+		cur, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cur)
+
+		h := importTestBook(dir, "session.txt", `> x <- c(1,
++ 2, 3)
+> x
+[1] 1 2 3
+> "#x looks right"
+[1] "#x looks right"
+> summary(x)
+   Min. 1st Qu.  Median
+      1     1.5       2
+>
+`)
+		d := decodedElems(h)
+		cv.So(len(d), cv.ShouldEqual, 6)
+		cv.So(d[0].Command, cv.ShouldResemble, []string{"x <- c(1,", "2, 3)"})
+		cv.So(d[1].Command, cv.ShouldResemble, []string{"x"})
+		cv.So(d[2].Console, cv.ShouldResemble, []string{"## [1] 1 2 3"})
+		cv.So(h.elems[3].Typ, cv.ShouldEqual, Comment)
+		cv.So(d[4].Command, cv.ShouldResemble, []string{"summary(x)"})
+		cv.So(d[5].Console, cv.ShouldResemble, []string{"##    Min. 1st Qu.  Median", "##       1     1.5       2"})
+
+		// and we never overwrite a book.
+		cfg := &RbookConfig{Out: filepath.Join(dir, "session.txt.rbook")}
+		cv.So(cfg.importBook(filepath.Join(dir, "session.txt")), cv.ShouldBeFalse)
+	})
+}
@@ -0,0 +1,207 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/glycerine/greenpack/msgp"
+)
+
+// indexEntry locates one element frame within the book file.
+type indexEntry struct {
+	Seqno  int
+	Offset int64
+}
+
+// The seqno -> offset index lives beside the book in <book>.idx,
+// as plain text so it is easy to eyeball:
+//
+//	rbook-index 1 <BookID>
+//	<seqno> <offset>
+//	<seqno> <offset>
+//	...
+//
+// ReadBook already visits every frame, so it rebuilds the
+// index as it goes, and rewrites the .idx file if that was
+// missing or stale. archiveElem appends a line per new element.
+const indexHeaderPrefix = "rbook-index 1 "
+
+func indexPathFor(bookpath string) string {
+	return bookpath + ".idx"
+}
+
+// writeIndexFile replaces the .idx file for bookpath with ents.
+func writeIndexFile(bookpath, bookID string, ents []indexEntry) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%v%v\n", indexHeaderPrefix, bookID)
+	for _, ent := range ents {
+		fmt.Fprintf(&buf, "%v %v\n", ent.Seqno, ent.Offset)
+	}
+	path := indexPathFor(bookpath)
+	tmp := path + ".tmp"
+	err := ioutil.WriteFile(tmp, buf.Bytes(), 0660)
+	if err != nil {
+		return fmt.Errorf("writeIndexFile() error: '%s'", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// readIndexFile returns the entries in the .idx file for bookpath,
+// which must be for the book with bookID. A torn last line,
+// from a crash during append, is ignored.
+func readIndexFile(bookpath, bookID string) (ents []indexEntry, err error) {
+	fd, err := os.Open(indexPathFor(bookpath))
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	r := bufio.NewReader(fd)
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("readIndexFile() error reading header: '%s'", err)
+	}
+	if strings.TrimSpace(header) != indexHeaderPrefix+bookID {
+		return nil, fmt.Errorf("readIndexFile() error: index '%v' is not for BookID '%v'", indexPathFor(bookpath), bookID)
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			// any partial line without its newline was torn.
+			return ents, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var ent indexEntry
+		_, err = fmt.Sscanf(line, "%d %d", &ent.Seqno, &ent.Offset)
+		if err != nil {
+			return nil, fmt.Errorf("readIndexFile() error on line '%v': '%s'", strings.TrimSpace(line), err)
+		}
+		ents = append(ents, ent)
+	}
+}
+
+func sameIndex(a, b []indexEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// saveIndexIfStale rewrites the .idx file from h.index, unless it
+// already says the same thing. Caller holds h.mut, or has
+// not shared h yet.
+func (h *HashRBook) saveIndexIfStale(bookpath string) {
+	onDisk, err := readIndexFile(bookpath, h.BookID)
+	if err == nil && sameIndex(onDisk, h.index) {
+		return
+	}
+	err = writeIndexFile(bookpath, h.BookID, h.index)
+	if err != nil {
+		// the index is only an optimization; carry on without it.
+		vvlog("could not save index for '%v': '%v'", bookpath, err)
+	}
+}
+
+// noteFrame records that the element with seqno was just
+// appended to the book at offset. Caller holds h.mut.
+func (h *HashRBook) noteFrame(seqno int, offset int64) {
+	ent := indexEntry{Seqno: seqno, Offset: offset}
+	h.index = append(h.index, ent)
+
+	fd, err := os.OpenFile(indexPathFor(h.diskPath), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0660)
+	if err == nil {
+		_, err = fmt.Fprintf(fd, "%v %v\n", ent.Seqno, ent.Offset)
+		fd.Close()
+	}
+	if err != nil {
+		vvlog("could not append to index for '%v': '%v'", h.diskPath, err)
+	}
+}
+
+// readFrameAt decodes the element frame that starts at offset in fd.
+func readFrameAt(fd *os.File, offset int64) (*HashRElem, error) {
+	sz, err := fd.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if offset >= sz {
+		return nil, fmt.Errorf("readFrameAt() error: offset %v is past the end of the book (%v bytes)", offset, sz)
+	}
+	return LoadElem(msgp.NewReader(io.NewSectionReader(fd, offset, sz-offset)))
+}
+
+// readMsgAt reads from the element frame at offset in fd only what
+// a replay page needs: the element's type, seqno, first command
+// line, and the message the browser shows. Everything else, image
+// bytes included, is skipped over on disk, not decoded; nor is
+// the checksum checked.
+func readMsgAt(fd *os.File, offset int64) (*HashRElem, error) {
+	sz, err := fd.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if offset >= sz {
+		return nil, fmt.Errorf("readMsgAt() error: offset %v is past the end of the book (%v bytes)", offset, sz)
+	}
+	r := msgp.NewReader(io.NewSectionReader(fd, offset, sz-offset))
+	_, err = r.ReadBytesHeader()
+	if err != nil {
+		return nil, fmt.Errorf("readMsgAt() error on frame header at offset %v: '%s'", offset, err)
+	}
+	nfield, err := r.ReadMapHeader()
+	if err != nil {
+		return nil, fmt.Errorf("readMsgAt() error on element at offset %v: '%s'", offset, err)
+	}
+	e := &HashRElem{}
+	for i := uint32(0); i < nfield; i++ {
+		key, err := r.ReadMapKeyPtr()
+		if err != nil {
+			return nil, fmt.Errorf("readMsgAt() error on element at offset %v: '%s'", offset, err)
+		}
+		switch string(key) {
+		case "type_zid00_rct":
+			var typ int
+			typ, err = r.ReadInt()
+			e.Typ = HashRTyp(typ)
+		case "seqno_zid02_int":
+			e.Seqno, err = r.ReadInt()
+		case "beginCommandLineNum_zid11_int":
+			e.BeginCommandLineNum, err = r.ReadInt()
+		case "cmdJSON_zid03_str":
+			e.CmdJSON, err = r.ReadString()
+		case "consoleJSON_zid04_str":
+			e.ConsoleJSON, err = r.ReadString()
+		case "imageJSON_zid05_str":
+			e.ImageJSON, err = r.ReadString()
+		case "commentJSON_zid06_str":
+			e.CommentJSON, err = r.ReadString()
+		case "errorJSON_zid21_str":
+			e.ErrorJSON, err = r.ReadString()
+		case "tableJSON_zid32_str":
+			e.TableJSON, err = r.ReadString()
+		case "widgetJSON_zid33_str":
+			e.WidgetJSON, err = r.ReadString()
+		default:
+			err = r.Skip()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("readMsgAt() error on element at offset %v: '%s'", offset, err)
+		}
+	}
+	e.setMsg()
+	return e, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// waitInput blocks until console input is ready to read, without
+// reading it, and returns true; or until halt can be read, as
+// when its write end is closed, and returns false.
+func waitInput(halt *os.File) bool {
+	hfd := int(halt.Fd())
+	for {
+		var r syscall.FdSet
+		fdSet(&r, 0)
+		fdSet(&r, hfd)
+		_, err := syscall.Select(hfd+1, &r, nil, nil, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || fdIsSet(&r, hfd) {
+			return false
+		}
+		if fdIsSet(&r, 0) {
+			return true
+		}
+	}
+}
+
+const nfdbits = 8 * int(unsafe.Sizeof(syscall.FdSet{}.Bits[0]))
+
+func fdSet(s *syscall.FdSet, fd int) {
+	s.Bits[fd/nfdbits] |= 1 << uint(fd%nfdbits)
+}
+
+func fdIsSet(s *syscall.FdSet, fd int) bool {
+	return s.Bits[fd/nfdbits]&(1<<uint(fd%nfdbits)) != 0
+}
+
+// processRSS returns the resident set size of the process now,
+// in bytes; 0 if we cannot tell. Reading /proc is cheap, and asks
+// nothing of R.
+func processRSS() int64 {
+	by, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0
+	}
+	f := strings.Fields(string(by))
+	if len(f) < 2 {
+		return 0
+	}
+	pages, err := strconv.ParseInt(f[1], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return pages * int64(os.Getpagesize())
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestWallTimeFromInput(t *testing.T) {
+
+	cv.Convey("a command's wall time should run from when its input arrived, not from the prompt", t, func() {
+
+		// stand in for the console on fd 0.
+		r, w, err := os.Pipe()
+		panicOn(err)
+		defer r.Close()
+		defer w.Close()
+		saved, err := syscall.Dup(0)
+		panicOn(err)
+		panicOn(syscall.Dup3(int(r.Fd()), 0, 0))
+		defer func() {
+			syscall.Dup3(saved, 0, 0)
+			syscall.Close(saved)
+		}()
+
+		m := startCmdMeter()
+		time.Sleep(300 * time.Millisecond) // at the prompt.
+		_, err = w.Write([]byte("x <- 1\n"))
+		panicOn(err)
+		time.Sleep(100 * time.Millisecond) // running.
+		m.stop()
+		cv.So(m.wall >= 90*time.Millisecond && m.wall < 250*time.Millisecond, cv.ShouldBeTrue)
+
+		// input R already holds leaves nothing to see: from the call.
+		r.Read(make([]byte, 100))
+		m = startCmdMeter()
+		time.Sleep(100 * time.Millisecond)
+		m.stop()
+		cv.So(m.wall >= 90*time.Millisecond, cv.ShouldBeTrue)
+
+		// the watch sleeps until input comes or stop() wakes it;
+		// either way it is gone after.
+		halt, wake, err := os.Pipe()
+		panicOn(err)
+		done := make(chan bool)
+		go func() { done <- waitInput(halt) }()
+		select {
+		case <-done:
+			panic("waitInput returned with no input")
+		case <-time.After(100 * time.Millisecond):
+		}
+		wake.Close()
+		cv.So(<-done, cv.ShouldBeFalse)
+		halt.Close()
+
+		_, err = w.Write([]byte("y <- 2\n"))
+		panicOn(err)
+		halt, wake, err = os.Pipe()
+		panicOn(err)
+		cv.So(waitInput(halt), cv.ShouldBeTrue)
+		halt.Close()
+		wake.Close()
+	})
+}
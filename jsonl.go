@@ -0,0 +1,669 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/glycerine/greenpack/msgp"
+)
+
+// rbook -dump-jsonl writes a book as JSON Lines, for jq, Python,
+// or R: a first line for the header, then one line per element.
+//
+//	{"rbookJSONL":1,"bookID":"...","createTm":"...",...}
+//	{"typ":"Command","typNum":1,"tm":"...","seqno":0,"command":["x <- 1"],...}
+//
+// Each element line has both the decoded command, console,
+// comment, or note, for reading; and the fields exactly as they
+// are in the book (cmdJSON and friends), for rbook -load-jsonl
+// to rebuild the book byte for byte. To change a book, edit the
+// decoded forms; they win over the others. -load-jsonl then
+// re-links the hash chain from the first edit on, and says so.
+
+// jsonlVersion is the version of the JSON Lines form, in rbookJSONL.
+const jsonlVersion = 1
+
+// jsonlBook is the first line: the book header.
+type jsonlBook struct {
+	RbookJSONL    int       `json:"rbookJSONL"`
+	BookID        string    `json:"bookID"`
+	CreateTm      time.Time `json:"createTm"`
+	User          string    `json:"user"`
+	Host          string    `json:"host"`
+	Path          string    `json:"path"`
+	FormatVersion int       `json:"formatVersion"`
+	Checksum      string    `json:"checksum,omitempty"`
+	ParentBookID  string    `json:"parentBookID,omitempty"`
+
+	// fields from a newer rbook, as msgpack.
+	Unknown []byte `json:"unknown,omitempty"`
+}
+
+// jsonlElem is the line for one HashRElem.
+type jsonlElem struct {
+	Typ    string    `json:"typ"`
+	TypNum HashRTyp  `json:"typNum"`
+	Tm     time.Time `json:"tm"`
+	Seqno  int       `json:"seqno"`
+
+	// decoded, for reading.
+	Command     []string `json:"command,omitempty"`
+	Console     []string `json:"console,omitempty"`
+	Stream      string   `json:"stream,omitempty"`
+	Comment     []string `json:"comment,omitempty"`
+	Note        string   `json:"note,omitempty"`
+	NoteOnSeqno *int     `json:"noteOnSeqno,omitempty"`
+	HideSeqno   *int     `json:"hideSeqno,omitempty"`
+
+	ErrorCommand []string `json:"errorCommand,omitempty"`
+	Error        []string `json:"error,omitempty"`
+	Traceback    []string `json:"traceback,omitempty"`
+
+	BeginCommandLineNum int `json:"beginCommandLineNum,omitempty"`
+	NumCommandLines     int `json:"numCommandLines,omitempty"`
+
+	// how long a command ran, and the memory it used.
+	WallNs      int64 `json:"wallNs,omitempty"`
+	CPUNs       int64 `json:"cpuNs,omitempty"`
+	RHeapBefore int64 `json:"rHeapBefore,omitempty"`
+	RHeapAfter  int64 `json:"rHeapAfter,omitempty"`
+	RHeapPeak   int64 `json:"rHeapPeak,omitempty"`
+	MaxRSS      int64 `json:"maxRSS,omitempty"`
+
+	// the png: inline, or in a file under -jsonl-images.
+	Image     []byte `json:"image,omitempty"`
+	ImageFile string `json:"imageFile,omitempty"`
+
+	// the type of the png, and any vector renderings; inline.
+	ImageMIME string `json:"imageMIME,omitempty"`
+	ImageSVG  []byte `json:"imageSVG,omitempty"`
+	ImagePDF  []byte `json:"imagePDF,omitempty"`
+
+	// the recordPlot(), as saveRDS() wrote it.
+	PlotRDS []byte `json:"plotRDS,omitempty"`
+
+	// a widget's self-contained html page.
+	WidgetHTML []byte `json:"widgetHTML,omitempty"`
+	WidgetHash string `json:"widgetHash,omitempty"`
+
+	ImageHost     string `json:"imageHost,omitempty"`
+	ImagePath     string `json:"imagePath,omitempty"`
+	ImagePathHash string `json:"imagePathHash,omitempty"`
+	ImageHash     string `json:"imageHash,omitempty"`
+
+	SourceHost   string `json:"sourceHost,omitempty"`
+	SourceBookID string `json:"sourceBookID,omitempty"`
+
+	// as they are in the book.
+	CmdJSON              string `json:"cmdJSON,omitempty"`
+	ConsoleJSON          string `json:"consoleJSON,omitempty"`
+	CommentJSON          string `json:"commentJSON,omitempty"`
+	ImageJSON            string `json:"imageJSON,omitempty"`
+	OverlayNoteJSON      string `json:"overlayNoteJSON,omitempty"`
+	OverlayHideSeqnoJSON string `json:"overlayHideSeqnoJSON,omitempty"`
+	ErrorJSON            string `json:"errorJSON,omitempty"`
+	TableJSON            string `json:"tableJSON,omitempty"`
+	WidgetJSON           string `json:"widgetJSON,omitempty"`
+	Checksum             string `json:"checksum,omitempty"`
+	PrevHash             string `json:"prevHash,omitempty"`
+
+	Unknown []byte `json:"unknown,omitempty"`
+}
+
+// dumpJSONL implements rbook -dump-jsonl: write the book at bookpath
+// as JSON Lines, to the -o path or else to stdout. Images go inline
+// as base64, or with -jsonl-images, into files in that directory.
+func (c *RbookConfig) dumpJSONL(bookpath string) (ok bool) {
+	r, err := OpenBookReader(bookpath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -dump-jsonl: %v\n", err)
+		return false
+	}
+	defer r.Close()
+
+	var fd *os.File
+	out := os.Stdout
+	if c.Out != "" {
+		fd, err = createAside(c.Out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rbook -dump-jsonl: %v\n", err)
+			return false
+		}
+		out = fd
+	}
+	fail := func(err error) bool {
+		fmt.Fprintf(os.Stderr, "rbook -dump-jsonl: %v\n", err)
+		if fd != nil {
+			fd.Close()
+			os.Remove(fd.Name())
+		}
+		return false
+	}
+	if c.JSONLImages != "" {
+		err = os.MkdirAll(c.JSONLImages, 0777)
+		if err != nil {
+			return fail(err)
+		}
+	}
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+
+	book := r.Book
+	err = enc.Encode(&jsonlBook{
+		RbookJSONL:    jsonlVersion,
+		BookID:        book.BookID,
+		CreateTm:      book.CreateTm,
+		User:          book.User,
+		Host:          book.Host,
+		Path:          book.Path,
+		FormatVersion: book.FormatVersion,
+		Checksum:      book.Checksum,
+		ParentBookID:  book.ParentBookID,
+		Unknown:       book.unknown.raw,
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	nelem := 0
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == TruncatedFrame {
+			fmt.Fprintf(os.Stderr, "rbook -dump-jsonl: note: '%v' has a torn last frame; ignoring it.\n", bookpath)
+			break
+		}
+		if err != nil {
+			return fail(fmt.Errorf("bad frame at offset %v: '%v'", r.Offset(), err))
+		}
+		je, err := c.jsonlElemFor(book, e)
+		if err != nil {
+			return fail(err)
+		}
+		err = enc.Encode(je)
+		if err != nil {
+			return fail(err)
+		}
+		nelem++
+	}
+	err = w.Flush()
+	if err != nil {
+		return fail(err)
+	}
+	if fd != nil {
+		err = finishAside(fd, c.Out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rbook -dump-jsonl: %v\n", err)
+			return false
+		}
+		fmt.Printf("rbook -dump-jsonl: wrote %v elements of '%v' to '%v'\n", nelem, bookpath, c.Out)
+	}
+	return true
+}
+
+// jsonlElemFor makes the line for e, writing its image
+// under -jsonl-images if need be.
+func (c *RbookConfig) jsonlElemFor(book *HashRBook, e *HashRElem) (je *jsonlElem, err error) {
+	je = &jsonlElem{
+		Typ:                  e.Typ.String(),
+		TypNum:               e.Typ,
+		Tm:                   e.Tm,
+		Seqno:                e.Seqno,
+		BeginCommandLineNum:  e.BeginCommandLineNum,
+		NumCommandLines:      e.NumCommandLines,
+		WallNs:               e.WallNs,
+		CPUNs:                e.CPUNs,
+		RHeapBefore:          e.RHeapBefore,
+		RHeapAfter:           e.RHeapAfter,
+		RHeapPeak:            e.RHeapPeak,
+		MaxRSS:               e.MaxRSS,
+		ImageMIME:            e.ImageMIME,
+		ImageHost:            e.ImageHost,
+		ImagePath:            e.ImagePath,
+		ImagePathHash:        e.ImagePathHash,
+		ImageHash:            e.ImageHash,
+		SourceHost:           e.SourceHost,
+		SourceBookID:         e.SourceBookID,
+		CmdJSON:              e.CmdJSON,
+		ConsoleJSON:          e.ConsoleJSON,
+		CommentJSON:          e.CommentJSON,
+		ImageJSON:            e.ImageJSON,
+		OverlayNoteJSON:      e.OverlayNoteJSON,
+		OverlayHideSeqnoJSON: e.OverlayHideSeqnoJSON,
+		ErrorJSON:            e.ErrorJSON,
+		TableJSON:            e.TableJSON,
+		WidgetJSON:           e.WidgetJSON,
+		WidgetHash:           e.WidgetHash,
+		Checksum:             e.Checksum,
+		PrevHash:             e.PrevHash,
+		Unknown:              e.unknown.raw,
+	}
+	d, err := decodeElem(e)
+	if err != nil {
+		return nil, err
+	}
+	if d != nil {
+		je.Command, je.Console, je.Comment = d.Command, d.Console, d.Comment
+		je.Stream = d.Stream
+		je.ErrorCommand, je.Error, je.Traceback = d.ErrorCommand, d.Error, d.Traceback
+	}
+
+	switch e.Typ {
+	case OverlayLaterNote:
+		n := &overlayNoteJSON{}
+		err = decodeLenPrefixed(e.OverlayNoteJSON, n)
+		if err != nil {
+			return nil, fmt.Errorf("note on seqno %v: '%s'", e.Seqno, err)
+		}
+		je.Note = n.OverlayNote
+		je.NoteOnSeqno = &n.OverlayOnSeqno
+	case OverlayHideOutput:
+		hide := e.OverlayHideSeqno
+		je.HideSeqno = &hide
+	}
+	if e.OverlayHideSeqno != 0 && je.HideSeqno == nil {
+		// never written so by rbook; but keep it.
+		hide := e.OverlayHideSeqno
+		je.HideSeqno = &hide
+	}
+
+	if e.Typ == Widget {
+		je.WidgetHTML, err = book.widgetHTML(e)
+		if err != nil {
+			return nil, fmt.Errorf("widget for seqno %v: '%s'", e.Seqno, err)
+		}
+	}
+
+	if e.Typ == Image || len(e.ImageBy) > 0 || e.ImageHash != "" {
+		by, err := book.imageBytes(e)
+		if err != nil {
+			return nil, fmt.Errorf("image for seqno %v: '%s'", e.Seqno, err)
+		}
+		if c.JSONLImages == "" || len(by) == 0 {
+			je.Image = by
+		} else {
+			je.ImageFile = filepath.Join(c.JSONLImages, fmt.Sprintf("seqno-%04d.png", e.Seqno))
+			err = ioutil.WriteFile(je.ImageFile, by, 0660)
+			if err != nil {
+				return nil, err
+			}
+		}
+		je.ImageSVG, err = book.renderBytes(e, mimeSVG)
+		if err != nil {
+			return nil, fmt.Errorf("svg for seqno %v: '%s'", e.Seqno, err)
+		}
+		je.ImagePDF, err = book.renderBytes(e, mimePDF)
+		if err != nil {
+			return nil, fmt.Errorf("pdf for seqno %v: '%s'", e.Seqno, err)
+		}
+		je.PlotRDS, err = book.plotRecord(e)
+		if err != nil {
+			return nil, fmt.Errorf("recordPlot() for seqno %v: '%s'", e.Seqno, err)
+		}
+	}
+	return je, nil
+}
+
+// loadJSONL implements rbook -load-jsonl: build the book at the -o
+// path from the JSON Lines at path, as written by -dump-jsonl. An
+// unedited dump gives back the original book, byte for byte.
+func (c *RbookConfig) loadJSONL(path string) (ok bool) {
+	out := c.Out
+	if FileExists(out) {
+		fmt.Fprintf(os.Stderr, "rbook -load-jsonl: refusing to overwrite existing '%v'\n", out)
+		return false
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -load-jsonl: %v\n", err)
+		return false
+	}
+	defer in.Close()
+
+	fd, err := createAside(out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -load-jsonl: %v\n", err)
+		return false
+	}
+	fail := func(err error) bool {
+		fmt.Fprintf(os.Stderr, "rbook -load-jsonl: %v\n", err)
+		fd.Close()
+		os.Remove(fd.Name())
+		return false
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(in))
+	jb := &jsonlBook{}
+	err = dec.Decode(jb)
+	if err != nil {
+		return fail(fmt.Errorf("could not read the header line: '%s'", err))
+	}
+	if jb.RbookJSONL == 0 || jb.RbookJSONL > jsonlVersion {
+		return fail(fmt.Errorf("'%v' does not start with an rbookJSONL %v header line", path, jsonlVersion))
+	}
+	book := &HashRBook{
+		CreateTm:      jb.CreateTm,
+		BookID:        jb.BookID,
+		User:          jb.User,
+		Host:          jb.Host,
+		Path:          jb.Path,
+		FormatVersion: jb.FormatVersion,
+		Checksum:      jb.Checksum,
+		ParentBookID:  jb.ParentBookID,
+		blobs:         newBlobStore(out),
+	}
+	book.unknown, err = unknownFromRaw(jb.Unknown)
+	if err != nil {
+		return fail(fmt.Errorf("header: bad unknown fields: '%s'", err))
+	}
+	legacy := book.FormatVersion < chainFormatVersion
+	if book.Checksum != "" || !legacy {
+		sum, err := book.ComputeChecksum()
+		panicOn(err)
+		if sum != book.Checksum {
+			book.Checksum = sum
+			fmt.Fprintf(os.Stderr, "rbook -load-jsonl: note: the header was edited; its checksum is updated.\n")
+		}
+	}
+
+	w := bufio.NewWriter(fd)
+	offset, err := writeFrameAsIs(w, book)
+	if err != nil {
+		return fail(err)
+	}
+
+	head := book.chainAnchor()
+	relinkFrom := -1
+	var index []indexEntry
+	for line := 2; ; line++ {
+		je := &jsonlElem{}
+		err = dec.Decode(je)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(fmt.Errorf("line %v: '%s'", line, err))
+		}
+		e, err := c.elemFromJSONL(je, path, book)
+		if err != nil {
+			return fail(fmt.Errorf("line %v: '%s'", line, err))
+		}
+
+		// keep the chain intact around any edit.
+		if e.Checksum != "" || !legacy {
+			sum, err := e.ComputeChecksum()
+			panicOn(err)
+			if sum != e.Checksum || (e.PrevHash != "" && e.PrevHash != head) || (!legacy && e.PrevHash == "") {
+				if relinkFrom < 0 {
+					relinkFrom = e.Seqno
+				}
+				e.PrevHash = head
+				e.Checksum, err = e.ComputeChecksum()
+				panicOn(err)
+			}
+		}
+		head, err = chainLink(head, e, true, legacy)
+		panicOn(err)
+
+		n, err := writeFrameAsIs(w, e)
+		if err != nil {
+			return fail(err)
+		}
+		index = append(index, indexEntry{Seqno: e.Seqno, Offset: offset})
+		offset += n
+	}
+	err = w.Flush()
+	if err == nil {
+		err = fd.Sync()
+	}
+	if err == nil {
+		err = finishAside(fd, out)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -load-jsonl: %v\n", err)
+		return false
+	}
+	err = writeIndexFile(out, book.BookID, index)
+	if err != nil {
+		// only an index; ReadBook will make it again.
+		vvlog("rbook -load-jsonl: %v", err)
+	}
+	if relinkFrom >= 0 {
+		fmt.Fprintf(os.Stderr, "rbook -load-jsonl: note: elements were edited; re-linked the hash chain from seqno %v on, so rbook -attest will give a new head.\n", relinkFrom)
+	}
+	fmt.Printf("rbook -load-jsonl: wrote '%v' (BookID %v): %v elements.\n", out, book.BookID, len(index))
+	return true
+}
+
+// elemFromJSONL makes the HashRElem for je. The fields as they
+// were in the book win; a line written by hand, with only the
+// decoded command, console, or comment, gets them made afresh.
+func (c *RbookConfig) elemFromJSONL(je *jsonlElem, path string, book *HashRBook) (e *HashRElem, err error) {
+	typ := je.TypNum
+	if typ == 0 {
+		typ = typFromName(je.Typ)
+		if typ == 0 {
+			return nil, fmt.Errorf("unknown typ '%v'", je.Typ)
+		}
+	}
+	e = &HashRElem{
+		Typ:                  typ,
+		Tm:                   je.Tm,
+		Seqno:                je.Seqno,
+		CmdJSON:              je.CmdJSON,
+		ConsoleJSON:          je.ConsoleJSON,
+		CommentJSON:          je.CommentJSON,
+		ImageJSON:            je.ImageJSON,
+		ImageMIME:            je.ImageMIME,
+		ImageSVG:             je.ImageSVG,
+		ImagePDF:             je.ImagePDF,
+		PlotRDS:              je.PlotRDS,
+		ImageHost:            je.ImageHost,
+		ImagePath:            je.ImagePath,
+		ImagePathHash:        je.ImagePathHash,
+		ImageHash:            je.ImageHash,
+		SourceHost:           je.SourceHost,
+		SourceBookID:         je.SourceBookID,
+		BeginCommandLineNum:  je.BeginCommandLineNum,
+		NumCommandLines:      je.NumCommandLines,
+		WallNs:               je.WallNs,
+		CPUNs:                je.CPUNs,
+		RHeapBefore:          je.RHeapBefore,
+		RHeapAfter:           je.RHeapAfter,
+		RHeapPeak:            je.RHeapPeak,
+		MaxRSS:               je.MaxRSS,
+		OverlayNoteJSON:      je.OverlayNoteJSON,
+		OverlayHideSeqnoJSON: je.OverlayHideSeqnoJSON,
+		ErrorJSON:            je.ErrorJSON,
+		TableJSON:            je.TableJSON,
+		WidgetJSON:           je.WidgetJSON,
+		WidgetHTML:           je.WidgetHTML,
+		WidgetHash:           je.WidgetHash,
+		Checksum:             je.Checksum,
+		PrevHash:             je.PrevHash,
+	}
+	if je.HideSeqno != nil {
+		e.OverlayHideSeqno = *je.HideSeqno
+	}
+	e.unknown, err = unknownFromRaw(je.Unknown)
+	if err != nil {
+		return nil, fmt.Errorf("bad unknown fields: '%s'", err)
+	}
+
+	// an edited command, console, comment, note, or error wins over
+	// the form it was in in the book; so does one written anew.
+	switch typ {
+	case Command:
+		if len(je.Command) > 0 && !jsonlSame(e.CmdJSON, je.Command, nil) {
+			e.CmdJSON, e.NumCommandLines = prepCommandMessage(strings.Join(je.Command, "\n"), e.Seqno)
+			e.CmdJSON = withStats(e.CmdJSON, e)
+		}
+	case Console:
+		if len(je.Console) > 0 && !jsonlSame(e.ConsoleJSON, je.Console, nil) {
+			by, err := json.Marshal(je.Console)
+			panicOn(err)
+			e.ConsoleJSON = prepConsoleMessage(string(by), e.Seqno)
+			if je.Stream == "stderr" {
+				// already in their ## form; see prepStderrMessage.
+				msg := fmt.Sprintf(`{"seqno": %v, "console":%v, "stream":"stderr"}`, e.Seqno, string(by))
+				e.ConsoleJSON = fmt.Sprintf("%v:%v", len(msg), msg)
+			}
+		}
+	case Comment:
+		if len(je.Comment) > 0 && !jsonlSame(e.CommentJSON, je.Comment, nil) {
+			// already in their ### form; see prepCommentMessage.
+			by, err := json.Marshal(je.Comment)
+			panicOn(err)
+			msg := fmt.Sprintf(`{"seqno": %v, "comment":%v}`, e.Seqno, string(by))
+			e.CommentJSON = fmt.Sprintf("%v:%v", len(msg), msg)
+		}
+	case OverlayLaterNote:
+		if je.NoteOnSeqno != nil && !jsonlSame(e.OverlayNoteJSON, nil, je) {
+			e.OverlayNoteJSON = prepOverlayLaterNoteMessage(je.Note, e.Seqno, *je.NoteOnSeqno)
+		}
+	case Error:
+		if len(je.Error) > 0 {
+			d := &DecodeJSON{}
+			if decodeLenPrefixed(e.ErrorJSON, d) != nil ||
+				!sameLines(d.ErrorCommand, je.ErrorCommand) ||
+				!sameLines(d.Error, je.Error) ||
+				!sameLines(d.Traceback, je.Traceback) {
+				e.ErrorJSON = prepErrorMessage(je.ErrorCommand, je.Error, je.Traceback, e.Seqno)
+			}
+		}
+	}
+
+	by := je.Image
+	if len(by) == 0 && je.ImageFile != "" {
+		imagePath := je.ImageFile
+		if !filepath.IsAbs(imagePath) && !FileExists(imagePath) {
+			// relative to the .jsonl file, then.
+			imagePath = filepath.Join(filepath.Dir(path), imagePath)
+		}
+		by, err = ioutil.ReadFile(imagePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(by) > 0 {
+		if e.ImageHash != "" {
+			// it was in the -blobs store; so it goes in ours.
+			_, err = book.blobs.put(by)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			e.ImageBy = by
+		}
+	}
+	return e, nil
+}
+
+// jsonlSame reports whether msg, a length-prefixed message as it
+// was in the book, still says what the decoded lines (or for a
+// note, je.Note and je.NoteOnSeqno) say.
+func jsonlSame(msg string, lines []string, je *jsonlElem) bool {
+	if msg == "" {
+		return false
+	}
+	if je != nil {
+		n := &overlayNoteJSON{}
+		err := decodeLenPrefixed(msg, n)
+		return err == nil && n.OverlayNote == je.Note && n.OverlayOnSeqno == *je.NoteOnSeqno
+	}
+	d := &DecodeJSON{}
+	err := decodeLenPrefixed(msg, d)
+	if err != nil {
+		return false
+	}
+	have := d.Command
+	if have == nil {
+		have = d.Console
+	}
+	if have == nil {
+		have = d.Comment
+	}
+	return sameLines(have, lines)
+}
+
+// sameLines reports whether a and b hold the same lines.
+func sameLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// typFromName is the HashRTyp whose String() is name; 0 if none.
+func typFromName(name string) HashRTyp {
+	for _, ty := range []HashRTyp{Command, Console, Image, Comment, OverlayLaterNote, OverlayHideOutput, Error, Table, Widget} {
+		if ty.String() == name {
+			return ty
+		}
+	}
+	var n int
+	if _, err := fmt.Sscanf(name, "HashRTyp(%d)", &n); err == nil {
+		return HashRTyp(n)
+	}
+	return 0
+}
+
+// unknownFromRaw makes the unknownFields for raw, a run
+// of msgpack map entries as kept by unknownFields.keep.
+func unknownFromRaw(raw []byte) (u unknownFields, err error) {
+	var nbs msgp.NilBitsStack
+	rest := raw
+	for len(rest) > 0 {
+		_, rest, err = nbs.ReadStringBytes(rest)
+		if err != nil {
+			return
+		}
+		rest, err = msgp.Skip(rest)
+		if err != nil {
+			return
+		}
+		u.n++
+	}
+	if u.n > 0 {
+		u.raw = raw
+	}
+	return
+}
+
+// marshaller is a HashRElem or HashRBook.
+type marshaller interface {
+	marshalAll() ([]byte, error)
+}
+
+// writeFrameAsIs writes the frame for m without filling in its
+// Checksum, as SaveToSlice would; so older frames that lack one
+// come back just as they were. n is the size of the frame.
+func writeFrameAsIs(w io.Writer, m marshaller) (n int64, err error) {
+	b, err := m.marshalAll()
+	if err != nil {
+		return 0, err
+	}
+	by, err := ByteSlice(b).MarshalMsg(nil)
+	if err != nil {
+		return 0, err
+	}
+	_, err = w.Write(by)
+	return int64(len(by)), err
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+// readJSONL returns the lines of the JSON Lines file at path.
+func readJSONL(path string) (lines []map[string]interface{}) {
+	fd, err := os.Open(path)
+	panicOn(err)
+	defer fd.Close()
+	scan := bufio.NewScanner(fd)
+	scan.Buffer(nil, 1<<24)
+	for scan.Scan() {
+		m := make(map[string]interface{})
+		panicOn(json.Unmarshal(scan.Bytes(), &m))
+		lines = append(lines, m)
+	}
+	panicOn(scan.Err())
+	return
+}
+
+func TestJSONL(t *testing.T) {
+
+	cv.Convey("rbook -dump-jsonl then -load-jsonl should give back every historical book format byte for byte", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-jsonl")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		for _, name := range goldenBooks {
+			path := filepath.Join(cwd, "testdata", name)
+			jsonl := filepath.Join(dir, name+".jsonl")
+			cfg := &RbookConfig{Out: jsonl}
+			cv.So(cfg.dumpJSONL(path), cv.ShouldBeTrue)
+
+			lines := readJSONL(jsonl)
+			cv.So(len(lines), cv.ShouldEqual, 8)
+			cv.So(lines[0]["bookID"], cv.ShouldEqual, "GoldenBookID0123456789ab")
+			cv.So(lines[1]["typ"], cv.ShouldEqual, "Command")
+
+			out := filepath.Join(dir, name)
+			cfg = &RbookConfig{Out: out}
+			cv.So(cfg.loadJSONL(jsonl), cv.ShouldBeTrue)
+			cv.So(bytes.Equal(mustReadFile(out), mustReadFile(path)), cv.ShouldBeTrue)
+			cv.So(FileExists(indexPathFor(out)), cv.ShouldBeTrue)
+		}
+	})
+
+	cv.Convey("rbook -dump-jsonl should decode each element for scripting, with -jsonl-images writing the plots to files; and -load-jsonl should take edits to the decoded forms and re-link the hash chain", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-jsonl")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "my.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		pngBy := writeExportTestBook(path)
+		orig := mustReadFile(path)
+
+		jsonl := filepath.Join(dir, "my.jsonl")
+		cfg := &RbookConfig{Out: jsonl, JSONLImages: "plots"}
+		cv.So(cfg.dumpJSONL(path), cv.ShouldBeTrue)
+
+		lines := readJSONL(jsonl)
+		cv.So(len(lines), cv.ShouldEqual, 11)
+		cv.So(lines[1]["command"], cv.ShouldResemble, []interface{}{"x <- c(1,2,3)"})
+		cv.So(lines[2]["console"], cv.ShouldResemble, []interface{}{"## [1] 1 2 3"})
+		cv.So(lines[8]["imageFile"], cv.ShouldEqual, filepath.Join("plots", "seqno-0007.png"))
+		cv.So(bytes.Equal(mustReadFile(filepath.Join(dir, "plots", "seqno-0007.png")), pngBy), cv.ShouldBeTrue)
+		cv.So(lines[9]["typ"], cv.ShouldEqual, "OverlayHideOutput")
+		cv.So(lines[9]["hideSeqno"], cv.ShouldEqual, float64(4))
+		cv.So(lines[10]["note"], cv.ShouldEqual, "x was <fine>")
+		cv.So(lines[10]["noteOnSeqno"], cv.ShouldEqual, float64(0))
+
+		copyPath := filepath.Join(dir, "copy.rbook")
+		cfg = &RbookConfig{Out: copyPath}
+		cv.So(cfg.loadJSONL(jsonl), cv.ShouldBeTrue)
+		cv.So(bytes.Equal(mustReadFile(copyPath), orig), cv.ShouldBeTrue)
+
+		// never over an existing book.
+		cv.So(cfg.loadJSONL(jsonl), cv.ShouldBeFalse)
+
+		// edit the first command, as jq might.
+		by := mustReadFile(jsonl)
+		all := strings.SplitAfter(string(by), "\n")
+		m := make(map[string]interface{})
+		panicOn(json.Unmarshal([]byte(all[1]), &m))
+		m["command"] = []string{"x <- c(4,5,6)"}
+		edited, err := json.Marshal(m)
+		panicOn(err)
+		all[1] = string(edited) + "\n"
+		panicOn(ioutil.WriteFile(jsonl, []byte(strings.Join(all, "")), 0660))
+
+		editPath := filepath.Join(dir, "edited.rbook")
+		cfg = &RbookConfig{Out: editPath}
+		cv.So(cfg.loadJSONL(jsonl), cv.ShouldBeTrue)
+
+		h, err := loadBookReadOnly(editPath)
+		panicOn(err)
+		d, err := decodeElem(h.elems[0])
+		panicOn(err)
+		cv.So(d.Command, cv.ShouldResemble, []string{"x <- c(4,5,6)"})
+		cv.So(len(h.elems), cv.ShouldEqual, 10)
+
+		res, ok := verifyFrames(editPath)
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(res.nelem, cv.ShouldEqual, 10)
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Under ReadBookLazy (rbook -lazy) the session keeps each element's
+// metadata and messages in memory. It does not keep the bytes of a
+// plot image, or the page of a widget. Those stay in the book file,
+// and are read back through h.index when wanted. So memory use
+// follows what the browser is looking at, not the lifetime of the
+// book.
+
+// startLazy opens the book at path for reading images back.
+func (h *HashRBook) startLazy(path string) {
+	fd, err := os.Open(path)
+	panicOn(err)
+	h.lazy = true
+	h.readFD = fd
+}
+
+// dropImage lets go of e's inline image bytes, any vector
+// renderings, and its recordPlot(); or of a Widget's page. These
+// are read back by loadLazyFrame when wanted.
+func (e *HashRElem) dropImage() {
+	if e.Typ == Widget && len(e.WidgetHTML) > 0 {
+		e.WidgetHTML = nil
+		e.lazyImage = true
+		return
+	}
+	if e.Typ == Image && (len(e.ImageBy) > 0 || len(e.ImageSVG) > 0 || len(e.ImagePDF) > 0 || len(e.PlotRDS) > 0) {
+		e.ImageBy = nil
+		e.ImageSVG = nil
+		e.ImagePDF = nil
+		e.PlotRDS = nil
+		e.lazyImage = true
+	}
+}
+
+// loadLazyImage reads e's image bytes back from the book file.
+// Caller holds h.mut.
+func (h *HashRBook) loadLazyImage(e *HashRElem) ([]byte, error) {
+	got, err := h.loadLazyFrame(e)
+	if err != nil {
+		return nil, err
+	}
+	return got.ImageBy, nil
+}
+
+// loadLazyFrame reads e back from the book file, in full.
+// Caller holds h.mut.
+func (h *HashRBook) loadLazyFrame(e *HashRElem) (*HashRElem, error) {
+	ents := h.index
+	i := sort.Search(len(ents), func(i int) bool { return ents[i].Seqno >= e.Seqno })
+	for ; i < len(ents) && ents[i].Seqno == e.Seqno; i++ {
+		got, err := readFrameAt(h.readFD, ents[i].Offset)
+		if err != nil {
+			return nil, err
+		}
+		if got.Typ == e.Typ && got.ImagePath == e.ImagePath && got.Checksum == e.Checksum {
+			return got, nil
+		}
+	}
+	return nil, fmt.Errorf("loadLazyImage() error: image for seqno %v not found in '%v'", e.Seqno, h.diskPath)
+}
+
+// materializeImages reads every dropped image back into memory,
+// for when we must rewrite the whole book. Caller holds h.mut.
+func (h *HashRBook) materializeImages() {
+	for _, e := range h.elems {
+		if !e.lazyImage {
+			continue
+		}
+		got, err := h.loadLazyFrame(e)
+		if err != nil {
+			vvlog("materializeImages(): lost image for seqno %v: '%v'", e.Seqno, err)
+			continue
+		}
+		e.ImageBy = got.ImageBy
+		e.ImageSVG = got.ImageSVG
+		e.ImagePDF = got.ImagePDF
+		e.PlotRDS = got.PlotRDS
+		e.WidgetHTML = got.WidgetHTML
+		e.lazyImage = false
+	}
+}
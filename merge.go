@@ -0,0 +1,267 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Working in the same git directory on several hosts gives one
+// book per host (my.rbook.<hostname>; see FinishConfig). rbook
+// -merge interleaves such books into one timeline, and /timeline
+// on the live page shows the same merge, read-only, of every
+// my.rbook.* book beside ours.
+
+// mergeSource is one of the books being merged.
+type mergeSource struct {
+	path string
+	book *HashRBook
+
+	next    int         // index in book.elems of the next to merge.
+	old2new map[int]int // seqno in this book -> seqno in the merge.
+}
+
+// mergeStats counts what mergeBooks did.
+type mergeStats struct {
+	nelem int
+	ndup  int // duplicate commands dropped, as -compact does.
+}
+
+// mergeBooks appends to out the elements of the books at paths,
+// interleaved by Tm, renumbering seqnos and command line numbers
+// as it goes. Each book keeps its own order. Each element records
+// the Host and BookID of its book in SourceHost and SourceBookID,
+// unless it already had them from an earlier merge. Images are
+// kept inline; or with blobs, in out's blob store.
+func mergeBooks(paths []string, out *HashRBook, blobs bool) (st *mergeStats, err error) {
+	st = &mergeStats{}
+	var srcs []*mergeSource
+	for _, path := range paths {
+		book, err := loadBookReadOnly(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read book '%v': '%v'", path, err)
+		}
+		if book.FormatVersion > BookFormatVersion {
+			// it may hold seqnos we do not know how to renumber.
+			return nil, fmt.Errorf("'%v' is format version %v, newer than this rbook knows (%v). Use a newer rbook.", path, book.FormatVersion, BookFormatVersion)
+		}
+		if len(srcs) == 0 || book.CreateTm.Before(out.CreateTm) {
+			out.CreateTm = book.CreateTm
+		}
+		srcs = append(srcs, &mergeSource{path: path, book: book, old2new: make(map[int]int)})
+	}
+	out.chainHead = out.chainAnchor()
+
+	lastCommandLineNum := 0
+	lastBegin := make([]int, len(srcs))
+	lastCommand := make([]int, len(srcs)) // seqno in out.
+	for {
+		// the earliest next element; on a tie, the book named first.
+		var src *mergeSource
+		k := -1
+		for i, s := range srcs {
+			if s.next == len(s.book.elems) {
+				continue
+			}
+			if src == nil || s.book.elems[s.next].Tm.Before(src.book.elems[src.next].Tm) {
+				src, k = s, i
+			}
+		}
+		if src == nil {
+			break
+		}
+		e := src.book.elems[src.next]
+		src.next++
+
+		if e.Typ == Command && e.BeginCommandLineNum > 0 {
+			if e.BeginCommandLineNum == lastBegin[k] {
+				// notes and hides on the duplicate go to the command we kept.
+				src.old2new[e.Seqno] = lastCommand[k]
+				st.ndup++
+				continue
+			}
+			lastBegin[k] = e.BeginCommandLineNum
+		}
+		ne, kept := reseqElem(e, len(out.elems), src.old2new)
+		if !kept {
+			continue
+		}
+		if ne.Typ == Command {
+			lastCommand[k] = ne.Seqno
+			ne.BeginCommandLineNum = lastCommandLineNum + 1
+			lastCommandLineNum += ne.NumCommandLines
+		}
+		if ne.SourceBookID == "" {
+			ne.SourceHost = src.book.Host
+			ne.SourceBookID = src.book.BookID
+		}
+		if ne.Typ == Image {
+			by, err := src.book.imageBytes(e)
+			if err != nil {
+				return nil, fmt.Errorf("'%v': image for seqno %v: '%v'", src.path, e.Seqno, err)
+			}
+			ne.ImageBy, ne.ImageHash = by, ""
+			if blobs {
+				ne.ImageHash, err = out.blobs.put(by)
+				if err != nil {
+					return nil, err
+				}
+				ne.ImageBy = nil
+			}
+		}
+		out.appendElem(ne)
+		st.nelem++
+	}
+	return st, nil
+}
+
+// mergeCmd implements rbook -merge: write the merge of the books
+// in c.MergeFrom to c.Out, as a new book.
+func (c *RbookConfig) mergeCmd() (ok bool) {
+	if FileExists(c.Out) {
+		fmt.Fprintf(os.Stderr, "rbook -merge: refusing to overwrite existing '%v'\n", c.Out)
+		return false
+	}
+	out := NewHashRBook(username, hostname, c.Out)
+	st, err := mergeBooks(c.MergeFrom, out, c.Blobs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -merge: %v\n", err)
+		return false
+	}
+	fd := out.DeletePathAndReSaveFullBook(c.Out)
+	panicOn(fd.Close())
+
+	fmt.Printf("rbook -merge: wrote '%v' (BookID %v): %v elements from %v books; dropped %v duplicate commands.\n", c.Out, out.BookID, st.nelem, len(c.MergeFrom), st.ndup)
+	return true
+}
+
+// siblingBooks returns the books beside the book at bookpath that
+// share its name up to the host suffix: for my.rbook.rog, all of
+// my.rbook and my.rbook.*. Companion files (.idx, .rsh, and the
+// like) are not books, and are left out; as are copies of a book
+// already found, such as a .pre-upgrade.
+func siblingBooks(bookpath string) (paths []string) {
+	dir, name := filepath.Split(bookpath)
+	base := name
+	if i := strings.LastIndex(name, ".rbook"); i >= 0 {
+		base = name[:i+len(".rbook")]
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, base+".*"))
+	if FileExists(filepath.Join(dir, base)) {
+		matches = append(matches, filepath.Join(dir, base))
+	}
+	sort.Strings(matches)
+	seen := make(map[string]bool)
+	for _, path := range matches {
+		if DirExists(path) {
+			continue
+		}
+		r, err := OpenBookReader(path)
+		if err != nil {
+			continue
+		}
+		r.Close()
+		if seen[r.Book.BookID] {
+			continue
+		}
+		seen[r.Book.BookID] = true
+		paths = append(paths, path)
+	}
+	return
+}
+
+// timelineCache keeps the last page /timeline served, good until
+// a book in it changes size or mtime, or a book comes or goes.
+var timelineCache = struct {
+	mut  sync.Mutex
+	key  string
+	page []byte
+}{}
+
+// timelineKey says which books, at which sizes and mtimes, a
+// timeline page was made from.
+func timelineKey(paths []string) (key string, err error) {
+	var b strings.Builder
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%v %v %v\n", path, fi.Size(), fi.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+// serveTimeline writes, as one html page, the merge of every
+// book beside the book at bookpath. Nothing is written to disk.
+// The page is kept in timelineCache, and made again only when
+// the books change.
+func serveTimeline(w http.ResponseWriter, bookpath string) {
+	paths := siblingBooks(bookpath)
+	if len(paths) == 0 {
+		http.Error(w, "no books found", http.StatusNotFound)
+		return
+	}
+	key, err := timelineKey(paths)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	timelineCache.mut.Lock()
+	defer timelineCache.mut.Unlock()
+	if key != timelineCache.key {
+		page, err := timelinePage(paths)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		timelineCache.key, timelineCache.page = key, page
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(timelineCache.page)
+}
+
+// timelinePage merges the books at paths into one html page.
+func timelinePage(paths []string) (page []byte, err error) {
+	out := &HashRBook{
+		CreateTm:   time.Now(),
+		BookID:     "timeline",
+		User:       username,
+		Host:       hostname,
+		Path:       strings.Join(paths, " + "),
+		path2image: make(map[string]*HashRElem),
+	}
+	_, err = mergeBooks(paths, out, false)
+	if err != nil {
+		return nil, err
+	}
+	ov := newExportOverlays()
+	for _, e := range out.elems {
+		err = ov.add(e)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	writeHTMLHead(bw, "timeline", out)
+	for _, e := range out.elems {
+		err = writeHTMLElem(bw, out, e, ov)
+		if err != nil {
+			vv("serveTimeline: %v", err)
+		}
+	}
+	writeHTMLTail(bw)
+	bw.Flush()
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+// writeHostBook makes the book at path, as written on host, with
+// elems at their Tm. Seqnos and the hash chain are filled in.
+func writeHostBook(path, host string, elems []*HashRElem) *HashRBook {
+	h, appendFD, err := ReadBook("tester", host, path)
+	panicOn(err)
+	for _, e := range elems {
+		e.Seqno = len(h.elems)
+		h.mut.Lock()
+		h.appendElem(e)
+		h.mut.Unlock()
+		by, err := e.SaveToSlice()
+		panicOn(err)
+		_, err = appendFD.Write(by)
+		panicOn(err)
+	}
+	panicOn(appendFD.Close())
+	return h
+}
+
+func TestMerge(t *testing.T) {
+
+	cv.Convey("rbook -merge should interleave the books from several hosts by time, renumber seqnos and command lines, point overlays at the new seqnos, and note each element's host and BookID; /timeline should show the same merge", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-merge")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		t0 := time.Date(2023, 9, 12, 5, 0, 0, 0, Chicago)
+		at := func(min int) time.Time { return t0.Add(time.Duration(min) * time.Minute) }
+		cmd := func(min, seqno, line int, code string) *HashRElem {
+			msg, n := prepCommandMessage(code, seqno)
+			return &HashRElem{Typ: Command, Tm: at(min), CmdJSON: msg, BeginCommandLineNum: line, NumCommandLines: n}
+		}
+
+		rogPath := filepath.Join(dir, "my.rbook.rog")
+		rog := writeHostBook(rogPath, "rog", []*HashRElem{
+			cmd(0, 0, 1, "a1 <- 1"), // 0
+			{Typ: Console, Tm: at(1), ConsoleJSON: prepConsoleMessage(`["## [1] 1"]`, 1)}, // 1
+			cmd(4, 2, 2, "a2 <- 2"), // 2
+			{Typ: OverlayHideOutput, Tm: at(5), OverlayHideSeqno: 1, OverlayHideSeqnoJSON: prepOverlayHideOutput(3, 1)}, // 3
+		})
+		bashPath := filepath.Join(dir, "my.rbook.bash")
+		bash := writeHostBook(bashPath, "bash", []*HashRElem{
+			cmd(2, 0, 1, "b1 <- function() {\n  1\n}"),                                                         // 0
+			{Typ: OverlayLaterNote, Tm: at(3), OverlayNoteJSON: prepOverlayLaterNoteMessage("about b1", 1, 0)}, // 1
+			cmd(6, 2, 4, "b2 <- 2"), // 2
+		})
+
+		// as the flags would come: books, then -o.
+		outPath := filepath.Join(dir, "merged.rbook")
+		cfg := &RbookConfig{}
+		fs := flag.NewFlagSet("rbook", flag.ContinueOnError)
+		cfg.DefineFlags(fs)
+		panicOn(fs.Parse([]string{"-merge", rogPath, bashPath, "-o", outPath}))
+		panicOn(cfg.FinishConfig(fs))
+		cv.So(cfg.MergeFrom, cv.ShouldResemble, []string{rogPath, bashPath})
+		cv.So(cfg.Out, cv.ShouldEqual, outPath)
+		cv.So(cfg.runOfflineTool(cfg.RbookFilePath), cv.ShouldBeTrue)
+
+		m, err := loadBookReadOnly(outPath)
+		panicOn(err)
+		cv.So(m.BookID == rog.BookID || m.BookID == bash.BookID, cv.ShouldBeFalse)
+		cv.So(m.CreateTm.Equal(rog.CreateTm) || m.CreateTm.Equal(bash.CreateTm), cv.ShouldBeTrue)
+		cv.So(len(m.elems), cv.ShouldEqual, 7)
+
+		wantHost := []string{"rog", "rog", "bash", "bash", "rog", "rog", "bash"}
+		for i, e := range m.elems {
+			cv.So(e.Seqno, cv.ShouldEqual, i)
+			cv.So(e.SourceHost, cv.ShouldEqual, wantHost[i])
+			if i > 0 {
+				cv.So(e.Tm.Before(m.elems[i-1].Tm), cv.ShouldBeFalse)
+			}
+		}
+		cv.So(m.elems[2].SourceBookID, cv.ShouldEqual, bash.BookID)
+		cv.So(m.elems[0].SourceBookID, cv.ShouldEqual, rog.BookID)
+
+		// command lines run on across the books.
+		cv.So(m.elems[0].BeginCommandLineNum, cv.ShouldEqual, 1)
+		cv.So(m.elems[2].BeginCommandLineNum, cv.ShouldEqual, 2)
+		cv.So(m.elems[4].BeginCommandLineNum, cv.ShouldEqual, 5)
+		cv.So(m.elems[6].BeginCommandLineNum, cv.ShouldEqual, 6)
+		d, err := decodeElem(m.elems[6])
+		panicOn(err)
+		cv.So(d.Seqno, cv.ShouldEqual, 6)
+		cv.So(d.Command, cv.ShouldResemble, []string{"b2 <- 2"})
+
+		// the overlays follow what they were on.
+		cv.So(m.elems[5].Typ, cv.ShouldEqual, OverlayHideOutput)
+		cv.So(m.elems[5].OverlayHideSeqno, cv.ShouldEqual, 1)
+		note := &overlayNoteJSON{}
+		panicOn(decodeLenPrefixed(m.elems[3].OverlayNoteJSON, note))
+		cv.So(note.OverlayOnSeqno, cv.ShouldEqual, 2)
+		cv.So(note.Seqno, cv.ShouldEqual, 3)
+
+		res, ok := verifyFrames(outPath)
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(res.checked, cv.ShouldEqual, 7)
+
+		// the merge is a new book; so is not a sibling of its sources,
+		// and their companion files are not books.
+		cv.So(FileExists(indexPathFor(rogPath)), cv.ShouldBeTrue)
+		cv.So(siblingBooks(rogPath), cv.ShouldResemble, []string{bashPath, rogPath})
+
+		rec := httptest.NewRecorder()
+		serveTimeline(rec, rogPath)
+		page := rec.Body.String()
+		cv.So(rec.Code, cv.ShouldEqual, 200)
+		a2 := strings.Index(page, "a2 &lt;- 2")
+		b1 := strings.Index(page, "b1 &lt;- function")
+		cv.So(b1, cv.ShouldBeGreaterThan, 0)
+		cv.So(a2, cv.ShouldBeGreaterThan, b1)
+		cv.So(page, cv.ShouldContainSubstring, `<span class="RsourceHost">bash</span>`)
+		cv.So(page, cv.ShouldContainSubstring, "about b1")
+
+		// the live book finds its siblings from its absolute path,
+		// even after a setwd() in R moves us elsewhere.
+		live, liveFD, err := ReadBook("tester", "rog", "my.rbook.rog")
+		panicOn(err)
+		panicOn(liveFD.Close())
+		cv.So(live.diskPath, cv.ShouldEqual, rogPath)
+		panicOn(os.Chdir(cwd))
+		rec = httptest.NewRecorder()
+		serveTimeline(rec, live.diskPath)
+		cv.So(rec.Body.String(), cv.ShouldEqual, page)
+
+		// the page is made again only when a book changes.
+		cv.So(timelineCache.key, cv.ShouldContainSubstring, bashPath)
+		writeHostBook(bashPath, "bash", []*HashRElem{cmd(7, 3, 5, "b3 <- 3")})
+		rec = httptest.NewRecorder()
+		serveTimeline(rec, live.diskPath)
+		cv.So(rec.Body.String(), cv.ShouldContainSubstring, "b3 &lt;- 3")
+		panicOn(os.Chdir(dir))
+
+		// and we never overwrite a book.
+		cv.So(cfg.mergeCmd(), cv.ShouldBeFalse)
+	})
+
+	cv.Convey("a note on a duplicate command that the merge drops should follow the command it keeps", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-merge")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		cmd := func(seqno, line int, code string) *HashRElem {
+			msg, n := prepCommandMessage(code, seqno)
+			return &HashRElem{Typ: Command, Tm: time.Now(), CmdJSON: msg, BeginCommandLineNum: line, NumCommandLines: n}
+		}
+		path := filepath.Join(dir, "my.rbook.rog")
+		writeHostBook(path, "rog", []*HashRElem{
+			cmd(0, 1, "x <- 1"),
+			cmd(1, 1, "x <- 1"),
+			{Typ: OverlayLaterNote, Tm: time.Now(), OverlayNoteJSON: prepOverlayLaterNoteMessage("about x", 2, 1)},
+		})
+		out := NewHashRBook("tester", "testhost", filepath.Join(dir, "merged.rbook"))
+		st, err := mergeBooks([]string{path}, out, false)
+		panicOn(err)
+		cv.So(st.ndup, cv.ShouldEqual, 1)
+		cv.So(len(out.elems), cv.ShouldEqual, 2)
+		note := &overlayNoteJSON{}
+		panicOn(decodeLenPrefixed(out.elems[1].OverlayNoteJSON, note))
+		cv.So(note.OverlayOnSeqno, cv.ShouldEqual, 0)
+	})
+}
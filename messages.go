@@ -0,0 +1,149 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// msgCapture keeps a copy of R's message stream: what message(),
+// warning(), and anything else that writes to stderr() from R
+// print, including the "Warning message:" R gives after a top
+// level command. Our console sink sees only stdout, and R cannot
+// split a message sink as it does that one; so instead R sinks
+// its messages into a pipe to us, and we pass them on to our
+// stderr as they come, so they still show in the terminal as
+// before, while keeping them for the book.
+type msgCapture struct {
+	r *os.File // we read R's messages here;
+	w *os.File // R writes them here, by /dev/fd; as does take().
+
+	mut sync.Mutex
+	got []byte
+
+	// read signals that the reader has passed a msgMark.
+	read chan bool
+}
+
+// msgMark is written after R's messages, so take() knows when the
+// reader has all of them.
+var msgMark = []byte("\x00rbook-msg-mark\x00")
+
+// newMsgCapture starts reading R's messages, passing them on to
+// out; our stderr.
+func newMsgCapture(out *os.File) (m *msgCapture, err error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	m = &msgCapture{r: r, w: w, read: make(chan bool)}
+	go m.reader(out)
+	return m, nil
+}
+
+// sinkR is the R code that sinks the message stream to us. We run
+// it before each command, in case the user reset the sink.
+func (m *msgCapture) sinkR() string {
+	return fmt.Sprintf(`if (sink.number(type="message") == 2L) { .rbook.msgcon <<- file("/dev/fd/%v", open="w"); sink(.rbook.msgcon, type="message") }`, m.w.Fd())
+}
+
+// reader copies what comes down the pipe to out, and into m.got.
+func (m *msgCapture) reader(out *os.File) {
+	var pending []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := m.r.Read(buf)
+		if err != nil {
+			return
+		}
+		pending = append(pending, buf[:n]...)
+		for {
+			i := bytes.Index(pending, msgMark)
+			if i < 0 {
+				break
+			}
+			m.keep(out, pending[:i])
+			pending = pending[i+len(msgMark):]
+			m.read <- true
+		}
+		// hold back only what might be the start of a mark.
+		hold := 0
+		for k := len(msgMark) - 1; k > 0; k-- {
+			if bytes.HasSuffix(pending, msgMark[:k]) {
+				hold = k
+				break
+			}
+		}
+		m.keep(out, pending[:len(pending)-hold])
+		pending = append([]byte{}, pending[len(pending)-hold:]...)
+	}
+}
+
+func (m *msgCapture) keep(out *os.File, by []byte) {
+	if len(by) == 0 {
+		return
+	}
+	out.Write(by)
+	m.mut.Lock()
+	m.got = append(m.got, by...)
+	m.mut.Unlock()
+}
+
+// take returns what R has written to its message stream since the
+// last take. R flushes its message connection after each write,
+// so once R returns to us, all of it is in the pipe.
+func (m *msgCapture) take() string {
+	_, err := m.w.Write(msgMark)
+	if err != nil {
+		return ""
+	}
+	<-m.read
+	m.mut.Lock()
+	s := string(m.got)
+	m.got = m.got[:0]
+	m.mut.Unlock()
+	return s
+}
+
+// messageLines splits what take() returned into the lines for
+// the book. A progress bar that redraws itself with \r keeps only
+// what it last showed.
+func messageLines(s string) (lines []string) {
+	s = strings.TrimRight(s, "\r\n")
+	if s == "" {
+		return nil
+	}
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if i := strings.LastIndex(line, "\r"); i >= 0 {
+			line = line[i+1:]
+		}
+		lines = append(lines, line)
+	}
+	return
+}
+
+// prepStderrMessage gives the message for a Console element that
+// holds R's message stream rather than its stdout. Lines get the
+// ## prefix, as console lines do; the "stream" tag lets the
+// browser show them apart, and older rbooks show them as output.
+func prepStderrMessage(lines []string, seqno int) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	prefixed := make([]string, len(lines))
+	for i, line := range lines {
+		prefixed[i] = "## " + line
+	}
+	by, err := json.Marshal(prefixed)
+	panicOn(err)
+
+	json := fmt.Sprintf(`{"seqno": %v, "console":%v, "stream":"stderr"}`, seqno, string(by))
+	lenPrefixedJson := fmt.Sprintf("%v:%v", len(json), json)
+	return lenPrefixedJson
+}
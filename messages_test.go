@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestMessageCapture(t *testing.T) {
+
+	cv.Convey("R's message stream should pass through to our stderr as it comes, and be kept for the book as console lines tagged stderr", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-messages")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		tee, err := os.Create(filepath.Join(dir, "stderr"))
+		panicOn(err)
+		defer tee.Close()
+		m, err := newMsgCapture(tee)
+		panicOn(err)
+		cv.So(m.sinkR(), cv.ShouldContainSubstring, fmt.Sprintf(`file("/dev/fd/%v", open="w")`, m.w.Fd()))
+
+		// as R would open it.
+		r, err := os.OpenFile(fmt.Sprintf("/dev/fd/%v", m.w.Fd()), os.O_WRONLY, 0)
+		panicOn(err)
+		defer r.Close()
+		fmt.Fprintf(r, "starting\n")
+		fmt.Fprintf(r, "|==   |  40%%\r|=====| 100%%\n")
+		fmt.Fprintf(r, "Warning message:\nIn log(-1) : NaNs produced\n")
+
+		got := m.take()
+		cv.So(got, cv.ShouldEqual, "starting\n|==   |  40%\r|=====| 100%\nWarning message:\nIn log(-1) : NaNs produced\n")
+		cv.So(string(mustReadFile(tee.Name())), cv.ShouldEqual, got)
+		cv.So(m.take(), cv.ShouldEqual, "")
+
+		lines := messageLines(got)
+		cv.So(lines, cv.ShouldResemble, []string{"starting", "|=====| 100%", "Warning message:", "In log(-1) : NaNs produced"})
+		cv.So(len(messageLines("\n")), cv.ShouldEqual, 0)
+
+		path := filepath.Join(dir, "my.rbook")
+		msg, n := prepCommandMessage("x <- log(-1)", 0)
+		writeHostBook(path, "rog", []*HashRElem{
+			{Typ: Command, Tm: time.Now(), CmdJSON: msg, BeginCommandLineNum: 1, NumCommandLines: n},
+			{Typ: Console, Tm: time.Now(), ConsoleJSON: prepStderrMessage(lines, 1)},
+		})
+		h, err := loadBookReadOnly(path)
+		panicOn(err)
+		d, err := decodeElem(h.elems[1])
+		panicOn(err)
+		cv.So(d.Stream, cv.ShouldEqual, "stderr")
+		cv.So(d.Console[2], cv.ShouldEqual, "## Warning message:")
+
+		// in the script as console output is.
+		fd, err := ioutil.TempFile(dir, "dump")
+		panicOn(err)
+		cv.So((&RbookConfig{}).dumpBook(fd, path), cv.ShouldBeTrue)
+		panicOn(fd.Close())
+		cv.So(string(mustReadFile(fd.Name())), cv.ShouldContainSubstring, "\n    ## Warning message:\n    ## In log(-1) : NaNs produced\n")
+
+		cfg := &RbookConfig{Out: filepath.Join(dir, "out.html")}
+		cv.So(cfg.exportHTML(path), cv.ShouldBeTrue)
+		page := string(mustReadFile(cfg.Out))
+		cv.So(page, cv.ShouldContainSubstring, `<div class="RconsoleOutput RmessageOutput">`)
+		cv.So(strings.Count(page, "RmessageOutput\">"), cv.ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"fmt"
+)
+
+// Automatic plot capture. We used to save a plot only after a
+// command that began with plot( or hist(, or on sv() and svv().
+// Now R tells us when a graphics page is finished, whatever drew
+// it: base graphics calls the before.plot.new hook, and grid
+// (so ggplot2 and lattice) the before.grid.newpage hook, just
+// before a new page wipes the old one. If the old page was drawn
+// on, we save it then. A loop that draws ten pages thus gives ten
+// Image elements. After each command, pageChanged() catches the
+// last page; and one only added to, by lines() or abline() say,
+// as its display list will have changed.
+//
+// The hooks and svv() call back into Go the same way; while in a
+// hook, .rbook.inhook is TRUE. A page from a hook is queued, to be
+// shown after the command that drew it. svv() shows its page at
+// once, in order with any dvv() output around it.
+//
+// Only our own device is watched: the png() we keep open under
+// -display=png, else the X11 or quartz window. A pdf() or png()
+// the user opened for themselves is theirs alone. Nor do we
+// close or reopen ours to save a page, as that would reset its
+// par(), and so a par(mfrow=...) layout, midway through a loop;
+// we save a copy, by copyPNGR() or savePlot().
+
+// autoPlotR sets up the page tracking in R. It is evaluated once,
+// after svv() is defined.
+//
+// .rbook.dirty is TRUE once a page has been started on our device
+// since we last saved one; .rbook.lastdl is the display list of
+// the page we last saved, to tell if it was drawn on since. A
+// par(mfrow=...) layout only starts a new page when its last
+// figure is done, so we check par("mfg") before saving on
+// plot.new; else each panel would be saved as it was drawn. An
+// empty display list counts as none; and until a page is saved,
+// only one started by plot.new counts, so a fresh device that
+// has only seen par() is not taken for a drawn page.
+const autoPlotR = `local({
+  if (!exists(".rbook.dev")) .rbook.dev <<- 0L
+  .rbook.dirty <<- FALSE
+  .rbook.lastdl <<- NULL
+  .rbook.inhook <<- FALSE
+  .rbook.ours <<- function() {
+    d <- dev.cur()
+    if (d == 1L) return(FALSE)
+    if (.rbook.dev > 0L) return(d == .rbook.dev)
+    names(d) %in% c("X11", "X11cairo", "quartz")
+  }
+  .rbook.dl <<- function() {
+    dl <- tryCatch(suppressWarnings(recordPlot()[[1]]), error=function(e) NULL)
+    if (length(dl) == 0L) NULL else dl
+  }
+  .rbook.changed <<- function() {
+    .rbook.ours() && (.rbook.dirty || (!is.null(.rbook.lastdl) && !identical(.rbook.dl(), .rbook.lastdl)))
+  }
+  .rbook.saved <<- function() {
+    .rbook.dirty <<- FALSE
+    .rbook.lastdl <<- .rbook.dl()
+    invisible()
+  }
+  .rbook.newpage <<- function() {
+    if (.rbook.ours()) {
+      if (.rbook.changed()) {
+        .rbook.inhook <<- TRUE
+        on.exit(.rbook.inhook <<- FALSE)
+        svv()
+      }
+      .rbook.dirty <<- TRUE
+    }
+    invisible()
+  }
+  setHook("before.plot.new", function() {
+    mfg <- par("mfg")
+    if (all(mfg[1:2] == mfg[3:4])) .rbook.newpage()
+  })
+  setHook("before.grid.newpage", function() .rbook.newpage())
+  invisible()
+})`
+
+// pngDeviceR follows the png() that -display=png opens for us,
+// making it our device; and keeping its display list, which a
+// file device does not by default, so we can tell when it changes,
+// and copy it.
+const pngDeviceR = `; dev.control(displaylist="enable"); .rbook.dev <<- dev.cur()`
+
+// pngArgs are those of our png() device, and of the copies of
+// it we save: 7 inches square at 100 dpi, rather than png()'s
+// default of 72, the same as the svg and pdf beside them (see
+// vectorR), and as a re-render with the defaults of /rbook/render.
+const pngArgs = `height=700, width=700, res=100, bg="white", type="cairo-png"`
+
+// pngOpenR opens a png() device, as ours, drawing to path.
+func pngOpenR(path string) string {
+	return fmt.Sprintf(`png(filename='%v', %v)`, path, pngArgs) + pngDeviceR
+}
+
+// copyPNGR saves a copy of the page on the current device to a
+// png at path, and goes back to the current device, left as it
+// was. dev.copy() replays the display list, so the copy's hooks
+// do not see our device, and do not fire again.
+func copyPNGR(path string) string {
+	return fmt.Sprintf(`local({ d <- dev.cur(); dev.copy(png, filename='%v', %v); dev.off(); dev.set(d); invisible() })`, path, pngArgs)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestPageHooksKeepLayout(t *testing.T) {
+
+	rscript, err := exec.LookPath("Rscript")
+	if err != nil {
+		t.Skip("needs R's Rscript on the PATH")
+	}
+
+	cv.Convey("under -display=png, saving each finished page of a par(mfrow=c(2,2)) loop should leave our device, and its layout, as they were", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-plots")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+
+		// svv() saves copies of the pages, as capturePlot() does.
+		var saves []string
+		for i := 1; i <= 4; i++ {
+			saves = append(saves, copyPNGR(filepath.Join(dir, fmt.Sprintf("page%v.png", i))))
+		}
+		script := strings.Join([]string{
+			pngOpenR(filepath.Join(dir, "device.png")),
+			`n <- 0; svv <- function() { n <<- n + 1; switch(n, ` + strings.Join(saves, ", ") + `); .rbook.saved() }`,
+			autoPlotR,
+			`dev0 <- dev.cur()`,
+			`par(mfrow=c(2,2), mar=c(2,2,1,1))`,
+			`for (i in 1:9) { plot(i); stopifnot(identical(par("mfrow"), c(2L, 2L)), identical(par("mar"), c(2, 2, 1, 1))) }`,
+			`cat("pages", n, "same device", dev.cur() == dev0, "mfrow", par("mfrow"), "changed", .rbook.changed(), "\n")`,
+		}, "\n")
+		scriptPath := filepath.Join(dir, "pages.R")
+		panicOn(ioutil.WriteFile(scriptPath, []byte(script), 0600))
+
+		out, err := exec.Command(rscript, "--vanilla", scriptPath).CombinedOutput()
+		cv.So(err, cv.ShouldBeNil)
+
+		// 9 panels: pages of 4 and 4 saved as the next began; the
+		// last, of 1, left for pageChanged() after the command.
+		cv.So(string(out), cv.ShouldContainSubstring, "pages 2 same device TRUE mfrow 2 2 changed TRUE")
+		cv.So(FileExists(filepath.Join(dir, "page1.png")), cv.ShouldBeTrue)
+		cv.So(FileExists(filepath.Join(dir, "page2.png")), cv.ShouldBeTrue)
+		cv.So(FileExists(filepath.Join(dir, "page3.png")), cv.ShouldBeFalse)
+	})
+}
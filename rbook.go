@@ -9,6 +9,7 @@ import (
 	"flag"
 	"fmt"
 	"hash"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/signal"
@@ -212,6 +213,14 @@ func main() {
 		bookpath = fn
 	}
 
+	if cfg.offlineTool() != "" {
+		// -fsck, -verify, ... need no session; so no lock here, and no .rsh script either.
+		if !cfg.runOfflineTool(bookpath) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if false { // runtime.GOOS == "darwin" {
 		// unix domain sockets buggy on darwin/go1.21.0 ?
 		// https://github.com/golang/go/issues/62337
@@ -246,9 +255,24 @@ func main() {
 		script, err = os.OpenFile(scriptPath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0770)
 		panicOn(err)
 	}
+	if cfg.Dump || cfg.DumpTimestamps {
+		// stream the book, rather than loading all of it.
+		if !cfg.dumpBook(script, bookpath) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	openBook := func() (*HashRBook, *os.File, error) {
+		if cfg.Lazy {
+			return ReadBookLazy(username, hostname, bookpath)
+		}
+		return ReadBook(username, hostname, bookpath)
+	}
+
 	var history *HashRBook
 
-	history, appendFD, err := ReadBook(username, hostname, bookpath)
+	history, appendFD, err := openBook()
 	panicOn(err)
 	if false {
 		// don't need to hold mut b/c reload server not started yet
@@ -260,29 +284,11 @@ func main() {
 	_ = appendFD
 	_ = history
 
-	scriptID := fmt.Sprintf(`
-#%v@%v:%v
-#BookID:%v
-#R rbook created: %v
-`, username, hostname, bookpath, history.BookID, history.CreateTm.Format(RFC3339NanoNumericTZ0pad))
+	scriptID := scriptIDFor(bookpath, history)
 
 	// write header for the script
 	if freshScript {
-		fmt.Fprintf(script, `#!/bin/bash
-exec R --vanilla -q --slave -e "source(file=pipe(\"tail -n +3 $0\"))" --args $@
-
-# text version of:
-
-%v
-
-require(png)
-
-`, scriptID)
-	}
-
-	if cfg.Dump || cfg.DumpTimestamps {
-		cfg.dumpToScript(script, history)
-		os.Exit(0)
+		writeScriptHeader(script, bookpath, history)
 	}
 
 	// for each new websocket client, as they
@@ -385,16 +391,27 @@ require(png)
 	archiveElem := func(e *HashRElem) {
 		// CODEX: keep in sync with the svvPlot() and dvvFunc() CODEX above.
 		history.mut.Lock()
-		history.elems = append(history.elems, e)
-		if e.ImagePath != "" {
-			//vv("saving e.ImagePath '%v' to path2image", e.ImagePath)
-			history.path2image[e.ImagePath] = e
-		}
+		history.appendElem(e)
 		history.mut.Unlock()
 
 		by, err := e.SaveToSlice()
 		panicOn(err)
 
+		// settle notes the offset of e's frame in the index, and lets
+		// go of its image under -lazy, once e is on disk. After a full
+		// rewrite, DeletePathAndReSaveFullBook has rebuilt the whole
+		// index, e included; but e's image is still ours to drop.
+		settle := func(rewrote bool, offset int64) {
+			history.mut.Lock()
+			defer history.mut.Unlock()
+			if !rewrote {
+				history.noteFrame(e.Seqno, offset)
+			}
+			if history.lazy {
+				e.dropImage()
+			}
+		}
+
 		// try to detect if file needs to be re-opened to continue to append:
 		var preSize, postSize int64
 		preSize, err = FileSize(bookpath)
@@ -405,6 +422,7 @@ require(png)
 			appendFD.Close() // try not the leak the old fd.
 			appendFD = history.DeletePathAndReSaveFullBook(bookpath)
 			// the latest e is already written so we are done now.
+			settle(true, 0)
 			return
 		}
 
@@ -427,12 +445,18 @@ require(png)
 				// prompted this addition: git/rebase deleted and re-created our file.
 				var history2 *HashRBook
 				var appendFD2 *os.File
-				history2, appendFD2, err = ReadBook(username, hostname, bookpath)
+				history2, appendFD2, err = openBook()
 				panicOn(err)
 				appendFD = appendFD2
 
 				history.mut.Lock()
 				nelem := len(history.elems)
+				// offsets are those of the re-opened file now.
+				history.index = history2.index
+				if history.lazy {
+					history.readFD.Close()
+					history.readFD = history2.readFD
+				}
 				history.mut.Unlock()
 
 				if len(history2.elems) != nelem-1 || history2.BookID != history.BookID {
@@ -441,6 +465,7 @@ require(png)
 					appendFD.Close() // try not the leak the old fd.
 					appendFD = history.DeletePathAndReSaveFullBook(bookpath)
 					// the latest e is already written so we are done now.
+					settle(true, 0)
 					return
 				}
 
@@ -472,89 +497,98 @@ require(png)
 				panicOn(err)
 			}
 		}
+
+		// the frame just written ends the file.
+		settle(false, postSize-int64(len(by)))
 	}
 
 	// setup for svvPlot() to be able to use -display=png and not need X11/cairo stuff.
 	odirPlots := bookpath + ".plots"
 	panicOn(os.MkdirAll(odirPlots, 0777))
-	var nextPlotSavePath string
 	if cfg.Display == "png" {
-		// start a png() file, reading to capture any plot.
-		rnd20 := cryrand.RandomStringWithUp(20)
-		nextPlotSavePath = fmt.Sprintf("%v/plotmini_%03d_%v.png", odirPlots, nextSave, rnd20)
-		err = embedr.EvalR(fmt.Sprintf(`png(filename='%v', height=700, width=700, bg="white", type="cairo-png")`, nextPlotSavePath))
+		// start the png() device we draw on, for the whole session.
+		// We save copies of its pages, so the file it would write
+		// itself is of no interest; see plots.go.
+		//
+		// tried rebuilding R to not need x11 but to keep png, for headless vps:
+		// ./configure --with-x=no --with-cairo=yes --with-libpng=yes --enable-R-shlib
+		devicePath := filepath.Join(os.TempDir(), fmt.Sprintf("rbook-device-%v.png", cryrand.RandomStringWithUp(20)))
+		err = embedr.EvalR(pngOpenR(devicePath))
 		if err != nil {
-			vv("error during initial png(filename='%v'): '%v'", nextPlotSavePath, err)
+			vv("error during initial png(filename='%v'): '%v'", devicePath, err)
 			panic("could not start png file saving!")
 		}
 	}
 
-	svvPlot := func() {
-		//fmt.Printf("svvPlot() called!  seqno=%v, bookpath='%v'\n", seqno, bookpath)
+	// capturePlot saves the page on our graphics device to a png,
+	// returning the Image element for it, not yet numbered or
+	// archived; nil if there was nothing to save.
+	capturePlot := func() *HashRElem {
+		//fmt.Printf("capturePlot() called!  seqno=%v, bookpath='%v'\n", seqno, bookpath)
 
 		e := &HashRElem{
-			Tm:    time.Now(),
-			Seqno: seqno,
+			Tm: time.Now(),
 		}
 
+		rnd20 := cryrand.RandomStringWithUp(20)
+		nextPlotSavePath := fmt.Sprintf("%v/plotmini_%03d_%v.png", odirPlots, nextSave, rnd20)
+		e.ImageSVG, e.ImagePDF = saveVectors(cfg.vectorKinds, nextPlotSavePath)
+		e.PlotRDS = savePlotRecord(nextPlotSavePath)
+
+		var err error
 		if cfg.Display == "png" {
-			// save the .png file
-			err = embedr.EvalR(fmt.Sprintf(`dev.off()`))
-			panicOn(err)
+			// a copy; our device, and its par(), stay as they are.
+			err = embedr.EvalR(copyPNGR(nextPlotSavePath))
+		} else if runtime.GOOS == "darwin" {
+			err = embedr.EvalR(fmt.Sprintf(`quartz.save(file='%v', type = "png", device = dev.cur(), dpi = 100, bg="white")`, nextPlotSavePath))
 		} else {
-			rnd20 := cryrand.RandomStringWithUp(20)
-			nextPlotSavePath = fmt.Sprintf("%v/plotmini_%03d_%v.png", odirPlots, nextSave, rnd20)
-			if runtime.GOOS == "darwin" {
-				err = embedr.EvalR(fmt.Sprintf(`quartz.save(file='%v', type = "png", device = dev.cur(), dpi = 100, bg="white")`, nextPlotSavePath))
-			} else {
-				err = embedr.EvalR(fmt.Sprintf(`savePlot(filename="%v")`, nextPlotSavePath))
-			}
+			err = embedr.EvalR(fmt.Sprintf(`savePlot(filename="%v")`, nextPlotSavePath))
 		}
 		if err != nil {
 			// possibly "no plot on device to save";
 			// don't bother to send to browser. And don't crash.
 			//continue
 			vv("error during savePlot(filename='%v'): '%v'", nextPlotSavePath, err)
-			return
+			return nil
 		}
 		panicOn(err)
 		pathhash, imageby := PathHash(nextPlotSavePath)
 		//vv("saved to path = '%v'; pathhash='%v'", nextPlotSavePath, pathhash)
 		nextSave++
 
-		//vv("Reloading browser with image path '%v'", nextPlotSavePath)
-		msg := prepImageMessage(nextPlotSavePath, pathhash, seqno)
-
 		e.Typ = Image
-		e.ImageJSON = msg
 		e.ImageHost = hostname
 		e.ImagePath = nextPlotSavePath
-		e.ImageBy = imageby
 		e.ImagePathHash = pathhash
+		e.ImageMIME = mimePNG
+		if cfg.Blobs {
+			e.ImageHash, err = history.blobs.put(imageby)
+			panicOn(err)
+		} else {
+			e.ImageBy = imageby
+		}
+
+		// this page is saved; see plots.go.
+		embedr.EvalR(`.rbook.saved()`)
+		return e
+	}
+
+	// emitPlot numbers the Image e, shows it, and archives it.
+	emitPlot := func(e *HashRElem) {
+		e.Seqno = seqno
+
+		//vv("Reloading browser with image path '%v'", e.ImagePath)
+		msg := withVectors(prepImageMessage(e.ImagePath, e.ImagePathHash, seqno), e)
+		e.ImageJSON = msg
 		e.msg = []byte(msg)
 
-		script = writeScriptImage(script, nextPlotSavePath)
+		script = writeScriptImage(script, e.ImagePath)
 
 		hub.broadcast <- e
 		seqno++
 
 		archiveElem(e)
 
-		if cfg.Display == "png" {
-			// After nextPlotSavePath is done being referenced; and after
-			// nextSave has been incremented:
-			// start the next png(), reading to capture any plot.
-			rnd20 := cryrand.RandomStringWithUp(20)
-			nextPlotSavePath = fmt.Sprintf("%v/plotmini_%03d_%v.png", odirPlots, nextSave, rnd20)
-			// tried rebuilding R to not need x11 but to keep png, for headless vps:
-			// ./configure --with-x=no --with-cairo=yes --with-libpng=yes --enable-R-shlib
-			//
-			err = embedr.EvalR(fmt.Sprintf(`png(filename='%v', height=700, width=700, bg="white", type="cairo-png")`, nextPlotSavePath))
-			if err != nil {
-				panic(fmt.Sprintf("error during subsequent png(filename='%v'): '%v'", nextPlotSavePath, err))
-			}
-		}
-
 		/*
 			// CODEX: keep in sync with code after the switch below!
 			history.mut.Lock()
@@ -572,6 +606,65 @@ require(png)
 		*/
 	}
 
+	// svvPlot saves the page on our graphics device, now.
+	svvPlot := func() {
+		if e := capturePlot(); e != nil {
+			emitPlot(e)
+		}
+	}
+
+	// pages that were finished during a command, by the
+	// graphics hooks in plots.go; they are shown after the
+	// command, by flushPlots().
+	var pendingPlots []*HashRElem
+	queuePlot := func() {
+		if e := capturePlot(); e != nil {
+			pendingPlots = append(pendingPlots, e)
+		}
+	}
+	flushPlots := func() {
+		for _, e := range pendingPlots {
+			emitPlot(e)
+		}
+		pendingPlots = nil
+	}
+
+	// htmlwidgets printed by the last command; see widgets.go.
+	flushWidgets := func(tm time.Time) {
+		for _, w := range takeWidgets() {
+			e, err := widgetElem(w, tm, seqno)
+			if err != nil {
+				vv("%v", err)
+				continue
+			}
+			hub.broadcast <- e
+			seqno++
+			archiveElem(e)
+		}
+	}
+
+	// plotCallback is what svv() calls: from a page hook, queue
+	// the page; from the user, show it now. See plots.go.
+	plotCallback := func() {
+		got, err := embedr.EvalR_fullback(`.rbook.inhook`)
+		if inhook, ok := got.(bool); err == nil && ok && inhook {
+			queuePlot()
+			return
+		}
+		svvPlot()
+	}
+
+	// pageChanged is true if the page on our device was
+	// started or drawn on since we last saved it.
+	pageChanged := func() bool {
+		got, err := embedr.EvalR_fullback(`.rbook.changed()`)
+		if err != nil {
+			return false
+		}
+		changed, ok := got.(bool)
+		return ok && changed
+	}
+
 	setWebDataFunc := func() {
 		dat, err := embedr.EvalR_fullback(`.my.webData`)
 		panicOn(err)
@@ -718,10 +811,110 @@ require(png)
 		*/
 	}
 
+	// stderrFunc records what R wrote to its message stream
+	// during a command; see messages.go.
+	stderrFunc := func(lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		e := &HashRElem{
+			Tm:    time.Now(),
+			Seqno: seqno,
+		}
+		msg := prepStderrMessage(lines, seqno)
+		e.Typ = Console
+		e.ConsoleJSON = msg
+		e.msg = []byte(msg)
+
+		script = writeScriptConsole(script, lines)
+
+		hub.broadcast <- e
+		seqno++
+		archiveElem(e)
+	}
+
+	// errorFunc records an R error, as an Error element, after
+	// input (the lines typed since the last complete command)
+	// failed to parse or evaluate. Before it go the output the
+	// command printed, outputJSON and its lines output, as a
+	// Console element; and messages, what R wrote to its message
+	// stream meanwhile, less the error itself.
+	errorFunc := func(input []string, outputJSON string, output []string, messages string) {
+		cmd := strings.TrimSpace(strings.Join(input, "\n"))
+		if isESSInjected(cmd) {
+			return
+		}
+		if cmd == "" {
+			stderrFunc(messageLines(messages))
+			return
+		}
+		if outputJSON != "" && outputJSON != "[]" {
+			e := &HashRElem{
+				Tm:    time.Now(),
+				Seqno: seqno,
+			}
+			msg := prepConsoleMessage(outputJSON, seqno)
+			e.Typ = Console
+			e.ConsoleJSON = msg
+			e.msg = []byte(msg)
+
+			script = writeScriptConsole(script, output)
+
+			hub.broadcast <- e
+			seqno++
+			archiveElem(e)
+		}
+		got, err := embedr.EvalR_fullback(`geterrmessage()`)
+		if err != nil {
+			stderrFunc(messageLines(messages))
+			return
+		}
+		errText := strings.Join(rStrings(got), "\n")
+		if i := strings.LastIndex(messages, errText); errText != "" && i >= 0 {
+			// it goes in the Error element instead.
+			messages = messages[:i] + messages[i+len(errText):]
+		}
+		stderrFunc(messageLines(messages))
+
+		message := strings.TrimRight(errText, "\n")
+		if message == "" {
+			// no condition: a ctrl-c interrupt, say.
+			return
+		}
+		got, err = embedr.EvalR_fullback(`vapply(.traceback(), function(call) paste(call, collapse="\n"), "")`)
+		var traceback []string
+		if err == nil {
+			traceback = rStrings(got)
+		}
+		cmdLines := strings.Split(cmd, "\n")
+		msgLines := strings.Split(message, "\n")
+
+		e := &HashRElem{
+			Tm:    time.Now(),
+			Seqno: seqno,
+		}
+		msg := prepErrorMessage(cmdLines, msgLines, traceback, seqno)
+		e.Typ = Error
+		e.ErrorJSON = msg
+		e.msg = []byte(msg)
+
+		script = writeScriptError(script, cmdLines, msgLines, traceback, e.Tm)
+
+		hub.broadcast <- e
+		seqno++
+		archiveElem(e)
+	}
+
+	// R's message stream: message(), warning(), and the like.
+	msgs, err := newMsgCapture(os.Stderr)
+	if err != nil {
+		vv("could not capture R's messages: '%v'", err)
+	}
+
 	// our repl
 	embedr.ReplDLLinit()
 	embedr.SetGoCallbackForCleanup(func() { cfg.StopXvfb() })
-	embedr.SetRCallbackToGoFunc(svvPlot)
+	embedr.SetRCallbackToGoFunc(plotCallback)
 	embedr.SetRCallbackToGoFuncDvv(dvvFunc)
 	embedr.SetRCallbackToGoFuncSetWebData(setWebDataFunc)
 
@@ -744,6 +937,15 @@ require(png)
 	embedr.EvalR(`.my.webData <<- c();`)
 	embedr.EvalR(`setweb=function(webData){ .my.webData <<- webData; .C("CallRCallbackToGoFuncSetWebData"); c()}`)
 
+	// save each graphics page as it is finished; see plots.go.
+	embedr.EvalR(autoPlotR)
+
+	// for printed data.frames; see tables.go.
+	embedr.EvalR(tableR(maxTableRows, maxTableCols))
+
+	// for printed htmlwidgets; see widgets.go.
+	embedr.EvalR(widgetR)
+
 	// on darwin, we need to start a quartz window with
 	// the bg="white", or else the browser will get an opaque
 	// background which can look invisible (dark gray on black).
@@ -779,15 +981,44 @@ require(png)
 	var capturedOutputOK bool
 	var lastHistory string
 
+	// the lines of a command still being typed; for errorFunc.
+	var pendingInput []string
+
+	// what R wrote to its message stream during the last command,
+	// until recorded.
+	var pendingMessages string
+
 	for {
+		// any pages, widgets, or messages left from a command we
+		// did not record; or from dv(), sv(), or a comment.
+		flushWidgets(time.Now())
+		flushPlots()
+		stderrFunc(messageLines(pendingMessages))
+		pendingMessages = ""
 
 		//updatePromptCwd("")
 		embedr.EvalR(`if(exists("zrecord_mini_console")) { rm("zrecord_mini_console") }`)
 		embedr.EvalR(`sink(textConnection("zrecord_mini_console", open="w"), split=T);`)
 
+		if msgs != nil {
+			embedr.EvalR(msgs.sinkR())
+		}
+
+		// so that after an error, geterrmessage() and .traceback()
+		// are about this command, not some earlier one.
+		embedr.EvalR(`assign(".Traceback", NULL, envir=baseenv()); invisible(.Internal(seterrmessage("")))`)
+
 		//path := ""
+		// times the command from when its input arrives; see stats.go.
+		meter := startCmdMeter()
 		did := embedr.ReplDLLdo1()
+		meter.stop()
 		_ = did
+		messages := ""
+		if msgs != nil {
+			messages = msgs.take()
+		}
+		pendingMessages = messages
 		//vv("did = %v", did)
 		if did > 1 {
 			// did == 2: this seems to mean that the parse is incomplete; need more input.
@@ -797,6 +1028,11 @@ require(png)
 		// did == -1 => ctrl-d (end of file).
 
 		lastHistory = embedr.LastHistoryLine() // to check for trailing semicolon
+		input := append(pendingInput, lastHistory)
+		pendingInput = nil
+		if did == 2 {
+			pendingInput = input
+		}
 		trailingSemicolon := strings.HasSuffix(lastHistory, ";")
 		autoDV := !trailingSemicolon
 		_ = autoDV
@@ -856,7 +1092,19 @@ require(png)
 		embedr.EvalR(`sink(file=NULL)`)
 
 		if did == 0 {
-			// simple error
+			// a parse or evaluation error.
+			if capturedOutputOK {
+				errorFunc(input, captureJSON, captureOK, messages)
+			} else {
+				errorFunc(input, "", nil, messages)
+			}
+			pendingMessages = ""
+			// widgets printed, and pages drawn, before the error.
+			flushWidgets(time.Now())
+			if pageChanged() {
+				queuePlot()
+			}
+			flushPlots()
 			continue
 		}
 		if did < 0 {
@@ -873,11 +1121,9 @@ require(png)
 		}
 
 		// weed out the ess crap
-		if strings.HasPrefix(cmd, ".ess") ||
-			strings.Contains(cmd, "options(STERM") ||
-			strings.Contains(cmd, ".emacs.d/ESS/etc/ESSR") {
-
+		if isESSInjected(cmd) {
 			// ignore the garbage .ess_funargs stuff
+			pendingMessages = ""
 			continue
 		}
 
@@ -959,6 +1205,8 @@ require(png)
 			} else { // cmd
 
 				msg, numlines := prepCommandMessage(cmd, seqno)
+				meter.fill(e)
+				msg = withStats(msg, e)
 				e.Typ = Command
 				e.CmdJSON = msg
 				e.msg = []byte(msg)
@@ -982,17 +1230,10 @@ require(png)
 					// version of dv() that does not need to use prev and prevCaptureOK
 					if capturedOutputOK && captureJSON != "" && !isProgress {
 
-						// before we overwrite e from the cmd just above,
-						// save it as in the CODEX.
-
-						history.mut.Lock()
-						history.elems = append(history.elems, e)
-						history.mut.Unlock()
-
-						by, err := e.SaveToSlice()
-						panicOn(err)
-						_, err = appendFD.Write(by)
-						panicOn(err)
+						// e from the cmd just above was already saved
+						// by archiveElem(e). Writing it a second time
+						// here, as we used to, would duplicate it on
+						// disk and break the hash chain.
 
 						// ship capture
 						//vv("autoDV is on. shipping captureJSON = '%v'", captureJSON)
@@ -1017,19 +1258,61 @@ require(png)
 						hub.broadcast <- e2
 						seqno++
 						archiveElem(e2)
-					}
 
-					// auto sv() too
-					if strings.HasPrefix(cmd, "plot(") || strings.HasPrefix(cmd, "hist(") {
-						svvPlot()
+						// a printed data.frame or matrix, as a table too.
+						if t := shownTable(); t != nil {
+							msg := prepTableMessage(t, seqno)
+							e3 := &HashRElem{
+								Tm:        e.Tm,
+								Seqno:     seqno,
+								Typ:       Table,
+								TableJSON: msg,
+								msg:       []byte(msg),
+							}
+							hub.broadcast <- e3
+							seqno++
+							archiveElem(e3)
+						}
 					}
 				} // end if autoDV
+
+				// plotly, leaflet, and the like.
+				flushWidgets(e.Tm)
+
+				// message(), and any warnings R printed after the command.
+				stderrFunc(messageLines(messages))
+				pendingMessages = ""
+
+				// auto sv() too: the page still open at the end of
+				// the command, if new or drawn on; after any pages
+				// it finished along the way.
+				if autoDV {
+					if pageChanged() {
+						queuePlot()
+					}
+				} else {
+					// a trailing ; leaves the page unsaved.
+					embedr.EvalR(`.rbook.saved()`)
+				}
+				flushPlots()
 			} // end else cmd
 		} // end switch
 	}
 	select {}
 }
 
+// rStrings gives the character vector that EvalR_fullback returned
+// as got; which comes back as a string when of length one.
+func rStrings(got interface{}) []string {
+	switch x := got.(type) {
+	case []string:
+		return x
+	case string:
+		return []string{x}
+	}
+	return nil
+}
+
 func escape(s string) (res string, grew int) {
 	if len(s) == 0 {
 		return
@@ -1141,6 +1424,25 @@ func prepConsoleMessage(consoleOut string, seqno int) string {
 	return lenPrefixedJson
 }
 
+// prepErrorMessage gives the message for an Error element: the
+// lines of the command that failed, R's condition message, and
+// the traceback, innermost call first, as .traceback() has it.
+func prepErrorMessage(cmd, message, traceback []string, seqno int) string {
+	if traceback == nil {
+		traceback = []string{}
+	}
+	cmdBy, err := json.Marshal(cmd)
+	panicOn(err)
+	msgBy, err := json.Marshal(message)
+	panicOn(err)
+	tbBy, err := json.Marshal(traceback)
+	panicOn(err)
+
+	json := fmt.Sprintf(`{"seqno": %v, "errorCommand":%v, "error":%v, "traceback":%v}`, seqno, string(cmdBy), string(msgBy), string(tbBy))
+	lenPrefixedJson := fmt.Sprintf("%v:%v", len(json), json)
+	return lenPrefixedJson
+}
+
 func prepOverlayLaterNoteMessage(note string, seqno, overlayOnSeqno int) string {
 	if note == "" {
 		return ""
@@ -1168,14 +1470,15 @@ func prepImageMessage(path, pathhash string, seqno int) string {
 	return lenPrefixedJson
 }
 
-// book.mut must be held by caller
-func prepInitMessage(book *HashRBook) string {
+// book.mut must be held by caller. pg is the first page
+// of elements that will follow; see replay.go.
+func prepInitMessage(book *HashRBook, pg *replayPage) string {
 	// don't want to send the elements
 
 	by, err := json.Marshal(book)
 	panicOn(err)
 
-	json := fmt.Sprintf(`{"init":true, "book":%v}`, string(by))
+	json := fmt.Sprintf(`{"init":true, "book":%v, "oldest":%v, "more":%v, "firstLine":%v}`, string(by), pg.oldest, pg.more, pg.firstLine)
 	lenPrefixedJson := fmt.Sprintf("%v:%v", len(json), json)
 	return lenPrefixedJson
 }
@@ -1219,12 +1522,129 @@ func writeScriptConsole(script *os.File, prevCaptureOK []string) *os.File {
 	return script
 }
 
+// writeScriptError appends an Error element to the script. All of
+// it is commented out, so that the script still runs through.
+func writeScriptError(script *os.File, cmd, message, traceback []string, at time.Time) *os.File {
+	fmt.Fprintf(script, spacer+" ## error: %v\n", at.In(Chicago).Format(RFC3339MicroNumericTZ))
+	writeScriptErrorBody(script, cmd, message, traceback)
+	return script
+}
+
+// writeScriptErrorBody writes the failed command, message, and
+// traceback, numbered as traceback() prints it.
+func writeScriptErrorBody(w io.Writer, cmd, message, traceback []string) {
+	for _, line := range cmd {
+		fmt.Fprintf(w, "#! %v\n", line)
+	}
+	for _, line := range message {
+		fmt.Fprintf(w, "    #! %v\n", line)
+	}
+	if len(traceback) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "    #! traceback:\n")
+	for _, line := range tracebackLines(traceback) {
+		fmt.Fprintf(w, "    #! %v\n", line)
+	}
+}
+
+// tracebackLines numbers the calls of traceback, innermost
+// first, as traceback() prints them.
+func tracebackLines(traceback []string) (lines []string) {
+	for i, call := range traceback {
+		for j, line := range strings.Split(call, "\n") {
+			if j == 0 {
+				lines = append(lines, fmt.Sprintf("%3d: %v", len(traceback)-i, line))
+			} else {
+				lines = append(lines, "     "+line)
+			}
+		}
+	}
+	return
+}
+
 type DecodeJSON struct {
 	Seqno   int      `json:"seqno"`
 	Command []string `json:"command"`
 	Console []string `json:"console"`
 	Comment []string `json:"comment"`
 	Image   string   `json:"image"`
+
+	// "stderr" for console lines from R's message stream; see
+	// prepStderrMessage(). Empty for stdout.
+	Stream string `json:"stream"`
+
+	ErrorCommand []string `json:"errorCommand"`
+	Error        []string `json:"error"`
+	Traceback    []string `json:"traceback"`
+
+	Table *rTable `json:"table"`
+
+	// a Widget's page is at /widget/<Widget>.
+	Widget      string `json:"widget"`
+	WidgetClass string `json:"widgetClass"`
+}
+
+func scriptIDFor(bookpath string, book *HashRBook) string {
+	return fmt.Sprintf(`
+#%v@%v:%v
+#BookID:%v
+#R rbook created: %v
+`, username, hostname, bookpath, book.BookID, book.CreateTm.Format(RFC3339NanoNumericTZ0pad))
+}
+
+// writeScriptHeader starts the .rsh script version of the book.
+func writeScriptHeader(script *os.File, bookpath string, book *HashRBook) {
+
+	scriptID := scriptIDFor(bookpath, book)
+
+	fmt.Fprintf(script, `#!/bin/bash
+exec R --vanilla -q --slave -e "source(file=pipe(\"tail -n +3 $0\"))" --args $@
+
+# text version of:
+
+%v
+
+require(png)
+
+`, scriptID)
+}
+
+// dumpBook implements -dump and -dumpts: write the script version of
+// the book at bookpath to fd, reading one element at a time. Only
+// the elements that -since, -until, -seqno, and -types pick out
+// are written.
+func (c *RbookConfig) dumpBook(fd *os.File, bookpath string) (ok bool) {
+	r, err := OpenBookReader(bookpath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -dump: %v\n", err)
+		return false
+	}
+	defer r.Close()
+
+	writeScriptHeader(fd, bookpath, r.Book)
+
+	lastCommandLineNum := 0
+	for i := 0; ; i++ {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == TruncatedFrame {
+			// ReadBook would repair it; a dump just stops.
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rbook -dump: bad frame at offset %v: '%v'\n", r.Offset(), err)
+			return false
+		}
+		if !c.filter.match(e) {
+			continue
+		}
+		c.dumpElemToScript(fd, i, e, &lastCommandLineNum)
+	}
+	fd.Sync()
+	return true
 }
 
 func (c *RbookConfig) dumpToScript(fd *os.File, book *HashRBook) {
@@ -1233,54 +1653,75 @@ func (c *RbookConfig) dumpToScript(fd *os.File, book *HashRBook) {
 	lastCommandLineNum := 0
 
 	for i, e := range book.elems {
-		colon := bytes.Index(e.msg, []byte{':'})
-		msg := e.msg[colon+1:]
-		d := &DecodeJSON{}
-		err := json.Unmarshal(msg, d)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "problem at i = %v, colon = %v, e = '%#v': msg='%v', err = '%v'", i, colon, e, string(msg), err)
-			panicOn(err)
-		}
+		c.dumpElemToScript(fd, i, e, &lastCommandLineNum)
+	}
 
-		if c.DumpTimestamps {
-			extra := ""
-			if e.BeginCommandLineNum > 0 {
-				extra = fmt.Sprintf("command line [%03d] ", e.BeginCommandLineNum)
-			}
-			fmt.Printf("          ##  ===== %v %v =====:\n", e.Tm.In(Chicago).Format(RFC3339MicroNumericTZ), extra)
-		} else {
-			// match what incrementally appended .rsh looks like, so we can
-			// re-create on git rebase deletion.
-			if e.BeginCommandLineNum > 0 {
-				if e.BeginCommandLineNum == lastCommandLineNum {
-					// eliminate duplicates
-					continue
-				}
-				lastCommandLineNum = e.BeginCommandLineNum
-				// keep this matching the writeScriptCommand() output at rbook.go:1203
-				fmt.Fprintf(fd, spacer+" ## command line [%03d]: %v\n", e.BeginCommandLineNum, e.Tm.In(Chicago).Format(RFC3339MicroNumericTZ))
-			}
+	fd.Sync()
+}
+
+// dumpElemToScript writes the i-th element e in script form.
+// *lastCommandLineNum lets us skip duplicate commands.
+func (c *RbookConfig) dumpElemToScript(fd *os.File, i int, e *HashRElem, lastCommandLineNum *int) {
+	if len(e.msg) == 0 || e.Typ == Table || e.Typ == Widget {
+		// the overlays have no script form; nor does a
+		// table, beyond the printed text before it; nor a widget.
+		return
+	}
+	colon := bytes.Index(e.msg, []byte{':'})
+	msg := e.msg[colon+1:]
+	d := &DecodeJSON{}
+	err := json.Unmarshal(msg, d)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "problem at i = %v, colon = %v, e = '%#v': msg='%v', err = '%v'", i, colon, e, string(msg), err)
+		panicOn(err)
+	}
+
+	if c.DumpTimestamps {
+		extra := ""
+		if e.BeginCommandLineNum > 0 {
+			extra = fmt.Sprintf("command line [%03d] ", e.BeginCommandLineNum)
 		}
-		switch e.Typ {
-		case Command:
-			for _, line := range d.Command {
-				fmt.Fprintf(fd, "%v\n", line)
-			}
-		case Comment:
-			for _, line := range d.Comment {
-				fmt.Fprintf(fd, "%v\n", line)
-			}
-		case Console:
-			for _, line := range d.Console {
-				fmt.Fprintf(fd, "    %v\n", line)
+		stats := ""
+		if s := e.StatsString(); s != "" {
+			// how long it ran, and the memory it used.
+			stats = "  " + s
+		}
+		fmt.Fprintf(fd, "          ##  ===== %v %v =====:%v\n", e.Tm.In(Chicago).Format(RFC3339MicroNumericTZ), extra, stats)
+	} else {
+		// match what incrementally appended .rsh looks like, so we can
+		// re-create on git rebase deletion.
+		if e.BeginCommandLineNum > 0 {
+			if e.BeginCommandLineNum == *lastCommandLineNum {
+				// eliminate duplicates
+				return
 			}
-		case Image:
-			fmt.Fprintf(fd, "    ##img=readPNG('%v');x11();grid::grid.raster(img); #saved\n", d.Image)
+			*lastCommandLineNum = e.BeginCommandLineNum
+			// keep this matching the writeScriptCommand() output at rbook.go:1203
+			fmt.Fprintf(fd, spacer+" ## command line [%03d]: %v\n", e.BeginCommandLineNum, e.Tm.In(Chicago).Format(RFC3339MicroNumericTZ))
 		}
-
 	}
-
-	fd.Sync()
+	switch e.Typ {
+	case Command:
+		for _, line := range d.Command {
+			fmt.Fprintf(fd, "%v\n", line)
+		}
+	case Comment:
+		for _, line := range d.Comment {
+			fmt.Fprintf(fd, "%v\n", line)
+		}
+	case Console:
+		for _, line := range d.Console {
+			fmt.Fprintf(fd, "    %v\n", line)
+		}
+	case Image:
+		fmt.Fprintf(fd, "    ##img=readPNG('%v');x11();grid::grid.raster(img); #saved\n", d.Image)
+	case Error:
+		if !c.DumpTimestamps {
+			// keep this matching writeScriptError().
+			fmt.Fprintf(fd, spacer+" ## error: %v\n", e.Tm.In(Chicago).Format(RFC3339MicroNumericTZ))
+		}
+		writeScriptErrorBody(fd, d.ErrorCommand, d.Error, d.Traceback)
+	}
 }
 
 // where we continue our command line numbering from
@@ -1317,6 +1758,14 @@ var annoyances = []string{`if (identical(getOption('pager'),`, // ... file.path(
 
 var essGarbage string = `(list \"\" '((\"` // randomly injected by ESS, ignored by rbook.
 
+// isESSInjected is true for the commands ESS sends to R
+// behind the user's back.
+func isESSInjected(cmd string) bool {
+	return strings.HasPrefix(cmd, ".ess") ||
+		strings.Contains(cmd, "options(STERM") ||
+		strings.Contains(cmd, ".emacs.d/ESS/etc/ESSR")
+}
+
 func isGarbage(s string) bool {
 	if strings.Contains(s, essGarbage) {
 		return true
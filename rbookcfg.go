@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"strings"
 	"time"
 
 	"4d63.com/tz"
@@ -86,6 +87,74 @@ type RbookConfig struct {
 	Dump           bool
 	DumpTimestamps bool
 
+	Fsck    bool
+	Verify  bool
+	Attest  bool
+	Upgrade bool
+
+	Blobs bool
+
+	// rbook -vector svg,pdf; see vector.go.
+	Vector      string
+	vectorKinds []string
+
+	// Out is the -o path, where -compact and the other
+	// offline tools write.
+	Out string
+
+	Compact      bool
+	CompactHide  bool
+	CompactPng   bool
+	CompactWidth int
+
+	// with -compact, drop each plot's recordPlot(); see render.go.
+	CompactNoRecord bool
+
+	ExportHTML     bool
+	ExportRmd      bool
+	ExportQmd      bool
+	ExportNoOutput bool
+	ExportIpynb    bool
+
+	Import bool
+
+	Merge bool
+
+	// rbook -grep pattern [books or directories...]; see grep.go.
+	Grep   string
+	GrepIn []string
+
+	// for git; see git.go.
+	Textconv    bool
+	MergeDriver bool
+
+	Extract bool
+
+	// rbook -rerender; see render.go.
+	Rerender   bool
+	RenderSize string
+	RenderDPI  int
+	RenderFmt  string
+	renderOpts renderOpts
+
+	// filters for -extract, -dump, and -dumpts; see extract.go.
+	FilterSince string
+	FilterUntil string
+	FilterSeqno string
+	FilterTypes string
+	filter      *elemFilter
+
+	// the books to -merge, or the %O %A %B books
+	// of -merge-driver, from the command line.
+	MergeFrom []string
+
+	DumpJSONL   bool
+	LoadJSONL   bool
+	JSONLImages string
+
+	Replay int
+	Lazy   bool
+
 	Wallpaper string
 
 	ShowVersion  bool
@@ -104,7 +173,7 @@ type RbookConfig struct {
 // call DefineFlags before myflags.Parse()
 func (c *RbookConfig) DefineFlags(fs *flag.FlagSet) {
 
-	fs.BoolVar(&c.DumpTimestamps, "dumpts", false, "like -dump but print the timestamp beside each line, showing when it was entered.")
+	fs.BoolVar(&c.DumpTimestamps, "dumpts", false, "like -dump but print the timestamp beside each line, showing when it was entered; and beside each command, how long it ran and the memory it used.")
 	fs.StringVar(&c.Host, "host", "", "host/ip to server on (optional)")
 	fs.IntVar(&c.Port, "port", 0, "port to serve index.html for images/R updates on (optional; if -port is taken or 0, defaults to the first free port at or above 8888)")
 	fs.StringVar(&c.RbookFilePath, "path", "", "path to the .rbook file to read and append to. this is also the default command line argument, so -path can be omitted in front of the path (default is my.rbook in the current dir)")
@@ -115,8 +184,44 @@ func (c *RbookConfig) DefineFlags(fs *flag.FlagSet) {
 	}
 	fs.StringVar(&c.Rhome, "rhome", defaultR_HOME, "value of R_HOME to start R with. This directory should have contents: bin  COPYING  etc  lib  library  modules  site-library  SVN-REVISION")
 
+	fs.IntVar(&c.Replay, "replay", 500, "send a browser only this many of the most recent cells when it connects; it fetches older ones as you scroll up. 0 means send the whole book at once.")
+	fs.BoolVar(&c.Lazy, "lazy", false, "keep plot images out of memory, reading each back from the book file when a browser asks for it. For long-lived books with many plots.")
 	fs.BoolVar(&c.Help, "help", false, "show this help given rbook -h")
 	fs.BoolVar(&c.Dump, "dump", false, "write script version of the -path binary book to standard out, then exit.")
+	fs.BoolVar(&c.Fsck, "fsck", false, "check every frame of the -path binary book and report its offset, then exit. Changes nothing; a torn last record is only repaired when the book is next opened for appending.")
+	fs.BoolVar(&c.Blobs, "blobs", false, "store new plot images once each, named by checksum, in the <book>.blobs/ directory beside the book, instead of inline in the book. Keeps books small; but the .blobs/ directory must then travel with the book.")
+	fs.StringVar(&c.Vector, "vector", "", "also keep each new plot as svg, pdf, or both (-vector svg,pdf), made by dev.copy() when the plot is captured. The browser shows the svg when there is one. For taking figures into a paper.")
+	fs.BoolVar(&c.Compact, "compact", false, "write a compacted copy of the -path binary book to the -o path, then exit. Drops duplicate commands and renumbers seqnos, keeping the BookID. The -path book is not changed. Example: rbook -compact in.rbook -o out.rbook")
+	fs.StringVar(&c.Out, "o", "", "output path for -compact, -extract, -rerender, -merge, -import, -load-jsonl, -dump-jsonl, and the -export tools.")
+	fs.BoolVar(&c.CompactHide, "compact-hide", false, "with -compact, permanently remove the outputs hidden by OverlayHideOutput records.")
+	fs.BoolVar(&c.CompactPng, "compact-png", false, "with -compact, re-encode plot images at best png compression.")
+	fs.IntVar(&c.CompactWidth, "compact-width", 0, "with -compact, downscale plot images wider than this many pixels (0 means never downscale).")
+	fs.BoolVar(&c.CompactNoRecord, "compact-norecord", false, "with -compact, drop the recordPlot() kept with each plot. Saves space, but -rerender and the browser can then no longer draw those plots again.")
+	fs.BoolVar(&c.ExportHTML, "export-html", false, "write the -path binary book as one self-contained html file (to -o, default <book>.html) that any browser can show offline, then exit. Example: rbook -export-html my.rbook -o my.html")
+	fs.BoolVar(&c.ExportRmd, "export-rmd", false, "write the -path binary book as an R Markdown document (to -o, default <book>.Rmd), with plots in figures/ beside it, then exit. Each command becomes a chunk, and comments become prose.")
+	fs.BoolVar(&c.ExportQmd, "export-qmd", false, "like -export-rmd, but write a Quarto .qmd document.")
+	fs.BoolVar(&c.ExportIpynb, "export-ipynb", false, "write the -path binary book as a Jupyter notebook for the IR kernel (to -o, default <book>.ipynb), then exit. Commands become code cells, with their output and plots; comments become markdown cells.")
+	fs.BoolVar(&c.Import, "import", false, "make a new book at the -o path from an R script, .Rhistory, rbook .rsh script, or ESS *R* transcript, then exit. Example: rbook -import analysis.R -o analysis.rbook")
+	fs.BoolVar(&c.Rerender, "rerender", false, "draw the plots of the -path book again, from the recordPlot() kept with each, at -render-size, -render-dpi, and -render-fmt, into the -o directory as seqno-NNNN.png (or .svg, .pdf); then exit. -since, -until, and -seqno pick which plots. Needs R at -rhome. Example: rbook -rerender my.rbook -seqno 12 -render-size 1400x900 -render-fmt pdf -o figs")
+	fs.StringVar(&c.RenderSize, "render-size", "700x700", "with -rerender, the width x height in pixels.")
+	fs.IntVar(&c.RenderDPI, "render-dpi", 100, "with -rerender, the dots per inch; the size in inches of an svg or pdf is -render-size over this.")
+	fs.StringVar(&c.RenderFmt, "render-fmt", "png", "with -rerender, the format: png, svg, or pdf.")
+	fs.BoolVar(&c.Extract, "extract", false, "write the elements that -since, -until, -seqno, and -types pick out to a new book at the -o path, then exit. The new book names its parent's BookID. Example: rbook -extract my.rbook -since '2023-09-12 13:00' -types command,image -o slice.rbook")
+	fs.StringVar(&c.FilterSince, "since", "", "with -extract, -rerender, -dump, or -dumpts: only elements at or after this time. Times without a zone are Chicago time, as -dumpts shows. Example: -since '2023-09-12 13:00'")
+	fs.StringVar(&c.FilterUntil, "until", "", "with -extract, -rerender, -dump, or -dumpts: only elements before this time.")
+	fs.StringVar(&c.FilterSeqno, "seqno", "", "with -extract, -rerender, -dump, or -dumpts: only elements with seqnos in this range; a:b includes both ends, as in R. Example: -seqno 10:20")
+	fs.StringVar(&c.FilterTypes, "types", "", "with -extract, -dump, or -dumpts: only elements of these types, from command,console,comment,image,note,hide,error,table,widget. Example: -types command,image")
+	fs.BoolVar(&c.Merge, "merge", false, "merge the books named on the command line (say, one per host) into a new book at the -o path, interleaved by time, then exit. Each element keeps the host and BookID it came from. The live page shows the same merge of every my.rbook.* book, read-only, at /timeline. Example: rbook -merge my.rbook.rog my.rbook.bash -o merged.rbook")
+	fs.StringVar(&c.Grep, "grep", "", "search the commands, console output, comments, and errors of the books named after the pattern (or of all the books under any directories named; by default, under .) for this regular expression, and their tables by size and column names, and widgets by class; print each hit with its book, seqno, command line number, and time; then exit. The live page searches its book at /search?q=pattern, or press '/' there. Example: rbook -grep glmnet ~/projects")
+	fs.BoolVar(&c.Textconv, "textconv", false, "write the book as plain text for git diff to stdout, then exit. In .gitattributes: *.rbook* diff=rbook; then: git config diff.rbook.textconv 'rbook -textconv'")
+	fs.BoolVar(&c.MergeDriver, "merge-driver", false, "as a git merge driver, merge the append-only books %O %A %B: keep ours (%A) as it is, and add to it what theirs (%B) appended since their common base (%O). Exits non-zero, leaving ours alone, if history was rewritten. In .gitattributes: *.rbook* merge=rbook; then: git config merge.rbook.driver 'rbook -merge-driver %O %A %B'")
+	fs.BoolVar(&c.DumpJSONL, "dump-jsonl", false, "write the book as JSON Lines, one object per element, to the -o path or stdout, then exit. For jq, Python, or R. Example: rbook -dump-jsonl my.rbook | jq .command")
+	fs.BoolVar(&c.LoadJSONL, "load-jsonl", false, "make a new book at the -o path from the JSON Lines that -dump-jsonl wrote, then exit. An unedited dump gives back the same book, byte for byte. Example: rbook -load-jsonl my.jsonl -o copy.rbook")
+	fs.StringVar(&c.JSONLImages, "jsonl-images", "", "under -dump-jsonl, write plot images into this directory, instead of inline as base64.")
+	fs.BoolVar(&c.ExportNoOutput, "export-no-output", false, "with -export-rmd or -export-qmd, leave out the console output.")
+	fs.BoolVar(&c.Attest, "attest", false, "verify the -path binary book, then print the head of its hash chain with the head's timestamp, for publishing or notarizing; then exit.")
+	fs.BoolVar(&c.Upgrade, "upgrade", false, fmt.Sprintf("rewrite the -path binary book in the current format (version %v), keeping its BookID and seqnos, then exit. The original is kept as <book>.pre-upgrade.", BookFormatVersion))
+	fs.BoolVar(&c.Verify, "verify", false, "check the checksum and hash chain link of every frame in the -path binary book, report the first bad seqno and its byte offset, then exit.")
 
 	home := os.Getenv("HOME")
 	fs.StringVar(&c.Wallpaper, "wall", fmt.Sprintf("%v/.wallpaper", home), "path or symlink to wallpaper to set on the Xvfb/x11vnc")
@@ -159,6 +264,91 @@ func (c *RbookConfig) FinishConfig(fs *flag.FlagSet) error {
 		}
 	}
 
+	if c.Merge && c.RbookFilePath == "" {
+		// the books to merge, with flags among them:
+		// rbook -merge a.rbook b.rbook -o merged.rbook
+		args := fs.Args()
+		for len(args) > 0 {
+			if strings.HasPrefix(args[0], "-") {
+				err := fs.Parse(args)
+				if err != nil {
+					return err
+				}
+				args = fs.Args()
+				continue
+			}
+			c.MergeFrom = append(c.MergeFrom, args[0])
+			args = args[1:]
+		}
+		if len(c.MergeFrom) < 2 {
+			return fmt.Errorf("rbook -merge needs two or more books: rbook -merge a.rbook b.rbook -o merged.rbook")
+		}
+		for _, path := range c.MergeFrom {
+			if !FileExists(path) {
+				return fmt.Errorf("rbook -merge could not find book at path '%v'", path)
+			}
+		}
+		c.RbookFilePath = c.MergeFrom[0]
+	}
+
+	if c.Grep != "" && c.RbookFilePath == "" {
+		// rbook -grep pattern [books or directories...], with flags among them.
+		args := fs.Args()
+		for len(args) > 0 {
+			if strings.HasPrefix(args[0], "-") {
+				err := fs.Parse(args)
+				if err != nil {
+					return err
+				}
+				args = fs.Args()
+				continue
+			}
+			c.GrepIn = append(c.GrepIn, args[0])
+			args = args[1:]
+		}
+		if len(c.GrepIn) == 0 {
+			c.GrepIn = []string{"."}
+		}
+		for _, path := range c.GrepIn {
+			if !FileExists(path) && !DirExists(path) {
+				return fmt.Errorf("rbook -grep could not find book or directory at path '%v'", path)
+			}
+		}
+		c.RbookFilePath = c.GrepIn[0]
+	}
+
+	if c.MergeDriver && c.RbookFilePath == "" {
+		// from git: rbook -merge-driver %O %A %B
+		c.MergeFrom = fs.Args()
+		if len(c.MergeFrom) != 3 {
+			return fmt.Errorf("rbook -merge-driver needs three books, from git: rbook -merge-driver %%O %%A %%B")
+		}
+		for _, path := range c.MergeFrom {
+			if !FileExists(path) {
+				return fmt.Errorf("rbook -merge-driver could not find book at path '%v'", path)
+			}
+		}
+		c.RbookFilePath = c.MergeFrom[1]
+	}
+
+	tool := c.offlineTool()
+	if c.Dump || c.DumpTimestamps {
+		tool = "dump"
+	}
+	if tool != "" && c.RbookFilePath == "" {
+		// allow flags after the book, as in: rbook -compact in.rbook -o out.rbook
+		if args := fs.Args(); len(args) > 1 {
+			c.RbookFilePath = args[0]
+			err := fs.Parse(args[1:])
+			if err != nil {
+				return err
+			}
+			if len(fs.Args()) > 0 {
+				return fmt.Errorf("rbook -%v: unexpected arguments '%v'", tool, fs.Args())
+			}
+		}
+	}
+
 	if c.RbookFilePath == "" {
 		args := fs.Args()
 		if len(args) == 1 {
@@ -172,6 +362,29 @@ func (c *RbookConfig) FinishConfig(fs *flag.FlagSet) error {
 		}
 	}
 
+	var err error
+	c.vectorKinds, err = parseVector(c.Vector)
+	if err != nil {
+		return fmt.Errorf("rbook %v", err)
+	}
+
+	c.filter, err = newElemFilter(c.FilterSince, c.FilterUntil, c.FilterSeqno, c.FilterTypes)
+	if err != nil {
+		return fmt.Errorf("rbook %v", err)
+	}
+	if c.filter != nil && !(c.Extract || c.Rerender || c.Dump || c.DumpTimestamps) {
+		return fmt.Errorf("rbook: -since, -until, -seqno, and -types go with -extract, -rerender, -dump, or -dumpts")
+	}
+
+	c.renderOpts = renderOpts{Fmt: strings.ToLower(c.RenderFmt), DPI: c.RenderDPI}
+	c.renderOpts.W, c.renderOpts.H, err = parseRenderSize(c.RenderSize)
+	if err == nil {
+		err = c.renderOpts.check()
+	}
+	if err != nil {
+		return fmt.Errorf("rbook -rerender: %v", err)
+	}
+
 	if c.Dump || c.DumpTimestamps {
 		if !FileExists(c.RbookFilePath) {
 			return fmt.Errorf("rbook -dump could not find book to dump at path '%v'", c.RbookFilePath)
@@ -179,6 +392,37 @@ func (c *RbookConfig) FinishConfig(fs *flag.FlagSet) error {
 		return nil // no web server stuff needed
 	}
 
+	if tool := c.offlineTool(); tool != "" {
+		if c.Grep != "" {
+			return nil // checked above.
+		}
+		if !FileExists(c.RbookFilePath) {
+			if c.Import || c.LoadJSONL {
+				return fmt.Errorf("rbook -%v could not find file to read at path '%v'", tool, c.RbookFilePath)
+			}
+			return fmt.Errorf("rbook -%v could not find book to check at path '%v'", tool, c.RbookFilePath)
+		}
+		if c.Compact && c.Out == "" {
+			return fmt.Errorf("rbook -compact needs an output path: rbook -compact in.rbook -o out.rbook")
+		}
+		if c.Import && c.Out == "" {
+			return fmt.Errorf("rbook -import needs an output path: rbook -import analysis.R -o analysis.rbook")
+		}
+		if c.Rerender && c.Out == "" {
+			return fmt.Errorf("rbook -rerender needs an output directory: rbook -rerender my.rbook -render-fmt pdf -o figs")
+		}
+		if c.Extract && c.Out == "" {
+			return fmt.Errorf("rbook -extract needs an output path: rbook -extract my.rbook -since 2023-09-12 -o slice.rbook")
+		}
+		if c.Merge && c.Out == "" {
+			return fmt.Errorf("rbook -merge needs an output path: rbook -merge a.rbook b.rbook -o merged.rbook")
+		}
+		if c.LoadJSONL && c.Out == "" {
+			return fmt.Errorf("rbook -load-jsonl needs an output path: rbook -load-jsonl my.jsonl -o copy.rbook")
+		}
+		return nil
+	}
+
 	// set the main web server port
 	const maxPort int = 65535
 	if c.Port == 0 || !IsAvailPort(c.Port) {
@@ -227,3 +471,95 @@ func (c *RbookConfig) FinishConfig(fs *flag.FlagSet) error {
 	//vv("end of FinishConfig, c = '%#v'", c)
 	return nil
 }
+
+// offlineTool returns the name of the flag asking for one of
+// our tools that work on the -path book without a session, and
+// then exit; or "" if none was requested. Most write a new file
+// at -o and leave -path alone; -upgrade and -merge-driver do
+// replace -path, but under its lock.
+func (c *RbookConfig) offlineTool() string {
+	switch {
+	case c.Fsck:
+		return "fsck"
+	case c.Verify:
+		return "verify"
+	case c.Attest:
+		return "attest"
+	case c.Compact:
+		return "compact"
+	case c.Upgrade:
+		return "upgrade"
+	case c.ExportHTML:
+		return "export-html"
+	case c.ExportRmd:
+		return "export-rmd"
+	case c.ExportQmd:
+		return "export-qmd"
+	case c.ExportIpynb:
+		return "export-ipynb"
+	case c.Import:
+		return "import"
+	case c.Extract:
+		return "extract"
+	case c.Rerender:
+		return "rerender"
+	case c.Merge:
+		return "merge"
+	case c.Grep != "":
+		return "grep"
+	case c.Textconv:
+		return "textconv"
+	case c.MergeDriver:
+		return "merge-driver"
+	case c.DumpJSONL:
+		return "dump-jsonl"
+	case c.LoadJSONL:
+		return "load-jsonl"
+	}
+	return ""
+}
+
+// runOfflineTool runs the tool named by c.offlineTool()
+// against the book at bookpath. Returns false if it found
+// a problem, so main can exit non-zero.
+func (c *RbookConfig) runOfflineTool(bookpath string) (ok bool) {
+	switch c.offlineTool() {
+	case "fsck":
+		return c.fsckBook(bookpath)
+	case "verify":
+		return c.verifyBook(bookpath)
+	case "attest":
+		return c.attestBook(bookpath)
+	case "compact":
+		return c.compactBook(bookpath)
+	case "upgrade":
+		return c.upgradeBook(bookpath)
+	case "export-html":
+		return c.exportHTML(bookpath)
+	case "export-rmd":
+		return c.exportMarkdown(bookpath, false)
+	case "export-qmd":
+		return c.exportMarkdown(bookpath, true)
+	case "export-ipynb":
+		return c.exportIpynb(bookpath)
+	case "import":
+		return c.importBook(bookpath)
+	case "extract":
+		return c.extractBook(bookpath)
+	case "rerender":
+		return c.rerenderBook(bookpath)
+	case "merge":
+		return c.mergeCmd()
+	case "grep":
+		return c.grepCmd(os.Stdout)
+	case "textconv":
+		return c.textconvBook(os.Stdout, bookpath)
+	case "merge-driver":
+		return c.mergeDriverCmd()
+	case "dump-jsonl":
+		return c.dumpJSONL(bookpath)
+	case "load-jsonl":
+		return c.loadJSONL(bookpath)
+	}
+	return true
+}
@@ -92,7 +92,7 @@ func createCertFiles() (cert string, key string) {
 }
 
 func (cfg *RbookConfig) startReloadServer(book *HashRBook) {
-	hub = newHub(book)
+	hub = newHub(book, cfg.Replay)
 	go hub.runRestarter() // never returns, recovers from all panics on its goroutine.
 	http.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
 		serveWs(hub, w, r)
@@ -0,0 +1,373 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glycerine/embedr"
+)
+
+// Re-rendering plots. A png is fixed at the size it was saved;
+// so when capturing a plot we also keep its recordPlot(), by
+// saveRDS(), in the Image element's PlotRDS. Later, replayPlot()
+// draws it again on a device of any size, resolution, and
+// format: rbook -rerender does so for a book on disk, and
+// /rbook/render?seqno=N&w=&h=&dpi=&fmt= for the live book.
+//
+// Both replay in an Rscript of their own, from the same R_HOME
+// as the session. The session's R cannot do it: its thread is
+// in ReplDLLdo1() waiting on the console, and R must only be
+// called from that thread. recordPlot() notes the namespaces
+// loaded, so grid based plots (ggplot2, lattice) replay too.
+//
+// An Rscript is costly, so /rbook/render draws one plot at a time,
+// no larger than maxServePixels a side, and keeps the last few it
+// drew in renderCache.
+
+// recordPlotR saves the page on the current device, as
+// recordPlot() sees it, to path.
+func recordPlotR(path string) string {
+	return fmt.Sprintf(`saveRDS(recordPlot(load=loadedNamespaces()), file='%v')`, path)
+}
+
+// savePlotRecord keeps the recordPlot() of the page on the
+// current device beside the png to be saved at pngPath,
+// returning its bytes; nil if R could not record it.
+func savePlotRecord(pngPath string) []byte {
+	path := besidePNG(pngPath, "rds")
+	err := embedr.EvalR(recordPlotR(path))
+	if err != nil {
+		vv("error during recordPlot() to '%v': '%v'", path, err)
+		return nil
+	}
+	by, err := ioutil.ReadFile(path)
+	if err != nil {
+		vv("could not read back recordPlot() '%v': '%v'", path, err)
+		return nil
+	}
+	// the bytes are in the book now; nothing serves the file.
+	os.Remove(path)
+	return by
+}
+
+// replayR is run by Rscript, with the arguments: the .rds of the
+// recorded plot, the output path, the format, the width and
+// height in pixels, and the dots per inch.
+const replayR = `a <- commandArgs(trailingOnly=TRUE); ` +
+	`p <- readRDS(a[1]); w <- as.numeric(a[4]); h <- as.numeric(a[5]); res <- as.numeric(a[6]); ` +
+	`switch(a[3], ` +
+	`png=png(filename=a[2], width=w, height=h, res=res, bg="white", type="cairo-png"), ` +
+	`svg=svg(filename=a[2], width=w/res, height=h/res), ` +
+	`pdf=cairo_pdf(filename=a[2], width=w/res, height=h/res)); ` +
+	`replayPlot(p, reloadPkgs=TRUE); invisible(dev.off())`
+
+// renderOpts says how to re-render a plot.
+type renderOpts struct {
+	Fmt  string // png, svg, or pdf.
+	W, H int    // in pixels.
+	DPI  int
+}
+
+// defaultRenderOpts are those of the png we capture.
+func defaultRenderOpts() renderOpts {
+	return renderOpts{Fmt: "png", W: 700, H: 700, DPI: 100}
+}
+
+// maxRenderPixels bounds each side of a re-rendered plot.
+const maxRenderPixels = 20000
+
+// maxServePixels bounds each side of a plot drawn for
+// /rbook/render; a larger request is scaled down to fit.
+const maxServePixels = 4000
+
+// clamp scales o down, keeping its aspect ratio, so that
+// neither side is more than max pixels.
+func (o renderOpts) clamp(max int) renderOpts {
+	big := o.W
+	if o.H > big {
+		big = o.H
+	}
+	if big > max {
+		o.W = o.W * max / big
+		o.H = o.H * max / big
+		if o.W < 1 {
+			o.W = 1
+		}
+		if o.H < 1 {
+			o.H = 1
+		}
+	}
+	return o
+}
+
+func (o renderOpts) check() error {
+	switch o.Fmt {
+	case "png", "svg", "pdf":
+	default:
+		return fmt.Errorf("unknown format '%v'; use png, svg, or pdf", o.Fmt)
+	}
+	if o.W <= 0 || o.H <= 0 || o.W > maxRenderPixels || o.H > maxRenderPixels {
+		return fmt.Errorf("size %vx%v out of range; each side must be 1 to %v pixels", o.W, o.H, maxRenderPixels)
+	}
+	if o.DPI <= 0 || o.DPI > 2400 {
+		return fmt.Errorf("dpi %v out of range; must be 1 to 2400", o.DPI)
+	}
+	return nil
+}
+
+// mime gives the content type of what o renders.
+func (o renderOpts) mime() string {
+	switch o.Fmt {
+	case "svg":
+		return mimeSVG
+	case "pdf":
+		return mimePDF
+	}
+	return mimePNG
+}
+
+// parseRenderSize reads a WxH size, as -render-size takes.
+func parseRenderSize(s string) (w, h int, err error) {
+	parts := strings.Split(strings.ToLower(s), "x")
+	if len(parts) == 2 {
+		w, err = strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err == nil {
+			h, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		}
+	}
+	if len(parts) != 2 || err != nil {
+		return 0, 0, fmt.Errorf("bad size '%v'; want width x height in pixels, as 1400x900", s)
+	}
+	return
+}
+
+// renderPlot replays the recorded plot rds under o, with the
+// Rscript of the R at rhome, and returns what it drew.
+func renderPlot(rhome string, rds []byte, o renderOpts) ([]byte, error) {
+	if err := o.check(); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir("", "rbook-render")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	rdsPath := filepath.Join(dir, "plot.rds")
+	if err := ioutil.WriteFile(rdsPath, rds, 0600); err != nil {
+		return nil, err
+	}
+	outPath := filepath.Join(dir, "plot."+o.Fmt)
+	cmd := exec.Command(filepath.Join(rhome, "bin", "Rscript"), "--vanilla", "-e", replayR,
+		rdsPath, outPath, o.Fmt, strconv.Itoa(o.W), strconv.Itoa(o.H), strconv.Itoa(o.DPI))
+	cmd.Env = append(os.Environ(), "R_HOME="+rhome)
+	got, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("replayPlot() failed: '%v': %v", err, strings.TrimSpace(string(got)))
+	}
+	by, err := ioutil.ReadFile(outPath)
+	if err != nil || len(by) == 0 {
+		return nil, fmt.Errorf("replayPlot() drew nothing: %v", strings.TrimSpace(string(got)))
+	}
+	return by, nil
+}
+
+// plotRecord returns the PlotRDS of the Image element e; empty if
+// it has none. Caller holds h.mut.
+func (h *HashRBook) plotRecord(e *HashRElem) ([]byte, error) {
+	if !e.lazyImage {
+		return e.PlotRDS, nil
+	}
+	got, err := h.loadLazyFrame(e)
+	if err != nil {
+		return nil, err
+	}
+	return got.PlotRDS, nil
+}
+
+// imageAtSeqno returns the Image element numbered seqno; nil if
+// there is none. Caller holds h.mut.
+func (h *HashRBook) imageAtSeqno(seqno int) *HashRElem {
+	i := sort.Search(len(h.elems), func(i int) bool { return h.elems[i].Seqno >= seqno })
+	for ; i < len(h.elems) && h.elems[i].Seqno == seqno; i++ {
+		if h.elems[i].Typ == Image {
+			return h.elems[i]
+		}
+	}
+	return nil
+}
+
+// renderKey names a render: the checksum of the recordPlot()
+// drawn, and how.
+type renderKey struct {
+	hash string
+	o    renderOpts
+}
+
+// maxRenderCache is how many renders renderCache keeps.
+const maxRenderCache = 32
+
+// renderCache holds the last renders /rbook/render drew, oldest
+// first in order.
+var renderCache = struct {
+	mut   sync.Mutex
+	m     map[renderKey][]byte
+	order []renderKey
+}{m: make(map[renderKey][]byte)}
+
+func cachedRender(k renderKey) []byte {
+	renderCache.mut.Lock()
+	defer renderCache.mut.Unlock()
+	return renderCache.m[k]
+}
+
+func cacheRender(k renderKey, by []byte) {
+	renderCache.mut.Lock()
+	defer renderCache.mut.Unlock()
+	if _, ok := renderCache.m[k]; ok {
+		return
+	}
+	if len(renderCache.order) == maxRenderCache {
+		delete(renderCache.m, renderCache.order[0])
+		renderCache.order = renderCache.order[1:]
+	}
+	renderCache.m[k] = by
+	renderCache.order = append(renderCache.order, k)
+}
+
+// renderSem lets one /rbook/render at a time run its Rscript.
+var renderSem = make(chan struct{}, 1)
+
+// serveRender answers /rbook/render?seqno=N&w=&h=&dpi=&fmt= with
+// the plot at seqno in the live book b, drawn again by the R at
+// rhome. Missing parameters are as the captured png.
+func serveRender(w http.ResponseWriter, r *http.Request, b *HashRBook, rhome string) {
+	q := r.URL.Query()
+	o := defaultRenderOpts()
+	seqno, err := strconv.Atoi(q.Get("seqno"))
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"w", &o.W}, {"h", &o.H}, {"dpi", &o.DPI}} {
+		if v := q.Get(p.name); v != "" && err == nil {
+			*p.dst, err = strconv.Atoi(v)
+		}
+	}
+	if f := q.Get("fmt"); f != "" {
+		o.Fmt = strings.ToLower(f)
+	}
+	if err == nil {
+		o = o.clamp(maxServePixels)
+		err = o.check()
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("bad render request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	b.mut.Lock()
+	e := b.imageAtSeqno(seqno)
+	var rds []byte
+	if e != nil {
+		rds, err = b.plotRecord(e)
+	}
+	b.mut.Unlock()
+	switch {
+	case e == nil:
+		http.Error(w, fmt.Sprintf("no plot at seqno %v", seqno), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("plot at seqno %v: %v", seqno, err), http.StatusInternalServerError)
+		return
+	case len(rds) == 0:
+		http.Error(w, fmt.Sprintf("the plot at seqno %v has no recordPlot() to replay", seqno), http.StatusNotFound)
+		return
+	}
+
+	k := renderKey{hash: checksumOf(rds), o: o}
+	by := cachedRender(k)
+	if by == nil {
+		select {
+		case renderSem <- struct{}{}:
+		case <-r.Context().Done():
+			return
+		}
+		// drawn while we waited?
+		by = cachedRender(k)
+		if by == nil {
+			by, err = renderPlot(rhome, rds, o)
+			if err == nil {
+				cacheRender(k, by)
+			}
+		}
+		<-renderSem
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("plot at seqno %v: %v", seqno, err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", o.mime())
+	w.Header().Set("Access-Control-Allow-Private-Network", "true")
+	http.ServeContent(w, r, "", e.Tm, bytes.NewReader(by))
+}
+
+// rerenderBook implements rbook -rerender: draw each plot of the
+// book at path that the filters pick out again, under -render-size,
+// -render-dpi, and -render-fmt, into the -o directory as
+// seqno-NNNN.fmt.
+func (c *RbookConfig) rerenderBook(path string) (ok bool) {
+	in, err := loadBookReadOnly(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -rerender: could not read book '%v': '%v'\n", path, err)
+		return false
+	}
+	err = os.MkdirAll(c.Out, 0777)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbook -rerender: %v\n", err)
+		return false
+	}
+
+	t0 := time.Now()
+	ok = true
+	nimg, ndone, nold := 0, 0, 0
+	for _, e := range in.elems {
+		if e.Typ != Image || !c.filter.match(e) {
+			continue
+		}
+		nimg++
+		if len(e.PlotRDS) == 0 {
+			nold++
+			continue
+		}
+		by, err := renderPlot(c.Rhome, e.PlotRDS, c.renderOpts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rbook -rerender: seqno %v: %v\n", e.Seqno, err)
+			ok = false
+			continue
+		}
+		out := filepath.Join(c.Out, fmt.Sprintf("seqno-%04d.%v", e.Seqno, c.renderOpts.Fmt))
+		err = ioutil.WriteFile(out, by, 0660)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rbook -rerender: %v\n", err)
+			return false
+		}
+		ndone++
+	}
+	fmt.Printf("rbook -rerender: wrote %v of %v plots to '%v' in %v.\n", ndone, nimg, c.Out, time.Since(t0).Round(time.Millisecond))
+	if nold > 0 {
+		fmt.Printf("rbook -rerender: %v plots were saved without a recordPlot(), and cannot be redrawn.\n", nold)
+	}
+	return ok && ndone > 0
+}
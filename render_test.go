@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+// fakeRscript stands in for R's Rscript: it writes what it was
+// asked to draw, followed by the recorded plot, to the output path.
+const fakeRscript = `#!/bin/sh
+# --vanilla -e expr rds out fmt w h dpi
+printf '%s %sx%s@%s ' "$6" "$7" "$8" "$9" > "$5"
+cat "$4" >> "$5"
+`
+
+func TestRerender(t *testing.T) {
+
+	cv.Convey("a plot's recordPlot() should be kept with it, so /rbook/render and rbook -rerender can have R draw it again at another size, resolution, and format", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-render")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "my.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		rhome := filepath.Join(dir, "R")
+		panicOn(os.MkdirAll(filepath.Join(rhome, "bin"), 0777))
+		panicOn(ioutil.WriteFile(filepath.Join(rhome, "bin", "Rscript"), []byte(fakeRscript), 0755))
+
+		t0 := time.Date(2023, 9, 12, 5, 0, 0, 0, Chicago)
+		msg, n := prepCommandMessage("plot(1:10)", 0)
+		h := writeHostBook(path, "rog", []*HashRElem{
+			{Typ: Command, Tm: t0, CmdJSON: msg, BeginCommandLineNum: 1, NumCommandLines: n},                   // 0
+			{Typ: Image, Tm: t0, ImagePath: "/p/a.png", ImageBy: []byte("png a"), PlotRDS: []byte("rds of a")}, // 1
+			{Typ: Image, Tm: t0, ImagePath: "/p/b.png", ImageBy: []byte("png b")},                              // 2, from before.
+		})
+
+		cv.So(recordPlotR("/p/a.rds"), cv.ShouldEqual, `saveRDS(recordPlot(load=loadedNamespaces()), file='/p/a.rds')`)
+
+		get := func(query string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			serveRender(rec, httptest.NewRequest("GET", "/rbook/render?"+query, nil), h, rhome)
+			return rec
+		}
+		rec := get("seqno=1&w=1400&h=900&fmt=svg")
+		cv.So(rec.Code, cv.ShouldEqual, 200)
+		cv.So(rec.Header().Get("Content-Type"), cv.ShouldEqual, "image/svg+xml")
+		cv.So(rec.Body.String(), cv.ShouldEqual, "svg 1400x900@100 rds of a")
+
+		rec = get("seqno=1")
+		cv.So(rec.Body.String(), cv.ShouldEqual, "png 700x700@100 rds of a")
+
+		cv.So(get("seqno=2").Code, cv.ShouldEqual, 404) // no recordPlot().
+		cv.So(get("seqno=0").Code, cv.ShouldEqual, 404) // not a plot.
+		cv.So(get("seqno=1&fmt=gif").Code, cv.ShouldEqual, 400)
+		cv.So(get("seqno=1&w=0").Code, cv.ShouldEqual, 400)
+		cv.So(get("seqno=x").Code, cv.ShouldEqual, 400)
+
+		// too big is drawn as big as we allow, the same shape.
+		rec = get("seqno=1&w=100000&h=50000")
+		cv.So(rec.Code, cv.ShouldEqual, 200)
+		cv.So(rec.Body.String(), cv.ShouldEqual, "png 4000x2000@100 rds of a")
+
+		// as the flags would come.
+		cfg := &RbookConfig{}
+		fs := flag.NewFlagSet("rbook", flag.ContinueOnError)
+		cfg.DefineFlags(fs)
+		figs := filepath.Join(dir, "figs")
+		panicOn(fs.Parse([]string{"-rerender", path, "-render-size", "1400x900", "-render-dpi", "300", "-render-fmt", "PDF", "-rhome", rhome, "-o", figs}))
+		panicOn(cfg.FinishConfig(fs))
+		cv.So(cfg.offlineTool(), cv.ShouldEqual, "rerender")
+		cv.So(cfg.renderOpts, cv.ShouldResemble, renderOpts{Fmt: "pdf", W: 1400, H: 900, DPI: 300})
+		cv.So(cfg.runOfflineTool(path), cv.ShouldBeTrue)
+		cv.So(string(mustReadFile(filepath.Join(figs, "seqno-0001.pdf"))), cv.ShouldEqual, "pdf 1400x900@300 rds of a")
+		cv.So(FileExists(filepath.Join(figs, "seqno-0002.pdf")), cv.ShouldBeFalse)
+
+		_, _, err = parseRenderSize("1400")
+		cv.So(err, cv.ShouldNotBeNil)
+
+		// R's complaint comes back.
+		panicOn(ioutil.WriteFile(filepath.Join(rhome, "bin", "Rscript"), []byte("#!/bin/sh\necho 'Error: no ggplot2' >&2\nexit 1\n"), 0755))
+		_, err = renderPlot(rhome, []byte("rds"), defaultRenderOpts())
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(strings.Contains(err.Error(), "Error: no ggplot2"), cv.ShouldBeTrue)
+
+		// what was drawn before comes from the cache, without R.
+		rec = get("seqno=1&w=1400&h=900&fmt=svg")
+		cv.So(rec.Code, cv.ShouldEqual, 200)
+		cv.So(rec.Body.String(), cv.ShouldEqual, "svg 1400x900@100 rds of a")
+		cv.So(get("seqno=1&w=1401&h=900&fmt=svg").Code, cv.ShouldEqual, 500)
+
+		// -compact-norecord drops the recordPlot()s.
+		small := filepath.Join(dir, "small.rbook")
+		cv.So((&RbookConfig{Out: small, CompactNoRecord: true}).compactBook(path), cv.ShouldBeTrue)
+		c, err := loadBookReadOnly(small)
+		panicOn(err)
+		cv.So(len(c.elems[1].PlotRDS), cv.ShouldEqual, 0)
+		cv.So(string(c.elems[1].ImageBy), cv.ShouldEqual, "png a")
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Paged replay: when a browser connects we send it only the
+// last cfg.Replay cells. As the user scrolls up, the browser
+// asks for older cells with {"older": <seqno>}, and we answer
+// with a page of up to cfg.Replay cells before that seqno,
+// bracketed by pageStart and pageEnd messages so the browser
+// knows to put them above what it already has.
+
+// pageRequest asks the hub to send client a page of
+// the elements before seqno before.
+type pageRequest struct {
+	client *Client
+	before int
+}
+
+// replayPage holds what we send for one page of elements.
+type replayPage struct {
+	msgs [][]byte
+
+	// seqno of the first element in the page; where the
+	// browser will ask for more from, if more.
+	oldest int
+
+	// more is true if there are elements before oldest.
+	more bool
+
+	// the command line number of the first command in the page,
+	// so the browser numbers the lines as the book does. 0 if none.
+	firstLine int
+}
+
+// pageBefore returns up to n element messages that come before seqno
+// before; or the last n if before < 0. n <= 0 means all of them.
+func (h *HashRBook) pageBefore(before, n int) (pg *replayPage) {
+	return h.readPage(h.pageEntries(before, n))
+}
+
+// pageSpan is where in the book file a page of elements is.
+type pageSpan struct {
+	ents []indexEntry
+	more bool // there are elements before ents.
+	path string
+}
+
+// newest is the seqno of the last element in the page, or -1.
+func (sp *pageSpan) newest() int {
+	if len(sp.ents) == 0 {
+		return -1
+	}
+	return sp.ents[len(sp.ents)-1].Seqno
+}
+
+// pageEntries looks up in h.index where the page pageBefore wants
+// is. It does no I/O, and only holds h.mut long enough to copy
+// the entries; so the hub can call it.
+func (h *HashRBook) pageEntries(before, n int) (sp *pageSpan) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	ents := h.index
+	end := len(ents)
+	if before >= 0 {
+		end = sort.Search(len(ents), func(i int) bool { return ents[i].Seqno >= before })
+	}
+	beg := 0
+	if n > 0 && end-n > 0 {
+		beg = end - n
+	}
+	return &pageSpan{
+		ents: append([]indexEntry{}, ents[beg:end]...),
+		more: beg > 0,
+		path: h.diskPath,
+	}
+}
+
+// readPage reads the page at sp from the book file, without
+// holding h.mut, so a big replay does not hold up archiveElem.
+// Only the messages are read; see readMsgAt.
+func (h *HashRBook) readPage(sp *pageSpan) (pg *replayPage) {
+	pg = &replayPage{oldest: -1, more: sp.more}
+	if len(sp.ents) == 0 {
+		return
+	}
+	fd, err := os.Open(sp.path)
+	if err != nil {
+		vvlog("readPage(): could not open book '%v', replaying from memory instead: '%v'", sp.path, err)
+		return h.pageBeforeFromMemory(sp.ents, pg)
+	}
+	defer fd.Close()
+
+	lastSeqno := -1
+	for i, ent := range sp.ents {
+		if i > 0 && ent.Seqno == lastSeqno {
+			// older books could have the same element twice.
+			continue
+		}
+		lastSeqno = ent.Seqno
+		e, err := readMsgAt(fd, ent.Offset)
+		if err != nil || e.Seqno != ent.Seqno {
+			vvlog("readPage(): index for '%v' does not match the book at offset %v (err='%v'); replaying from memory instead.", sp.path, ent.Offset, err)
+			return h.pageBeforeFromMemory(sp.ents, &replayPage{oldest: -1, more: sp.more})
+		}
+		pg.add(e)
+	}
+	return
+}
+
+// pageBeforeFromMemory is the fallback for pageBefore when the
+// book file is not usable, as when git has just replaced it.
+func (h *HashRBook) pageBeforeFromMemory(ents []indexEntry, pg *replayPage) *replayPage {
+	if len(ents) == 0 {
+		return pg
+	}
+	first, last := ents[0].Seqno, ents[len(ents)-1].Seqno
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	lastSeqno := -1
+	for i, e := range h.elems {
+		if e.Seqno < first || e.Seqno > last || (i > 0 && e.Seqno == lastSeqno) {
+			continue
+		}
+		lastSeqno = e.Seqno
+		pg.add(e)
+	}
+	return pg
+}
+
+func (pg *replayPage) add(e *HashRElem) {
+	if pg.oldest < 0 {
+		pg.oldest = e.Seqno
+	}
+	if pg.firstLine == 0 && e.Typ == Command {
+		pg.firstLine = e.BeginCommandLineNum
+	}
+	if len(e.msg) > 0 {
+		pg.msgs = append(pg.msgs, e.msg)
+	}
+}
+
+func prepPageStartMessage(pg *replayPage) string {
+	json := fmt.Sprintf(`{"pageStart":true, "oldest":%v, "more":%v, "firstLine":%v}`, pg.oldest, pg.more, pg.firstLine)
+	lenPrefixedJson := fmt.Sprintf("%v:%v", len(json), json)
+	return lenPrefixedJson
+}
+
+func prepPageEndMessage() string {
+	json := `{"pageEnd":true}`
+	lenPrefixedJson := fmt.Sprintf("%v:%v", len(json), json)
+	return lenPrefixedJson
+}
+
+// parsePageRequest returns the seqno the browser wants the
+// page before, if msg is a request for older cells.
+func parsePageRequest(msg []byte) (before int, ok bool) {
+	var req struct {
+		Older *int `json:"older"`
+	}
+	if json.Unmarshal(msg, &req) != nil || req.Older == nil {
+		return 0, false
+	}
+	return *req.Older, true
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestIndexAndPagedReplay(t *testing.T) {
+
+	cv.Convey("ReadBook should keep a seqno -> offset index in <book>.idx, rebuilding it when missing, and pageBefore should serve the most recent cells first, then older pages", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-replay")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "replay.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		writeTestBook(path, 5)
+		panicOn(os.Remove(indexPathFor(path)))
+
+		h, appendFD, err := ReadBook("tester", "testhost", path)
+		panicOn(err)
+		appendFD.Close()
+		cv.So(FileExists(indexPathFor(path)), cv.ShouldBeTrue)
+
+		ents, err := readIndexFile(path, h.BookID)
+		panicOn(err)
+		cv.So(len(ents), cv.ShouldEqual, 5)
+		cv.So(sameIndex(ents, h.index), cv.ShouldBeTrue)
+
+		fd, err := os.Open(path)
+		panicOn(err)
+		for _, ent := range ents {
+			e, err := readFrameAt(fd, ent.Offset)
+			panicOn(err)
+			cv.So(e.Seqno, cv.ShouldEqual, ent.Seqno)
+		}
+		fd.Close()
+
+		// the index for another book is not ours.
+		_, err = readIndexFile(path, "some-other-BookID")
+		cv.So(err, cv.ShouldNotBeNil)
+
+		pg := h.pageBefore(-1, 2)
+		cv.So(len(pg.msgs), cv.ShouldEqual, 2)
+		cv.So(pg.oldest, cv.ShouldEqual, 3)
+		cv.So(pg.more, cv.ShouldBeTrue)
+		cv.So(pg.firstLine, cv.ShouldEqual, 4)
+		cv.So(string(pg.msgs[1]), cv.ShouldEqual, string(h.elems[4].msg))
+
+		pg = h.pageBefore(pg.oldest, 2)
+		cv.So(pg.oldest, cv.ShouldEqual, 1)
+		cv.So(pg.more, cv.ShouldBeTrue)
+
+		pg = h.pageBefore(pg.oldest, 2)
+		cv.So(len(pg.msgs), cv.ShouldEqual, 1)
+		cv.So(pg.oldest, cv.ShouldEqual, 0)
+		cv.So(pg.more, cv.ShouldBeFalse)
+
+		// 0 means everything.
+		cv.So(len(h.pageBefore(-1, 0).msgs), cv.ShouldEqual, 5)
+
+		// a missing book file falls back to memory.
+		h.diskPath = filepath.Join(dir, "gone.rbook")
+		pg = h.pageBefore(-1, 2)
+		cv.So(len(pg.msgs), cv.ShouldEqual, 2)
+		cv.So(pg.oldest, cv.ShouldEqual, 3)
+
+		before, ok := parsePageRequest([]byte(`{"older": 17}`))
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(before, cv.ShouldEqual, 17)
+		_, ok = parsePageRequest([]byte(`hello`))
+		cv.So(ok, cv.ShouldBeFalse)
+	})
+}
+
+func TestHubDropsSlowClient(t *testing.T) {
+
+	cv.Convey("The hub must not wait on a client that has stopped reading: once clientQueue batches are waiting for it, the client is dropped and the others still get every broadcast", t, func() {
+
+		h := newHub(nil, 10)
+		slow := &Client{hub: h, send: make(chan [][]byte, clientQueue)}
+		fast := &Client{hub: h, send: make(chan [][]byte, clientQueue)}
+		h.clients[slow] = true
+		h.clients[fast] = true
+
+		for i := 0; i < clientQueue; i++ {
+			cv.So(h.sendTo(slow, []byte("x")), cv.ShouldBeTrue)
+		}
+		cv.So(h.sendTo(slow, []byte("one too many")), cv.ShouldBeFalse)
+		cv.So(h.clients[slow], cv.ShouldBeFalse)
+		cv.So(h.clients[fast], cv.ShouldBeTrue)
+
+		// slow's writePump sees the close after the queued batches.
+		n := 0
+		for range slow.send {
+			n++
+		}
+		cv.So(n, cv.ShouldEqual, clientQueue)
+
+		cv.So(h.sendTo(fast, []byte("a"), []byte("b")), cv.ShouldBeTrue)
+		batch := <-fast.send
+		cv.So(len(batch), cv.ShouldEqual, 2)
+		cv.So(string(batch[1]), cv.ShouldEqual, "b")
+	})
+}
+
+func TestHubReadsPagesOffItsGoroutine(t *testing.T) {
+
+	cv.Convey("The hub should leave reading pages of the book to another goroutine, which reads only the messages, not the image bytes; broadcasts that come meanwhile follow a new client's first page, less those already in it", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-replay")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "hub.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		big := make([]byte, 1<<20)
+		imsg := prepImageMessage("hub.rbook.plots/p.png", "hash", 1)
+		msg, n := prepCommandMessage("plot(1)", 0)
+		writeHostBook(path, "testhost", []*HashRElem{
+			{Typ: Command, Tm: time.Now(), CmdJSON: msg, BeginCommandLineNum: 1, NumCommandLines: n},
+			{Typ: Image, Tm: time.Now(), ImageJSON: imsg, ImagePath: "hub.rbook.plots/p.png", ImageBy: big},
+			{Typ: Comment, Tm: time.Now(), CommentJSON: prepCommentMessage("after", 2)},
+		})
+		h, appendFD, err := ReadBook("tester", "testhost", path)
+		panicOn(err)
+		appendFD.Close()
+
+		fd, err := os.Open(path)
+		panicOn(err)
+		for _, ent := range h.index {
+			e, err := readMsgAt(fd, ent.Offset)
+			panicOn(err)
+			full, err := readFrameAt(fd, ent.Offset)
+			panicOn(err)
+			cv.So(e.Seqno, cv.ShouldEqual, full.Seqno)
+			cv.So(e.Typ, cv.ShouldEqual, full.Typ)
+			cv.So(string(e.msg), cv.ShouldEqual, string(full.msg))
+			cv.So(e.ImageBy == nil, cv.ShouldBeTrue)
+		}
+		fd.Close()
+
+		hub := newHub(h, 2)
+		c := &Client{hub: hub, send: make(chan [][]byte, clientQueue)}
+		hub.addClient(c)
+		// seqno 2 is in the first page already; 3 is not.
+		hub.broadcastElem(h.elems[2])
+		hub.broadcastElem(&HashRElem{Seqno: 3, msg: []byte("new")})
+		cv.So(len(c.send), cv.ShouldEqual, 0)
+		hub.sendPage(<-hub.ready)
+		batch := <-c.send
+		cv.So(len(batch), cv.ShouldEqual, 4)
+		cv.So(string(batch[0]), cv.ShouldContainSubstring, `"init":true`)
+		cv.So(string(batch[1]), cv.ShouldEqual, imsg)
+		cv.So(string(batch[3]), cv.ShouldEqual, "new")
+
+		// from now on, broadcasts go straight to the client.
+		hub.broadcastElem(&HashRElem{Seqno: 4, msg: []byte("newer")})
+		cv.So(string((<-c.send)[0]), cv.ShouldEqual, "newer")
+
+		// an older page.
+		go hub.readPageFor(c, h.pageEntries(1, hub.replayN), false)
+		hub.sendPage(<-hub.ready)
+		batch = <-c.send
+		cv.So(len(batch), cv.ShouldEqual, 3)
+		cv.So(string(batch[0]), cv.ShouldContainSubstring, `"pageStart":true`)
+		cv.So(string(batch[1]), cv.ShouldEqual, msg)
+
+		// a client whose first page never comes cannot hold
+		// broadcasts without limit.
+		stuck := &Client{hub: hub, send: make(chan [][]byte, clientQueue)}
+		hub.clients[stuck] = true
+		hub.waiting[stuck] = nil
+		for i := 0; i <= clientQueue; i++ {
+			hub.broadcastElem(&HashRElem{Seqno: 5 + i, msg: []byte("x")})
+			<-c.send
+		}
+		cv.So(hub.clients[stuck], cv.ShouldBeFalse)
+		cv.So(hub.clients[c], cv.ShouldBeTrue)
+	})
+}
@@ -30,6 +30,18 @@ const (
 	// user notes, and requests to fold (hide) a big output.
 	OverlayLaterNote  HashRTyp = 16
 	OverlayHideOutput HashRTyp = 32
+
+	// an R error: the command that failed, R's condition
+	// message, and the traceback() at the time.
+	Error HashRTyp = 64
+
+	// the first rows of a printed data.frame or matrix, as
+	// values; see tables.go.
+	Table HashRTyp = 128
+
+	// a printed htmlwidget (plotly, leaflet, DT), as one
+	// self-contained html page; see widgets.go.
+	Widget HashRTyp = 256
 )
 
 func (ty HashRTyp) String() string {
@@ -47,8 +59,16 @@ func (ty HashRTyp) String() string {
 		return "OverlayLaterNote"
 	case OverlayHideOutput:
 		return "OverlayHideOutput"
+
+	case Error:
+		return "Error"
+	case Table:
+		return "Table"
+	case Widget:
+		return "Widget"
 	}
-	panic(fmt.Sprintf("unrecognized HashRTyp = %v", int(ty)))
+	// from a newer rbook; we keep these, but cannot show them.
+	return fmt.Sprintf("HashRTyp(%v)", int(ty))
 }
 
 // HashRElem are the "cells" of a notebook; in this
@@ -117,8 +137,91 @@ type HashRElem struct {
 	OverlayHideSeqno     int    `msg:"overlayHideSeqno" json:"overlayHideSeqno" zid:"14"`
 	OverlayHideSeqnoJSON string `msg:"overlayHideSeqnoJSON" json:"overlayHideSeqnoJSON" zid:"15"`
 
+	// Checksum is the blake2b hash of this element as serialized
+	// with an empty Checksum; see e.ComputeChecksum(). SaveToSlice()
+	// fills it in, and rbook -verify checks it. Books written before
+	// we had it simply lack it; and older rbook binaries skip
+	// over it, as they do any zid they don't know.
+	Checksum string `msg:"checksum" json:"checksum" zid:"16"`
+
+	// PrevHash is the Checksum of the element before this one,
+	// or for the first element, a hash of the book's BookID and
+	// CreateTm. Since Checksum covers PrevHash, the
+	// elements form a hash chain, and any later edit, insertion,
+	// deletion, or re-ordering changes the chain head that
+	// rbook -attest prints. See chain.go.
+	PrevHash string `msg:"prevHash" json:"prevHash" zid:"17"`
+
+	// ImageHash is set instead of ImageBy when rbook -blobs is on:
+	// the png bytes are then kept once, under this checksum, in
+	// the <book>.blobs/ store beside the book; see blobs.go.
+	ImageHash string `msg:"imageHash" json:"imageHash" zid:"18"`
+
+	// SourceHost and SourceBookID are set by rbook -merge: the
+	// Host and BookID of the book this element first came from.
+	SourceHost   string `msg:"sourceHost" json:"sourceHost" zid:"19"`
+	SourceBookID string `msg:"sourceBookID" json:"sourceBookID" zid:"20"`
+
+	// 7th type: an R error; see prepErrorMessage().
+	ErrorJSON string `msg:"errorJSON" json:"errorJSON" zid:"21"`
+
+	// For a Command: how long it ran, and the memory it used; see
+	// stats.go. Zero when not measured, as in older books.
+	//
+	// WallNs is from when the command's input arrived to when it
+	// was done, so not time spent at the prompt; CPUNs is the user
+	// plus system time of the process over the same call.
+	WallNs int64 `msg:"wallNs" json:"wallNs" zid:"22"`
+	CPUNs  int64 `msg:"cpuNs" json:"cpuNs" zid:"23"`
+
+	// The memory in use, in bytes, before and after the command:
+	// the resident set of the process, in which R's heap lives.
+	// Asking R for its heap alone would mean a gc() each time.
+	// RHeapPeak is no longer recorded, for the same reason; we
+	// keep it so books that have it read back unchanged.
+	RHeapBefore int64 `msg:"rHeapBefore" json:"rHeapBefore" zid:"24"`
+	RHeapAfter  int64 `msg:"rHeapAfter" json:"rHeapAfter" zid:"25"`
+	RHeapPeak   int64 `msg:"rHeapPeak" json:"rHeapPeak" zid:"26"`
+
+	// MaxRSS is the peak resident set size of the whole process
+	// since it started, in bytes, as of the end of the command.
+	// It is not of the command alone.
+	MaxRSS int64 `msg:"maxRSS" json:"maxRSS" zid:"27"`
+
+	// ImageMIME is the MIME type of ImageBy; "" in older
+	// books, where it is always image/png.
+	ImageMIME string `msg:"imageMIME" json:"imageMIME" zid:"28"`
+
+	// vector renderings of the same plot, made at capture time
+	// under rbook -vector; see vector.go. Empty if not made.
+	ImageSVG []byte `msg:"imageSVG" json:"imageSVG" zid:"29"`
+	ImagePDF []byte `msg:"imagePDF" json:"imagePDF" zid:"30"`
+
+	// PlotRDS is the saveRDS() of the recordPlot() taken when
+	// the plot was captured, so it can be replayed at another
+	// size or format; see render.go. Empty in older books.
+	PlotRDS []byte `msg:"plotRDS" json:"plotRDS" zid:"31"`
+
+	// 8th type: a table; see prepTableMessage().
+	TableJSON string `msg:"tableJSON" json:"tableJSON" zid:"32"`
+
+	// 9th type: an htmlwidget; see prepWidgetMessage(). WidgetHTML
+	// is the page htmlwidgets::saveWidget() wrote, scripts and all,
+	// and WidgetHash its checksum, by which /widget/ serves it.
+	WidgetJSON string `msg:"widgetJSON" json:"widgetJSON" zid:"33"`
+	WidgetHTML []byte `msg:"widgetHTML" json:"widgetHTML" zid:"34"`
+	WidgetHash string `msg:"widgetHash" json:"widgetHash" zid:"35"`
+
+	// fields from a newer rbook, kept to write back out; see format.go.
+	unknown unknownFields
+
 	// convenience, not on disk.
 	msg []byte
+
+	// under ReadBookLazy, true when ImageBy (or a Widget's
+	// WidgetHTML) has been let go, to be read back from the
+	// book file on demand.
+	lazyImage bool
 }
 
 func (e *HashRElem) LastCommandLineNumber() int {
@@ -145,9 +248,29 @@ HashRElem{
 	OverlayNoteJSON: %v,
 	OverlayHideSeqno: %v,
 	OverlayHideSeqnoJSON: %v,
+	Checksum: %v,
+	PrevHash: %v,
+	ImageHash: %v,
+	SourceHost: %v,
+	SourceBookID: %v,
+	ErrorJSON: %v,
+	WallNs: %v,
+	CPUNs: %v,
+	RHeapBefore: %v,
+	RHeapAfter: %v,
+	RHeapPeak: %v,
+	MaxRSS: %v,
+	ImageMIME: %v,
+	ImageSVG: (len: %v),
+	ImagePDF: (len: %v),
+	PlotRDS: (len: %v),
+	TableJSON: %v,
+	WidgetJSON: %v,
+	WidgetHTML: (len: %v),
+	WidgetHash: %v,
 
 }
-`, e.Typ, e.Tm, e.Seqno, e.CmdJSON, e.ConsoleJSON, e.CommentJSON, e.ImageJSON, e.ImageHost, e.ImagePath, len(e.ImageBy), e.ImagePathHash, e.OverlayNoteJSON, e.OverlayHideSeqno, e.OverlayHideSeqnoJSON)
+`, e.Typ, e.Tm, e.Seqno, e.CmdJSON, e.ConsoleJSON, e.CommentJSON, e.ImageJSON, e.ImageHost, e.ImagePath, len(e.ImageBy), e.ImagePathHash, e.OverlayNoteJSON, e.OverlayHideSeqno, e.OverlayHideSeqnoJSON, e.Checksum, e.PrevHash, e.ImageHash, e.SourceHost, e.SourceBookID, e.ErrorJSON, e.WallNs, e.CPUNs, e.RHeapBefore, e.RHeapAfter, e.RHeapPeak, e.MaxRSS, e.ImageMIME, len(e.ImageSVG), len(e.ImagePDF), len(e.PlotRDS), e.TableJSON, e.WidgetJSON, len(e.WidgetHTML), e.WidgetHash)
 }
 
 // The header, aka init message.
@@ -162,6 +285,21 @@ type HashRBook struct {
 	Host string `msg:"host" json:"host" zid:"3"`
 	Path string `msg:"path" json:"path" zid:"4"`
 
+	// FormatVersion is the BookFormatVersion of the rbook
+	// that wrote the header; 0 for books from before we kept it.
+	FormatVersion int `msg:"formatVersion" json:"formatVersion" zid:"6"`
+
+	// ParentBookID is the BookID of the book that rbook -extract
+	// took this one from; empty for a book started afresh.
+	ParentBookID string `msg:"parentBookID" json:"parentBookID" zid:"7"`
+
+	// Checksum of the header, as for HashRElem.Checksum. Books
+	// before format version 5 keep it at zid 5; see marshalAll.
+	Checksum string `msg:"checksum" json:"checksum" zid:"8"`
+
+	// fields from a newer rbook, kept to write back out; see format.go.
+	unknown unknownFields
+
 	// held for convenience here, but serialized
 	// separately afterwards on disk and individually
 	// on the wire, so lower case elems.
@@ -172,18 +310,43 @@ type HashRBook struct {
 	// my.rbook file is complete by itself. Also hold mut when read/writing it.
 	path2image map[string]*HashRElem
 
+	// chainHead is the hash chain head after the last of elems;
+	// the next element's PrevHash. Hold mut when read/writing it.
+	chainHead string
+
+	// index has the offset of each of elems within the book
+	// file, and is mirrored in the <book>.idx file; see index.go.
+	// Hold mut when read/writing it.
+	index []indexEntry
+
+	// diskPath is where we read the book from, made absolute,
+	// so that a setwd() in R does not lose it. Path is where
+	// it was first written, so they differ when a book has
+	// been copied or moved.
+	diskPath string
+
+	// under ReadBookLazy: lazy is set, and readFD is kept open
+	// on the book file to read images back from. Hold mut to use readFD.
+	lazy   bool
+	readFD *os.File
+
+	// where images saved under rbook -blobs live.
+	blobs *blobStore
+
 	// must hold when reading/writing elems
 	mut sync.Mutex
 }
 
 func NewHashRBook(user, host, path string) *HashRBook {
 	return &HashRBook{
-		CreateTm:   time.Now(),
-		BookID:     cryrand.RandomStringWithUp(24),
-		User:       user,
-		Host:       host,
-		Path:       path,
-		path2image: make(map[string]*HashRElem),
+		CreateTm:      time.Now(),
+		BookID:        cryrand.RandomStringWithUp(24),
+		User:          user,
+		Host:          host,
+		Path:          path,
+		FormatVersion: BookFormatVersion,
+		path2image:    make(map[string]*HashRElem),
+		blobs:         newBlobStore(path),
 	}
 }
 
@@ -196,33 +359,65 @@ func NewHashRBook(user, host, path string) *HashRBook {
 // .
 func (h *HashRBook) DeletePathAndReSaveFullBook(path string) (appendFD *os.File) {
 	var err error
+	if h.lazy {
+		// get back the images, before we truncate.
+		h.mut.Lock()
+		h.materializeImages()
+		h.mut.Unlock()
+	}
 	appendFD, err = os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND|os.O_TRUNC, 0660)
 	panicOn(err)
 	h.Path = path
 
 	h.mut.Lock()
 	defer h.mut.Unlock()
+	h.diskPath = absPath(path)
 
 	var by []byte
 	by, err = h.SaveToSlice()
 	panicOn(err)
 	_, err = appendFD.Write(by)
 	panicOn(err)
+	offset := int64(len(by))
 
 	// write all elements
+	h.index = h.index[:0]
 	for _, e := range h.elems {
 		by, err := e.SaveToSlice()
 		panicOn(err)
 		_, err = appendFD.Write(by)
 		panicOn(err)
+		h.index = append(h.index, indexEntry{Seqno: e.Seqno, Offset: offset})
+		offset += int64(len(by))
 	}
 	err = appendFD.Sync()
 	panicOn(err)
+	h.saveIndexIfStale(path)
+	if h.lazy {
+		h.readFD.Close()
+		h.startLazy(path)
+		for _, e := range h.elems {
+			e.dropImage()
+		}
+	}
 
 	return
 }
 
+// ReadBook reads the book at path, creating it if need be,
+// and returns it with appendFD open for appending to it.
 func ReadBook(user, host, path string) (h *HashRBook, appendFD *os.File, err error) {
+	return loadBookForAppend(user, host, path, false)
+}
+
+// ReadBookLazy is like ReadBook, but does not hold on to plot
+// image bytes. Those are read back from the book file when a
+// browser asks for them; see lazy.go.
+func ReadBookLazy(user, host, path string) (h *HashRBook, appendFD *os.File, err error) {
+	return loadBookForAppend(user, host, path, true)
+}
+
+func loadBookForAppend(user, host, path string, lazy bool) (h *HashRBook, appendFD *os.File, err error) {
 
 	fresh := true
 	if FileExists(path) {
@@ -242,34 +437,103 @@ func ReadBook(user, host, path string) (h *HashRBook, appendFD *os.File, err err
 		var by []byte
 		by, err = h.SaveToSlice()
 		panicOn(err)
+		h.chainHead = h.chainAnchor()
 		_, err = appendFD.Write(by)
 		panicOn(err)
 		err = appendFD.Sync()
+		h.diskPath = absPath(path)
+		h.saveIndexIfStale(path)
+		if lazy {
+			h.startLazy(path)
+		}
 		return
 	}
 
-	mpr := msgp.NewReader(appendFD)
-	h, err = LoadBook(mpr)
+	sz, err := FileSize(path)
 	panicOn(err)
 
+	scan := newBookFrameScanner(appendFD)
+	h, err = LoadBook(scan.mpr)
+	panicOn(err)
+	if h.FormatVersion > BookFormatVersion {
+		// we keep what we do not know, so appending is safe; but
+		// we cannot show it all.
+		msg := fmt.Sprintf("rbook: note: '%v' is format version %v, newer than this rbook (%v). Parts of it may not be shown.", path, h.FormatVersion, BookFormatVersion)
+		fmt.Fprintf(os.Stderr, "%v\n", msg)
+		vvlog(msg)
+	}
+	h.chainHead = h.chainAnchor()
+	h.blobs = newBlobStore(path)
+	h.diskPath = absPath(path)
+
 	var e *HashRElem
 	for {
-		e, err = LoadElem(mpr)
+		goodOffset := scan.offset()
+		e, err = LoadElem(scan.mpr)
 		if err == io.EOF {
 			err = nil
-			return
+			break
+		}
+		if err == TruncatedFrame {
+			// rbook died in the middle of appending the last
+			// record. Set aside the partial bytes and keep going.
+			err = repairTornTail(appendFD, path, goodOffset, sz)
+			panicOn(err)
+			err = nil
+			break
+		}
+		if err != nil {
+			// corrupt, not torn: leave the file as it is.
+			appendFD.Close()
+			return nil, nil, fmt.Errorf("ReadBook('%v') error on the frame at offset %v: '%v'; see rbook -fsck", path, goodOffset, err)
 		}
-		panicOn(err)
 		//vv("got '%v'", e)
 		h.elems = append(h.elems, e)
+		h.index = append(h.index, indexEntry{Seqno: e.Seqno, Offset: goodOffset})
+		if !knownTyp(e.Typ) {
+			vvlog("ReadBook('%v'): keeping seqno %v of unknown type %v", path, e.Seqno, e.Typ)
+		}
 
 		if e.Typ == Image {
 			h.path2image[e.ImagePath] = e
 		}
+
+		// trust the stored checksums here, for a quick startup.
+		// rbook -verify and -attest recompute them all.
+		h.chainHead, err = chainLink(h.chainHead, e, true, h.FormatVersion < chainFormatVersion)
+		if err != nil {
+			// keep going, but we cannot promise an intact chain.
+			vvlog("ReadBook('%v'): %v", path, err)
+			err = nil
+		}
+		if lazy {
+			e.dropImage()
+		}
+	}
+	h.saveIndexIfStale(path)
+	if lazy {
+		h.startLazy(path)
 	}
 	return
 }
 
+// appendElem adds e to the end of h.elems, linking e into the
+// hash chain by setting its PrevHash and Checksum.
+// Caller must hold h.mut.
+func (h *HashRBook) appendElem(e *HashRElem) {
+	e.PrevHash = h.chainHead
+	sum, err := e.ComputeChecksum()
+	panicOn(err)
+	e.Checksum = sum
+	h.chainHead = sum
+
+	h.elems = append(h.elems, e)
+	if e.ImagePath != "" {
+		//vv("saving e.ImagePath '%v' to path2image", e.ImagePath)
+		h.path2image[e.ImagePath] = e
+	}
+}
+
 type ByteSlice []byte
 
 // read a HashRElem into e from r.
@@ -296,6 +560,11 @@ func LoadElem(r *msgp.Reader) (e *HashRElem, err error) {
 		return nil, err
 	}
 	if err == io.EOF {
+		if r.R.Buffered() > 0 {
+			// a lone byte at the end of the file: not even
+			// a whole frame header made it to disk.
+			return nil, TruncatedFrame
+		}
 		return nil, err
 	}
 	if err != nil {
@@ -303,13 +572,24 @@ func LoadElem(r *msgp.Reader) (e *HashRElem, err error) {
 	}
 
 	//ninside, ntotal, nheader, err := UnframeBinMsgpack(by)
-	ntotal, _, _, err := UnframeBinMsgpack(by)
+	ntotal, _, nheader, err := UnframeBinMsgpack(by)
 
+	if err == NotEnoughBytes {
+		// we already peeked as far as the file goes.
+		return nil, TruncatedFrame
+	}
 	if err != nil {
 		return nil, fmt.Errorf("LoadElem() error on UnframeBinMsgPack(): '%s'", err)
 	}
 
-	_, err = r.R.Peek(ntotal)
+	by, err = r.R.Peek(ntotal)
+	if err == io.EOF {
+		// the header promises more bytes than are on disk.
+		if !tornFrame(by[nheader:]) {
+			return nil, CorruptFrameLength
+		}
+		return nil, TruncatedFrame
+	}
 	if err != nil {
 		return nil, fmt.Errorf("LoadElem() error on Peek() call for ntotal bytes: '%s'", err)
 	}
@@ -325,20 +605,33 @@ func LoadElem(r *msgp.Reader) (e *HashRElem, err error) {
 	if err != nil {
 		return nil, fmt.Errorf("LoadElem() error on tk.UnmarshalMsg(): '%s'", err)
 	}
+	err = ue.keepUnknown(bs2)
+	if err != nil {
+		return nil, fmt.Errorf("LoadElem() error on keepUnknown(): '%s'", err)
+	}
 
-	// fill the msg convenience for refreshing new clients with history
-	switch ue.Typ {
+	ue.setMsg()
+	return &ue, nil
+}
+
+// setMsg fills the msg convenience for refreshing new clients with history.
+func (e *HashRElem) setMsg() {
+	switch e.Typ {
 	case Command:
-		ue.msg = []byte(ue.CmdJSON)
+		e.msg = []byte(e.CmdJSON)
 	case Console:
-		ue.msg = []byte(ue.ConsoleJSON)
+		e.msg = []byte(e.ConsoleJSON)
 	case Image:
-		ue.msg = []byte(ue.ImageJSON)
+		e.msg = []byte(e.ImageJSON)
 	case Comment:
-		ue.msg = []byte(ue.CommentJSON)
+		e.msg = []byte(e.CommentJSON)
+	case Error:
+		e.msg = []byte(e.ErrorJSON)
+	case Table:
+		e.msg = []byte(e.TableJSON)
+	case Widget:
+		e.msg = []byte(e.WidgetJSON)
 	}
-
-	return &ue, nil
 }
 
 // Save HashRElem as a framed msgpack message (where first few bytes are a []byte encoded
@@ -346,7 +639,13 @@ func LoadElem(r *msgp.Reader) (e *HashRElem, err error) {
 // bytes consist themselves of a msgpack serialized Tk.
 func (e *HashRElem) SaveToSlice() ([]byte, error) {
 
-	b, err := e.MarshalMsg(nil)
+	sum, err := e.ComputeChecksum()
+	if err != nil {
+		return nil, fmt.Errorf("HashRElem.SaveToSlice() error on ComputeChecksum: '%s'", err)
+	}
+	e.Checksum = sum
+
+	b, err := e.marshalAll()
 	if err != nil {
 		return nil, fmt.Errorf("HashRElem.SaveToSlice() error on MarshalMsg: '%s'", err)
 	}
@@ -406,6 +705,10 @@ func LoadBook(r *msgp.Reader) (book *HashRBook, err error) {
 	if err != nil {
 		return nil, fmt.Errorf("LoadElem() error on tk.UnmarshalMsg(): '%s'", err)
 	}
+	err = ue.keepUnknown(bs2)
+	if err != nil {
+		return nil, fmt.Errorf("LoadBook() error on keepUnknown(): '%s'", err)
+	}
 	ue.path2image = make(map[string]*HashRElem)
 
 	return &ue, nil
@@ -416,7 +719,13 @@ func LoadBook(r *msgp.Reader) (book *HashRBook, err error) {
 // bytes consist themselves of a msgpack serialized Tk.
 func (book *HashRBook) SaveToSlice() ([]byte, error) {
 
-	b, err := book.MarshalMsg(nil)
+	sum, err := book.ComputeChecksum()
+	if err != nil {
+		return nil, fmt.Errorf("HashRBook.SaveToSlice() error on ComputeChecksum: '%s'", err)
+	}
+	book.Checksum = sum
+
+	b, err := book.marshalAll()
 	if err != nil {
 		return nil, fmt.Errorf("HashRBook.SaveToSlice() error on MarshalMsg: '%s'", err)
 	}
@@ -434,14 +743,51 @@ type UnframeError int
 const (
 	NotEnoughBytes UnframeError = -1
 	NotBinarySlice UnframeError = -2
+
+	// TruncatedFrame is returned by LoadElem when the
+	// file ends before the frame does; as happens if
+	// rbook died in the middle of an append.
+	TruncatedFrame UnframeError = -3
+
+	// CorruptFrameLength is returned by LoadElem when the
+	// file ends before the frame says it does, but that is
+	// not because the frame was torn: a whole element, and
+	// then another frame, follow its header. Its length is
+	// wrong, and the frames after it are not to be thrown
+	// away as a torn tail.
+	CorruptFrameLength UnframeError = -4
 )
 
+// tornFrame says if rest, all that follows a frame header up to
+// the end of the file, is what an append cut short leaves: a
+// part of an element. If rest holds a whole element, followed by
+// the start of another frame or by nothing, then the frame's
+// length is what is wrong.
+func tornFrame(rest []byte) bool {
+	if msgp.NextType(rest) != msgp.MapType {
+		return true
+	}
+	after, err := msgp.Skip(rest)
+	if err != nil {
+		return true
+	}
+	if len(after) == 0 {
+		return false
+	}
+	_, _, _, err = UnframeBinMsgpack(after)
+	return err == NotBinarySlice
+}
+
 func (e UnframeError) Error() string {
 	switch e {
 	case NotEnoughBytes:
 		return "UnframeBinMsgpack() error: NotEnoughBytes"
 	case NotBinarySlice:
 		return "UnframeBinMsgpack() error: NotBinarySlice: could not find 0xC4, 0xC5, 0xC6 in start of binary msgpack"
+	case TruncatedFrame:
+		return "LoadElem() error: TruncatedFrame: file ends before the last frame does"
+	case CorruptFrameLength:
+		return "LoadElem() error: CorruptFrameLength: a frame's length runs past the end of the file, though a whole element follows it; the book is corrupt, not torn"
 	default:
 		return "UnknownUnframeError"
 	}
@@ -489,10 +835,8 @@ func UnframeBinMsgpack(p []byte) (ntotal int, ninside int, nheader int, err erro
 		nheader = 5
 		ntotal = ninside + nheader
 	default:
-		fmt.Printf("p bytes = '%#v'\n", p[:5])
-		fmt.Printf("p bytes = '%#v'/as string='%v'\n", p, string(p))
+		// corrupt, or not a book at all. Let the caller decide what to do.
 		err = NotBinarySlice
-		panic(err)
 	}
 	return
 }
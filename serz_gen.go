@@ -128,7 +128,7 @@ func (z *HashRBook) DecodeMsg(dc *msgp.Reader) (err error) {
 
 	var field []byte
 	_ = field
-	const maxFields2zgensym_965f3afadc761adf_3 = 5
+	const maxFields2zgensym_965f3afadc761adf_3 = 8
 
 	// -- templateDecodeMsg starts here--
 	var totalEncodedFields2zgensym_965f3afadc761adf_3 uint32
@@ -207,6 +207,24 @@ doneWithStruct2zgensym_965f3afadc761adf_3:
 			if err != nil {
 				return
 			}
+		case "formatVersion_zid06_int":
+			found2zgensym_965f3afadc761adf_3[5] = true
+			z.FormatVersion, err = dc.ReadInt()
+			if err != nil {
+				return
+			}
+		case "parentBookID_zid07_str":
+			found2zgensym_965f3afadc761adf_3[6] = true
+			z.ParentBookID, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "checksum_zid08_str":
+			found2zgensym_965f3afadc761adf_3[7] = true
+			z.Checksum, err = dc.ReadString()
+			if err != nil {
+				return
+			}
 		default:
 			err = dc.Skip()
 			if err != nil {
@@ -230,16 +248,16 @@ doneWithStruct2zgensym_965f3afadc761adf_3:
 }
 
 // fields of HashRBook
-var decodeMsgFieldOrder2zgensym_965f3afadc761adf_3 = []string{"createTm_zid00_tim", "bookID_zid01_str", "user_zid02_str", "host_zid03_str", "path_zid04_str"}
+var decodeMsgFieldOrder2zgensym_965f3afadc761adf_3 = []string{"createTm_zid00_tim", "bookID_zid01_str", "user_zid02_str", "host_zid03_str", "path_zid04_str", "formatVersion_zid06_int", "parentBookID_zid07_str", "checksum_zid08_str"}
 
-var decodeMsgFieldSkip2zgensym_965f3afadc761adf_3 = []bool{false, false, false, false, false}
+var decodeMsgFieldSkip2zgensym_965f3afadc761adf_3 = []bool{false, false, false, false, false, false, false, false}
 
 // fieldsNotEmpty supports omitempty tags
 func (z *HashRBook) fieldsNotEmpty(isempty []bool) uint32 {
 	if len(isempty) == 0 {
-		return 5
+		return 8
 	}
-	var fieldsInUse uint32 = 5
+	var fieldsInUse uint32 = 8
 	isempty[0] = (z.CreateTm.IsZero()) // time.Time, omitempty
 	if isempty[0] {
 		fieldsInUse--
@@ -260,6 +278,18 @@ func (z *HashRBook) fieldsNotEmpty(isempty []bool) uint32 {
 	if isempty[4] {
 		fieldsInUse--
 	}
+	isempty[5] = (z.FormatVersion == 0) // number, omitempty
+	if isempty[5] {
+		fieldsInUse--
+	}
+	isempty[6] = (len(z.ParentBookID) == 0) // string, omitempty
+	if isempty[6] {
+		fieldsInUse--
+	}
+	isempty[7] = (len(z.Checksum) == 0) // string, omitempty
+	if isempty[7] {
+		fieldsInUse--
+	}
 
 	return fieldsInUse
 }
@@ -271,7 +301,7 @@ func (z *HashRBook) EncodeMsg(en *msgp.Writer) (err error) {
 	}
 
 	// honor the omitempty tags
-	var empty_zgensym_965f3afadc761adf_4 [5]bool
+	var empty_zgensym_965f3afadc761adf_4 [8]bool
 	fieldsInUse_zgensym_965f3afadc761adf_5 := z.fieldsNotEmpty(empty_zgensym_965f3afadc761adf_4[:])
 
 	// map header
@@ -350,6 +380,42 @@ func (z *HashRBook) EncodeMsg(en *msgp.Writer) (err error) {
 		}
 	}
 
+	if !empty_zgensym_965f3afadc761adf_4[5] {
+		// write "formatVersion_zid06_int"
+		err = en.Append(0xb7, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x5f, 0x7a, 0x69, 0x64, 0x30, 0x36, 0x5f, 0x69, 0x6e, 0x74)
+		if err != nil {
+			return err
+		}
+		err = en.WriteInt(z.FormatVersion)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_4[6] {
+		// write "parentBookID_zid07_str"
+		err = en.Append(0xb6, 0x70, 0x61, 0x72, 0x65, 0x6e, 0x74, 0x42, 0x6f, 0x6f, 0x6b, 0x49, 0x44, 0x5f, 0x7a, 0x69, 0x64, 0x30, 0x37, 0x5f, 0x73, 0x74, 0x72)
+		if err != nil {
+			return err
+		}
+		err = en.WriteString(z.ParentBookID)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_4[7] {
+		// write "checksum_zid08_str"
+		err = en.Append(0xb2, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x5f, 0x7a, 0x69, 0x64, 0x30, 0x38, 0x5f, 0x73, 0x74, 0x72)
+		if err != nil {
+			return err
+		}
+		err = en.WriteString(z.Checksum)
+		if err != nil {
+			return
+		}
+	}
+
 	return
 }
 
@@ -362,7 +428,7 @@ func (z *HashRBook) MarshalMsg(b []byte) (o []byte, err error) {
 	o = msgp.Require(b, z.Msgsize())
 
 	// honor the omitempty tags
-	var empty [5]bool
+	var empty [8]bool
 	fieldsInUse := z.fieldsNotEmpty(empty[:])
 	o = msgp.AppendMapHeader(o, fieldsInUse)
 
@@ -396,6 +462,24 @@ func (z *HashRBook) MarshalMsg(b []byte) (o []byte, err error) {
 		o = msgp.AppendString(o, z.Path)
 	}
 
+	if !empty[5] {
+		// string "formatVersion_zid06_int"
+		o = append(o, 0xb7, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x5f, 0x7a, 0x69, 0x64, 0x30, 0x36, 0x5f, 0x69, 0x6e, 0x74)
+		o = msgp.AppendInt(o, z.FormatVersion)
+	}
+
+	if !empty[6] {
+		// string "parentBookID_zid07_str"
+		o = append(o, 0xb6, 0x70, 0x61, 0x72, 0x65, 0x6e, 0x74, 0x42, 0x6f, 0x6f, 0x6b, 0x49, 0x44, 0x5f, 0x7a, 0x69, 0x64, 0x30, 0x37, 0x5f, 0x73, 0x74, 0x72)
+		o = msgp.AppendString(o, z.ParentBookID)
+	}
+
+	if !empty[7] {
+		// string "checksum_zid08_str"
+		o = append(o, 0xb2, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x5f, 0x7a, 0x69, 0x64, 0x30, 0x38, 0x5f, 0x73, 0x74, 0x72)
+		o = msgp.AppendString(o, z.Checksum)
+	}
+
 	return
 }
 
@@ -414,7 +498,7 @@ func (z *HashRBook) UnmarshalMsgWithCfg(bts []byte, cfg *msgp.RuntimeConfig) (o
 
 	var field []byte
 	_ = field
-	const maxFields6zgensym_965f3afadc761adf_7 = 5
+	const maxFields6zgensym_965f3afadc761adf_7 = 8
 
 	// -- templateUnmarshalMsg starts here--
 	var totalEncodedFields6zgensym_965f3afadc761adf_7 uint32
@@ -496,6 +580,27 @@ doneWithStruct6zgensym_965f3afadc761adf_7:
 			found6zgensym_965f3afadc761adf_7[4] = true
 			z.Path, bts, err = nbs.ReadStringBytes(bts)
 
+			if err != nil {
+				return
+			}
+		case "formatVersion_zid06_int":
+			found6zgensym_965f3afadc761adf_7[5] = true
+			z.FormatVersion, bts, err = nbs.ReadIntBytes(bts)
+
+			if err != nil {
+				return
+			}
+		case "parentBookID_zid07_str":
+			found6zgensym_965f3afadc761adf_7[6] = true
+			z.ParentBookID, bts, err = nbs.ReadStringBytes(bts)
+
+			if err != nil {
+				return
+			}
+		case "checksum_zid08_str":
+			found6zgensym_965f3afadc761adf_7[7] = true
+			z.Checksum, bts, err = nbs.ReadStringBytes(bts)
+
 			if err != nil {
 				return
 			}
@@ -522,22 +627,25 @@ doneWithStruct6zgensym_965f3afadc761adf_7:
 }
 
 // fields of HashRBook
-var unmarshalMsgFieldOrder6zgensym_965f3afadc761adf_7 = []string{"createTm_zid00_tim", "bookID_zid01_str", "user_zid02_str", "host_zid03_str", "path_zid04_str"}
+var unmarshalMsgFieldOrder6zgensym_965f3afadc761adf_7 = []string{"createTm_zid00_tim", "bookID_zid01_str", "user_zid02_str", "host_zid03_str", "path_zid04_str", "formatVersion_zid06_int", "parentBookID_zid07_str", "checksum_zid08_str"}
 
-var unmarshalMsgFieldSkip6zgensym_965f3afadc761adf_7 = []bool{false, false, false, false, false}
+var unmarshalMsgFieldSkip6zgensym_965f3afadc761adf_7 = []bool{false, false, false, false, false, false, false, false}
 
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *HashRBook) Msgsize() (s int) {
-	s = 1 + 19 + msgp.TimeSize + 17 + msgp.StringPrefixSize + len(z.BookID) + 15 + msgp.StringPrefixSize + len(z.User) + 15 + msgp.StringPrefixSize + len(z.Host) + 15 + msgp.StringPrefixSize + len(z.Path)
+	s = 1 + 19 + msgp.TimeSize + 17 + msgp.StringPrefixSize + len(z.BookID) + 15 + msgp.StringPrefixSize + len(z.User) + 15 + msgp.StringPrefixSize + len(z.Host) + 15 + msgp.StringPrefixSize + len(z.Path) + 24 + msgp.IntSize + 23 + msgp.StringPrefixSize + len(z.ParentBookID) + 19 + msgp.StringPrefixSize + len(z.Checksum)
 	return
 }
 func (z *HashRBook) Gstring() (r string) {
 	r = "&HashRBook{\n"
-	r += fmt.Sprintf("CreateTm: %v,\n", z.CreateTm)
-	r += fmt.Sprintf("  BookID: \"%v\",\n", z.BookID)
-	r += fmt.Sprintf("    User: \"%v\",\n", z.User)
-	r += fmt.Sprintf("    Host: \"%v\",\n", z.Host)
-	r += fmt.Sprintf("    Path: \"%v\",\n", z.Path)
+	r += fmt.Sprintf("     CreateTm: %v,\n", z.CreateTm)
+	r += fmt.Sprintf("       BookID: \"%v\",\n", z.BookID)
+	r += fmt.Sprintf("         User: \"%v\",\n", z.User)
+	r += fmt.Sprintf("         Host: \"%v\",\n", z.Host)
+	r += fmt.Sprintf("         Path: \"%v\",\n", z.Path)
+	r += fmt.Sprintf("FormatVersion: %v,\n", z.FormatVersion)
+	r += fmt.Sprintf(" ParentBookID: \"%v\",\n", z.ParentBookID)
+	r += fmt.Sprintf("     Checksum: \"%v\",\n", z.Checksum)
 	r += "}\n"
 	return
 }
@@ -557,7 +665,7 @@ func (z *HashRElem) DecodeMsg(dc *msgp.Reader) (err error) {
 
 	var field []byte
 	_ = field
-	const maxFields8zgensym_965f3afadc761adf_9 = 16
+	const maxFields8zgensym_965f3afadc761adf_9 = 36
 
 	// -- templateDecodeMsg starts here--
 	var totalEncodedFields8zgensym_965f3afadc761adf_9 uint32
@@ -706,6 +814,126 @@ doneWithStruct8zgensym_965f3afadc761adf_9:
 			if err != nil {
 				return
 			}
+		case "checksum_zid16_str":
+			found8zgensym_965f3afadc761adf_9[16] = true
+			z.Checksum, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "prevHash_zid17_str":
+			found8zgensym_965f3afadc761adf_9[17] = true
+			z.PrevHash, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "imageHash_zid18_str":
+			found8zgensym_965f3afadc761adf_9[18] = true
+			z.ImageHash, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "sourceHost_zid19_str":
+			found8zgensym_965f3afadc761adf_9[19] = true
+			z.SourceHost, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "sourceBookID_zid20_str":
+			found8zgensym_965f3afadc761adf_9[20] = true
+			z.SourceBookID, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "errorJSON_zid21_str":
+			found8zgensym_965f3afadc761adf_9[21] = true
+			z.ErrorJSON, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "wallNs_zid22_i64":
+			found8zgensym_965f3afadc761adf_9[22] = true
+			z.WallNs, err = dc.ReadInt64()
+			if err != nil {
+				return
+			}
+		case "cpuNs_zid23_i64":
+			found8zgensym_965f3afadc761adf_9[23] = true
+			z.CPUNs, err = dc.ReadInt64()
+			if err != nil {
+				return
+			}
+		case "rHeapBefore_zid24_i64":
+			found8zgensym_965f3afadc761adf_9[24] = true
+			z.RHeapBefore, err = dc.ReadInt64()
+			if err != nil {
+				return
+			}
+		case "rHeapAfter_zid25_i64":
+			found8zgensym_965f3afadc761adf_9[25] = true
+			z.RHeapAfter, err = dc.ReadInt64()
+			if err != nil {
+				return
+			}
+		case "rHeapPeak_zid26_i64":
+			found8zgensym_965f3afadc761adf_9[26] = true
+			z.RHeapPeak, err = dc.ReadInt64()
+			if err != nil {
+				return
+			}
+		case "maxRSS_zid27_i64":
+			found8zgensym_965f3afadc761adf_9[27] = true
+			z.MaxRSS, err = dc.ReadInt64()
+			if err != nil {
+				return
+			}
+		case "imageMIME_zid28_str":
+			found8zgensym_965f3afadc761adf_9[28] = true
+			z.ImageMIME, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "imageSVG_zid29_bin":
+			found8zgensym_965f3afadc761adf_9[29] = true
+			z.ImageSVG, err = dc.ReadBytes(z.ImageSVG)
+			if err != nil {
+				return
+			}
+		case "imagePDF_zid30_bin":
+			found8zgensym_965f3afadc761adf_9[30] = true
+			z.ImagePDF, err = dc.ReadBytes(z.ImagePDF)
+			if err != nil {
+				return
+			}
+		case "plotRDS_zid31_bin":
+			found8zgensym_965f3afadc761adf_9[31] = true
+			z.PlotRDS, err = dc.ReadBytes(z.PlotRDS)
+			if err != nil {
+				return
+			}
+		case "tableJSON_zid32_str":
+			found8zgensym_965f3afadc761adf_9[32] = true
+			z.TableJSON, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "widgetJSON_zid33_str":
+			found8zgensym_965f3afadc761adf_9[33] = true
+			z.WidgetJSON, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "widgetHTML_zid34_bin":
+			found8zgensym_965f3afadc761adf_9[34] = true
+			z.WidgetHTML, err = dc.ReadBytes(z.WidgetHTML)
+			if err != nil {
+				return
+			}
+		case "widgetHash_zid35_str":
+			found8zgensym_965f3afadc761adf_9[35] = true
+			z.WidgetHash, err = dc.ReadString()
+			if err != nil {
+				return
+			}
 		default:
 			err = dc.Skip()
 			if err != nil {
@@ -729,16 +957,16 @@ doneWithStruct8zgensym_965f3afadc761adf_9:
 }
 
 // fields of HashRElem
-var decodeMsgFieldOrder8zgensym_965f3afadc761adf_9 = []string{"type_zid00_rct", "tm_zid01_tim", "seqno_zid02_int", "cmdJSON_zid03_str", "consoleJSON_zid04_str", "imageJSON_zid05_str", "commentJSON_zid06_str", "imageHost_zid07_str", "imagePath_zid08_str", "imageBy_zid09_bin", "imagePathHash_zid10_str", "beginCommandLineNum_zid11_int", "numCommandLines_zid12_int", "overlayNote_zid13_str", "overlayHideSeqno_zid14_int", "overlayHideSeqnoJSON_zid15_str"}
+var decodeMsgFieldOrder8zgensym_965f3afadc761adf_9 = []string{"type_zid00_rct", "tm_zid01_tim", "seqno_zid02_int", "cmdJSON_zid03_str", "consoleJSON_zid04_str", "imageJSON_zid05_str", "commentJSON_zid06_str", "imageHost_zid07_str", "imagePath_zid08_str", "imageBy_zid09_bin", "imagePathHash_zid10_str", "beginCommandLineNum_zid11_int", "numCommandLines_zid12_int", "overlayNote_zid13_str", "overlayHideSeqno_zid14_int", "overlayHideSeqnoJSON_zid15_str", "checksum_zid16_str", "prevHash_zid17_str", "imageHash_zid18_str", "sourceHost_zid19_str", "sourceBookID_zid20_str", "errorJSON_zid21_str", "wallNs_zid22_i64", "cpuNs_zid23_i64", "rHeapBefore_zid24_i64", "rHeapAfter_zid25_i64", "rHeapPeak_zid26_i64", "maxRSS_zid27_i64", "imageMIME_zid28_str", "imageSVG_zid29_bin", "imagePDF_zid30_bin", "plotRDS_zid31_bin", "tableJSON_zid32_str", "widgetJSON_zid33_str", "widgetHTML_zid34_bin", "widgetHash_zid35_str"}
 
-var decodeMsgFieldSkip8zgensym_965f3afadc761adf_9 = []bool{false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false}
+var decodeMsgFieldSkip8zgensym_965f3afadc761adf_9 = []bool{false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false}
 
 // fieldsNotEmpty supports omitempty tags
 func (z *HashRElem) fieldsNotEmpty(isempty []bool) uint32 {
 	if len(isempty) == 0 {
-		return 16
+		return 36
 	}
-	var fieldsInUse uint32 = 16
+	var fieldsInUse uint32 = 36
 	isempty[0] = (z.Typ == 0) // number, omitempty
 	if isempty[0] {
 		fieldsInUse--
@@ -803,6 +1031,86 @@ func (z *HashRElem) fieldsNotEmpty(isempty []bool) uint32 {
 	if isempty[15] {
 		fieldsInUse--
 	}
+	isempty[16] = (len(z.Checksum) == 0) // string, omitempty
+	if isempty[16] {
+		fieldsInUse--
+	}
+	isempty[17] = (len(z.PrevHash) == 0) // string, omitempty
+	if isempty[17] {
+		fieldsInUse--
+	}
+	isempty[18] = (len(z.ImageHash) == 0) // string, omitempty
+	if isempty[18] {
+		fieldsInUse--
+	}
+	isempty[19] = (len(z.SourceHost) == 0) // string, omitempty
+	if isempty[19] {
+		fieldsInUse--
+	}
+	isempty[20] = (len(z.SourceBookID) == 0) // string, omitempty
+	if isempty[20] {
+		fieldsInUse--
+	}
+	isempty[21] = (len(z.ErrorJSON) == 0) // string, omitempty
+	if isempty[21] {
+		fieldsInUse--
+	}
+	isempty[22] = (z.WallNs == 0) // number, omitempty
+	if isempty[22] {
+		fieldsInUse--
+	}
+	isempty[23] = (z.CPUNs == 0) // number, omitempty
+	if isempty[23] {
+		fieldsInUse--
+	}
+	isempty[24] = (z.RHeapBefore == 0) // number, omitempty
+	if isempty[24] {
+		fieldsInUse--
+	}
+	isempty[25] = (z.RHeapAfter == 0) // number, omitempty
+	if isempty[25] {
+		fieldsInUse--
+	}
+	isempty[26] = (z.RHeapPeak == 0) // number, omitempty
+	if isempty[26] {
+		fieldsInUse--
+	}
+	isempty[27] = (z.MaxRSS == 0) // number, omitempty
+	if isempty[27] {
+		fieldsInUse--
+	}
+	isempty[28] = (len(z.ImageMIME) == 0) // string, omitempty
+	if isempty[28] {
+		fieldsInUse--
+	}
+	isempty[29] = (len(z.ImageSVG) == 0) // string, omitempty
+	if isempty[29] {
+		fieldsInUse--
+	}
+	isempty[30] = (len(z.ImagePDF) == 0) // string, omitempty
+	if isempty[30] {
+		fieldsInUse--
+	}
+	isempty[31] = (len(z.PlotRDS) == 0) // string, omitempty
+	if isempty[31] {
+		fieldsInUse--
+	}
+	isempty[32] = (len(z.TableJSON) == 0) // string, omitempty
+	if isempty[32] {
+		fieldsInUse--
+	}
+	isempty[33] = (len(z.WidgetJSON) == 0) // string, omitempty
+	if isempty[33] {
+		fieldsInUse--
+	}
+	isempty[34] = (len(z.WidgetHTML) == 0) // string, omitempty
+	if isempty[34] {
+		fieldsInUse--
+	}
+	isempty[35] = (len(z.WidgetHash) == 0) // string, omitempty
+	if isempty[35] {
+		fieldsInUse--
+	}
 
 	return fieldsInUse
 }
@@ -814,7 +1122,7 @@ func (z *HashRElem) EncodeMsg(en *msgp.Writer) (err error) {
 	}
 
 	// honor the omitempty tags
-	var empty_zgensym_965f3afadc761adf_11 [16]bool
+	var empty_zgensym_965f3afadc761adf_11 [36]bool
 	fieldsInUse_zgensym_965f3afadc761adf_12 := z.fieldsNotEmpty(empty_zgensym_965f3afadc761adf_11[:])
 
 	// map header
@@ -1025,6 +1333,246 @@ func (z *HashRElem) EncodeMsg(en *msgp.Writer) (err error) {
 		}
 	}
 
+	if !empty_zgensym_965f3afadc761adf_11[16] {
+		// write "checksum_zid16_str"
+		err = en.Append(0xb2, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x5f, 0x7a, 0x69, 0x64, 0x31, 0x36, 0x5f, 0x73, 0x74, 0x72)
+		if err != nil {
+			return err
+		}
+		err = en.WriteString(z.Checksum)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_11[17] {
+		// write "prevHash_zid17_str"
+		err = en.Append(0xb2, 0x70, 0x72, 0x65, 0x76, 0x48, 0x61, 0x73, 0x68, 0x5f, 0x7a, 0x69, 0x64, 0x31, 0x37, 0x5f, 0x73, 0x74, 0x72)
+		if err != nil {
+			return err
+		}
+		err = en.WriteString(z.PrevHash)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_11[18] {
+		// write "imageHash_zid18_str"
+		err = en.Append(0xb3, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x48, 0x61, 0x73, 0x68, 0x5f, 0x7a, 0x69, 0x64, 0x31, 0x38, 0x5f, 0x73, 0x74, 0x72)
+		if err != nil {
+			return err
+		}
+		err = en.WriteString(z.ImageHash)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_11[19] {
+		// write "sourceHost_zid19_str"
+		err = en.Append(0xb4, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x48, 0x6f, 0x73, 0x74, 0x5f, 0x7a, 0x69, 0x64, 0x31, 0x39, 0x5f, 0x73, 0x74, 0x72)
+		if err != nil {
+			return err
+		}
+		err = en.WriteString(z.SourceHost)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_11[20] {
+		// write "sourceBookID_zid20_str"
+		err = en.Append(0xb6, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x42, 0x6f, 0x6f, 0x6b, 0x49, 0x44, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x30, 0x5f, 0x73, 0x74, 0x72)
+		if err != nil {
+			return err
+		}
+		err = en.WriteString(z.SourceBookID)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_11[21] {
+		// write "errorJSON_zid21_str"
+		err = en.Append(0xb3, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x4a, 0x53, 0x4f, 0x4e, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x31, 0x5f, 0x73, 0x74, 0x72)
+		if err != nil {
+			return err
+		}
+		err = en.WriteString(z.ErrorJSON)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_11[22] {
+		// write "wallNs_zid22_i64"
+		err = en.Append(0xb0, 0x77, 0x61, 0x6c, 0x6c, 0x4e, 0x73, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x32, 0x5f, 0x69, 0x36, 0x34)
+		if err != nil {
+			return err
+		}
+		err = en.WriteInt64(z.WallNs)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_11[23] {
+		// write "cpuNs_zid23_i64"
+		err = en.Append(0xaf, 0x63, 0x70, 0x75, 0x4e, 0x73, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x33, 0x5f, 0x69, 0x36, 0x34)
+		if err != nil {
+			return err
+		}
+		err = en.WriteInt64(z.CPUNs)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_11[24] {
+		// write "rHeapBefore_zid24_i64"
+		err = en.Append(0xb5, 0x72, 0x48, 0x65, 0x61, 0x70, 0x42, 0x65, 0x66, 0x6f, 0x72, 0x65, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x34, 0x5f, 0x69, 0x36, 0x34)
+		if err != nil {
+			return err
+		}
+		err = en.WriteInt64(z.RHeapBefore)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_11[25] {
+		// write "rHeapAfter_zid25_i64"
+		err = en.Append(0xb4, 0x72, 0x48, 0x65, 0x61, 0x70, 0x41, 0x66, 0x74, 0x65, 0x72, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x35, 0x5f, 0x69, 0x36, 0x34)
+		if err != nil {
+			return err
+		}
+		err = en.WriteInt64(z.RHeapAfter)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_11[26] {
+		// write "rHeapPeak_zid26_i64"
+		err = en.Append(0xb3, 0x72, 0x48, 0x65, 0x61, 0x70, 0x50, 0x65, 0x61, 0x6b, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x36, 0x5f, 0x69, 0x36, 0x34)
+		if err != nil {
+			return err
+		}
+		err = en.WriteInt64(z.RHeapPeak)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_11[27] {
+		// write "maxRSS_zid27_i64"
+		err = en.Append(0xb0, 0x6d, 0x61, 0x78, 0x52, 0x53, 0x53, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x37, 0x5f, 0x69, 0x36, 0x34)
+		if err != nil {
+			return err
+		}
+		err = en.WriteInt64(z.MaxRSS)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_11[28] {
+		// write "imageMIME_zid28_str"
+		err = en.Append(0xb3, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x4d, 0x49, 0x4d, 0x45, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x38, 0x5f, 0x73, 0x74, 0x72)
+		if err != nil {
+			return err
+		}
+		err = en.WriteString(z.ImageMIME)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_11[29] {
+		// write "imageSVG_zid29_bin"
+		err = en.Append(0xb2, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x53, 0x56, 0x47, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x39, 0x5f, 0x62, 0x69, 0x6e)
+		if err != nil {
+			return err
+		}
+		err = en.WriteBytes(z.ImageSVG)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_11[30] {
+		// write "imagePDF_zid30_bin"
+		err = en.Append(0xb2, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x50, 0x44, 0x46, 0x5f, 0x7a, 0x69, 0x64, 0x33, 0x30, 0x5f, 0x62, 0x69, 0x6e)
+		if err != nil {
+			return err
+		}
+		err = en.WriteBytes(z.ImagePDF)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_11[31] {
+		// write "plotRDS_zid31_bin"
+		err = en.Append(0xb1, 0x70, 0x6c, 0x6f, 0x74, 0x52, 0x44, 0x53, 0x5f, 0x7a, 0x69, 0x64, 0x33, 0x31, 0x5f, 0x62, 0x69, 0x6e)
+		if err != nil {
+			return err
+		}
+		err = en.WriteBytes(z.PlotRDS)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_11[32] {
+		// write "tableJSON_zid32_str"
+		err = en.Append(0xb3, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x4a, 0x53, 0x4f, 0x4e, 0x5f, 0x7a, 0x69, 0x64, 0x33, 0x32, 0x5f, 0x73, 0x74, 0x72)
+		if err != nil {
+			return err
+		}
+		err = en.WriteString(z.TableJSON)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_11[33] {
+		// write "widgetJSON_zid33_str"
+		err = en.Append(0xb4, 0x77, 0x69, 0x64, 0x67, 0x65, 0x74, 0x4a, 0x53, 0x4f, 0x4e, 0x5f, 0x7a, 0x69, 0x64, 0x33, 0x33, 0x5f, 0x73, 0x74, 0x72)
+		if err != nil {
+			return err
+		}
+		err = en.WriteString(z.WidgetJSON)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_11[34] {
+		// write "widgetHTML_zid34_bin"
+		err = en.Append(0xb4, 0x77, 0x69, 0x64, 0x67, 0x65, 0x74, 0x48, 0x54, 0x4d, 0x4c, 0x5f, 0x7a, 0x69, 0x64, 0x33, 0x34, 0x5f, 0x62, 0x69, 0x6e)
+		if err != nil {
+			return err
+		}
+		err = en.WriteBytes(z.WidgetHTML)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_965f3afadc761adf_11[35] {
+		// write "widgetHash_zid35_str"
+		err = en.Append(0xb4, 0x77, 0x69, 0x64, 0x67, 0x65, 0x74, 0x48, 0x61, 0x73, 0x68, 0x5f, 0x7a, 0x69, 0x64, 0x33, 0x35, 0x5f, 0x73, 0x74, 0x72)
+		if err != nil {
+			return err
+		}
+		err = en.WriteString(z.WidgetHash)
+		if err != nil {
+			return
+		}
+	}
+
 	return
 }
 
@@ -1037,7 +1585,7 @@ func (z *HashRElem) MarshalMsg(b []byte) (o []byte, err error) {
 	o = msgp.Require(b, z.Msgsize())
 
 	// honor the omitempty tags
-	var empty [16]bool
+	var empty [36]bool
 	fieldsInUse := z.fieldsNotEmpty(empty[:])
 	o = msgp.AppendMapHeader(o, fieldsInUse)
 
@@ -1137,6 +1685,126 @@ func (z *HashRElem) MarshalMsg(b []byte) (o []byte, err error) {
 		o = msgp.AppendString(o, z.OverlayHideSeqnoJSON)
 	}
 
+	if !empty[16] {
+		// string "checksum_zid16_str"
+		o = append(o, 0xb2, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x5f, 0x7a, 0x69, 0x64, 0x31, 0x36, 0x5f, 0x73, 0x74, 0x72)
+		o = msgp.AppendString(o, z.Checksum)
+	}
+
+	if !empty[17] {
+		// string "prevHash_zid17_str"
+		o = append(o, 0xb2, 0x70, 0x72, 0x65, 0x76, 0x48, 0x61, 0x73, 0x68, 0x5f, 0x7a, 0x69, 0x64, 0x31, 0x37, 0x5f, 0x73, 0x74, 0x72)
+		o = msgp.AppendString(o, z.PrevHash)
+	}
+
+	if !empty[18] {
+		// string "imageHash_zid18_str"
+		o = append(o, 0xb3, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x48, 0x61, 0x73, 0x68, 0x5f, 0x7a, 0x69, 0x64, 0x31, 0x38, 0x5f, 0x73, 0x74, 0x72)
+		o = msgp.AppendString(o, z.ImageHash)
+	}
+
+	if !empty[19] {
+		// string "sourceHost_zid19_str"
+		o = append(o, 0xb4, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x48, 0x6f, 0x73, 0x74, 0x5f, 0x7a, 0x69, 0x64, 0x31, 0x39, 0x5f, 0x73, 0x74, 0x72)
+		o = msgp.AppendString(o, z.SourceHost)
+	}
+
+	if !empty[20] {
+		// string "sourceBookID_zid20_str"
+		o = append(o, 0xb6, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x42, 0x6f, 0x6f, 0x6b, 0x49, 0x44, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x30, 0x5f, 0x73, 0x74, 0x72)
+		o = msgp.AppendString(o, z.SourceBookID)
+	}
+
+	if !empty[21] {
+		// string "errorJSON_zid21_str"
+		o = append(o, 0xb3, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x4a, 0x53, 0x4f, 0x4e, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x31, 0x5f, 0x73, 0x74, 0x72)
+		o = msgp.AppendString(o, z.ErrorJSON)
+	}
+
+	if !empty[22] {
+		// string "wallNs_zid22_i64"
+		o = append(o, 0xb0, 0x77, 0x61, 0x6c, 0x6c, 0x4e, 0x73, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x32, 0x5f, 0x69, 0x36, 0x34)
+		o = msgp.AppendInt64(o, z.WallNs)
+	}
+
+	if !empty[23] {
+		// string "cpuNs_zid23_i64"
+		o = append(o, 0xaf, 0x63, 0x70, 0x75, 0x4e, 0x73, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x33, 0x5f, 0x69, 0x36, 0x34)
+		o = msgp.AppendInt64(o, z.CPUNs)
+	}
+
+	if !empty[24] {
+		// string "rHeapBefore_zid24_i64"
+		o = append(o, 0xb5, 0x72, 0x48, 0x65, 0x61, 0x70, 0x42, 0x65, 0x66, 0x6f, 0x72, 0x65, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x34, 0x5f, 0x69, 0x36, 0x34)
+		o = msgp.AppendInt64(o, z.RHeapBefore)
+	}
+
+	if !empty[25] {
+		// string "rHeapAfter_zid25_i64"
+		o = append(o, 0xb4, 0x72, 0x48, 0x65, 0x61, 0x70, 0x41, 0x66, 0x74, 0x65, 0x72, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x35, 0x5f, 0x69, 0x36, 0x34)
+		o = msgp.AppendInt64(o, z.RHeapAfter)
+	}
+
+	if !empty[26] {
+		// string "rHeapPeak_zid26_i64"
+		o = append(o, 0xb3, 0x72, 0x48, 0x65, 0x61, 0x70, 0x50, 0x65, 0x61, 0x6b, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x36, 0x5f, 0x69, 0x36, 0x34)
+		o = msgp.AppendInt64(o, z.RHeapPeak)
+	}
+
+	if !empty[27] {
+		// string "maxRSS_zid27_i64"
+		o = append(o, 0xb0, 0x6d, 0x61, 0x78, 0x52, 0x53, 0x53, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x37, 0x5f, 0x69, 0x36, 0x34)
+		o = msgp.AppendInt64(o, z.MaxRSS)
+	}
+
+	if !empty[28] {
+		// string "imageMIME_zid28_str"
+		o = append(o, 0xb3, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x4d, 0x49, 0x4d, 0x45, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x38, 0x5f, 0x73, 0x74, 0x72)
+		o = msgp.AppendString(o, z.ImageMIME)
+	}
+
+	if !empty[29] {
+		// string "imageSVG_zid29_bin"
+		o = append(o, 0xb2, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x53, 0x56, 0x47, 0x5f, 0x7a, 0x69, 0x64, 0x32, 0x39, 0x5f, 0x62, 0x69, 0x6e)
+		o = msgp.AppendBytes(o, z.ImageSVG)
+	}
+
+	if !empty[30] {
+		// string "imagePDF_zid30_bin"
+		o = append(o, 0xb2, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x50, 0x44, 0x46, 0x5f, 0x7a, 0x69, 0x64, 0x33, 0x30, 0x5f, 0x62, 0x69, 0x6e)
+		o = msgp.AppendBytes(o, z.ImagePDF)
+	}
+
+	if !empty[31] {
+		// string "plotRDS_zid31_bin"
+		o = append(o, 0xb1, 0x70, 0x6c, 0x6f, 0x74, 0x52, 0x44, 0x53, 0x5f, 0x7a, 0x69, 0x64, 0x33, 0x31, 0x5f, 0x62, 0x69, 0x6e)
+		o = msgp.AppendBytes(o, z.PlotRDS)
+	}
+
+	if !empty[32] {
+		// string "tableJSON_zid32_str"
+		o = append(o, 0xb3, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x4a, 0x53, 0x4f, 0x4e, 0x5f, 0x7a, 0x69, 0x64, 0x33, 0x32, 0x5f, 0x73, 0x74, 0x72)
+		o = msgp.AppendString(o, z.TableJSON)
+	}
+
+	if !empty[33] {
+		// string "widgetJSON_zid33_str"
+		o = append(o, 0xb4, 0x77, 0x69, 0x64, 0x67, 0x65, 0x74, 0x4a, 0x53, 0x4f, 0x4e, 0x5f, 0x7a, 0x69, 0x64, 0x33, 0x33, 0x5f, 0x73, 0x74, 0x72)
+		o = msgp.AppendString(o, z.WidgetJSON)
+	}
+
+	if !empty[34] {
+		// string "widgetHTML_zid34_bin"
+		o = append(o, 0xb4, 0x77, 0x69, 0x64, 0x67, 0x65, 0x74, 0x48, 0x54, 0x4d, 0x4c, 0x5f, 0x7a, 0x69, 0x64, 0x33, 0x34, 0x5f, 0x62, 0x69, 0x6e)
+		o = msgp.AppendBytes(o, z.WidgetHTML)
+	}
+
+	if !empty[35] {
+		// string "widgetHash_zid35_str"
+		o = append(o, 0xb4, 0x77, 0x69, 0x64, 0x67, 0x65, 0x74, 0x48, 0x61, 0x73, 0x68, 0x5f, 0x7a, 0x69, 0x64, 0x33, 0x35, 0x5f, 0x73, 0x74, 0x72)
+		o = msgp.AppendString(o, z.WidgetHash)
+	}
+
 	return
 }
 
@@ -1155,7 +1823,7 @@ func (z *HashRElem) UnmarshalMsgWithCfg(bts []byte, cfg *msgp.RuntimeConfig) (o
 
 	var field []byte
 	_ = field
-	const maxFields13zgensym_965f3afadc761adf_14 = 16
+	const maxFields13zgensym_965f3afadc761adf_14 = 36
 
 	// -- templateUnmarshalMsg starts here--
 	var totalEncodedFields13zgensym_965f3afadc761adf_14 uint32
@@ -1328,6 +1996,186 @@ doneWithStruct13zgensym_965f3afadc761adf_14:
 			found13zgensym_965f3afadc761adf_14[15] = true
 			z.OverlayHideSeqnoJSON, bts, err = nbs.ReadStringBytes(bts)
 
+			if err != nil {
+				return
+			}
+		case "checksum_zid16_str":
+			found13zgensym_965f3afadc761adf_14[16] = true
+			z.Checksum, bts, err = nbs.ReadStringBytes(bts)
+
+			if err != nil {
+				return
+			}
+		case "prevHash_zid17_str":
+			found13zgensym_965f3afadc761adf_14[17] = true
+			z.PrevHash, bts, err = nbs.ReadStringBytes(bts)
+
+			if err != nil {
+				return
+			}
+		case "imageHash_zid18_str":
+			found13zgensym_965f3afadc761adf_14[18] = true
+			z.ImageHash, bts, err = nbs.ReadStringBytes(bts)
+
+			if err != nil {
+				return
+			}
+		case "sourceHost_zid19_str":
+			found13zgensym_965f3afadc761adf_14[19] = true
+			z.SourceHost, bts, err = nbs.ReadStringBytes(bts)
+
+			if err != nil {
+				return
+			}
+		case "sourceBookID_zid20_str":
+			found13zgensym_965f3afadc761adf_14[20] = true
+			z.SourceBookID, bts, err = nbs.ReadStringBytes(bts)
+
+			if err != nil {
+				return
+			}
+		case "errorJSON_zid21_str":
+			found13zgensym_965f3afadc761adf_14[21] = true
+			z.ErrorJSON, bts, err = nbs.ReadStringBytes(bts)
+
+			if err != nil {
+				return
+			}
+		case "wallNs_zid22_i64":
+			found13zgensym_965f3afadc761adf_14[22] = true
+			z.WallNs, bts, err = nbs.ReadInt64Bytes(bts)
+
+			if err != nil {
+				return
+			}
+		case "cpuNs_zid23_i64":
+			found13zgensym_965f3afadc761adf_14[23] = true
+			z.CPUNs, bts, err = nbs.ReadInt64Bytes(bts)
+
+			if err != nil {
+				return
+			}
+		case "rHeapBefore_zid24_i64":
+			found13zgensym_965f3afadc761adf_14[24] = true
+			z.RHeapBefore, bts, err = nbs.ReadInt64Bytes(bts)
+
+			if err != nil {
+				return
+			}
+		case "rHeapAfter_zid25_i64":
+			found13zgensym_965f3afadc761adf_14[25] = true
+			z.RHeapAfter, bts, err = nbs.ReadInt64Bytes(bts)
+
+			if err != nil {
+				return
+			}
+		case "rHeapPeak_zid26_i64":
+			found13zgensym_965f3afadc761adf_14[26] = true
+			z.RHeapPeak, bts, err = nbs.ReadInt64Bytes(bts)
+
+			if err != nil {
+				return
+			}
+		case "maxRSS_zid27_i64":
+			found13zgensym_965f3afadc761adf_14[27] = true
+			z.MaxRSS, bts, err = nbs.ReadInt64Bytes(bts)
+
+			if err != nil {
+				return
+			}
+		case "imageMIME_zid28_str":
+			found13zgensym_965f3afadc761adf_14[28] = true
+			z.ImageMIME, bts, err = nbs.ReadStringBytes(bts)
+
+			if err != nil {
+				return
+			}
+		case "imageSVG_zid29_bin":
+			found13zgensym_965f3afadc761adf_14[29] = true
+			if nbs.AlwaysNil || msgp.IsNil(bts) {
+				if !nbs.AlwaysNil {
+					bts = bts[1:]
+				}
+				z.ImageSVG = z.ImageSVG[:0]
+			} else {
+				z.ImageSVG, bts, err = nbs.ReadBytesBytes(bts, z.ImageSVG)
+
+				if err != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		case "imagePDF_zid30_bin":
+			found13zgensym_965f3afadc761adf_14[30] = true
+			if nbs.AlwaysNil || msgp.IsNil(bts) {
+				if !nbs.AlwaysNil {
+					bts = bts[1:]
+				}
+				z.ImagePDF = z.ImagePDF[:0]
+			} else {
+				z.ImagePDF, bts, err = nbs.ReadBytesBytes(bts, z.ImagePDF)
+
+				if err != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		case "plotRDS_zid31_bin":
+			found13zgensym_965f3afadc761adf_14[31] = true
+			if nbs.AlwaysNil || msgp.IsNil(bts) {
+				if !nbs.AlwaysNil {
+					bts = bts[1:]
+				}
+				z.PlotRDS = z.PlotRDS[:0]
+			} else {
+				z.PlotRDS, bts, err = nbs.ReadBytesBytes(bts, z.PlotRDS)
+
+				if err != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		case "tableJSON_zid32_str":
+			found13zgensym_965f3afadc761adf_14[32] = true
+			z.TableJSON, bts, err = nbs.ReadStringBytes(bts)
+
+			if err != nil {
+				return
+			}
+		case "widgetJSON_zid33_str":
+			found13zgensym_965f3afadc761adf_14[33] = true
+			z.WidgetJSON, bts, err = nbs.ReadStringBytes(bts)
+
+			if err != nil {
+				return
+			}
+		case "widgetHTML_zid34_bin":
+			found13zgensym_965f3afadc761adf_14[34] = true
+			if nbs.AlwaysNil || msgp.IsNil(bts) {
+				if !nbs.AlwaysNil {
+					bts = bts[1:]
+				}
+				z.WidgetHTML = z.WidgetHTML[:0]
+			} else {
+				z.WidgetHTML, bts, err = nbs.ReadBytesBytes(bts, z.WidgetHTML)
+
+				if err != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		case "widgetHash_zid35_str":
+			found13zgensym_965f3afadc761adf_14[35] = true
+			z.WidgetHash, bts, err = nbs.ReadStringBytes(bts)
+
 			if err != nil {
 				return
 			}
@@ -1354,13 +2202,13 @@ doneWithStruct13zgensym_965f3afadc761adf_14:
 }
 
 // fields of HashRElem
-var unmarshalMsgFieldOrder13zgensym_965f3afadc761adf_14 = []string{"type_zid00_rct", "tm_zid01_tim", "seqno_zid02_int", "cmdJSON_zid03_str", "consoleJSON_zid04_str", "imageJSON_zid05_str", "commentJSON_zid06_str", "imageHost_zid07_str", "imagePath_zid08_str", "imageBy_zid09_bin", "imagePathHash_zid10_str", "beginCommandLineNum_zid11_int", "numCommandLines_zid12_int", "overlayNote_zid13_str", "overlayHideSeqno_zid14_int", "overlayHideSeqnoJSON_zid15_str"}
+var unmarshalMsgFieldOrder13zgensym_965f3afadc761adf_14 = []string{"type_zid00_rct", "tm_zid01_tim", "seqno_zid02_int", "cmdJSON_zid03_str", "consoleJSON_zid04_str", "imageJSON_zid05_str", "commentJSON_zid06_str", "imageHost_zid07_str", "imagePath_zid08_str", "imageBy_zid09_bin", "imagePathHash_zid10_str", "beginCommandLineNum_zid11_int", "numCommandLines_zid12_int", "overlayNote_zid13_str", "overlayHideSeqno_zid14_int", "overlayHideSeqnoJSON_zid15_str", "checksum_zid16_str", "prevHash_zid17_str", "imageHash_zid18_str", "sourceHost_zid19_str", "sourceBookID_zid20_str", "errorJSON_zid21_str", "wallNs_zid22_i64", "cpuNs_zid23_i64", "rHeapBefore_zid24_i64", "rHeapAfter_zid25_i64", "rHeapPeak_zid26_i64", "maxRSS_zid27_i64", "imageMIME_zid28_str", "imageSVG_zid29_bin", "imagePDF_zid30_bin", "plotRDS_zid31_bin", "tableJSON_zid32_str", "widgetJSON_zid33_str", "widgetHTML_zid34_bin", "widgetHash_zid35_str"}
 
-var unmarshalMsgFieldSkip13zgensym_965f3afadc761adf_14 = []bool{false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false}
+var unmarshalMsgFieldSkip13zgensym_965f3afadc761adf_14 = []bool{false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false, false}
 
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *HashRElem) Msgsize() (s int) {
-	s = 3 + 15 + msgp.IntSize + 13 + msgp.TimeSize + 16 + msgp.IntSize + 18 + msgp.StringPrefixSize + len(z.CmdJSON) + 22 + msgp.StringPrefixSize + len(z.ConsoleJSON) + 20 + msgp.StringPrefixSize + len(z.ImageJSON) + 22 + msgp.StringPrefixSize + len(z.CommentJSON) + 20 + msgp.StringPrefixSize + len(z.ImageHost) + 20 + msgp.StringPrefixSize + len(z.ImagePath) + 18 + msgp.BytesPrefixSize + len(z.ImageBy) + 24 + msgp.StringPrefixSize + len(z.ImagePathHash) + 30 + msgp.IntSize + 26 + msgp.IntSize + 22 + msgp.StringPrefixSize + len(z.OverlayNoteJSON) + 27 + msgp.IntSize + 31 + msgp.StringPrefixSize + len(z.OverlayHideSeqnoJSON)
+	s = 3 + 15 + msgp.IntSize + 13 + msgp.TimeSize + 16 + msgp.IntSize + 18 + msgp.StringPrefixSize + len(z.CmdJSON) + 22 + msgp.StringPrefixSize + len(z.ConsoleJSON) + 20 + msgp.StringPrefixSize + len(z.ImageJSON) + 22 + msgp.StringPrefixSize + len(z.CommentJSON) + 20 + msgp.StringPrefixSize + len(z.ImageHost) + 20 + msgp.StringPrefixSize + len(z.ImagePath) + 18 + msgp.BytesPrefixSize + len(z.ImageBy) + 24 + msgp.StringPrefixSize + len(z.ImagePathHash) + 30 + msgp.IntSize + 26 + msgp.IntSize + 22 + msgp.StringPrefixSize + len(z.OverlayNoteJSON) + 27 + msgp.IntSize + 31 + msgp.StringPrefixSize + len(z.OverlayHideSeqnoJSON) + 19 + msgp.StringPrefixSize + len(z.Checksum) + 19 + msgp.StringPrefixSize + len(z.PrevHash) + 20 + msgp.StringPrefixSize + len(z.ImageHash) + 21 + msgp.StringPrefixSize + len(z.SourceHost) + 23 + msgp.StringPrefixSize + len(z.SourceBookID) + 20 + msgp.StringPrefixSize + len(z.ErrorJSON) + 17 + msgp.Int64Size + 16 + msgp.Int64Size + 22 + msgp.Int64Size + 21 + msgp.Int64Size + 20 + msgp.Int64Size + 17 + msgp.Int64Size + 20 + msgp.StringPrefixSize + len(z.ImageMIME) + 19 + msgp.BytesPrefixSize + len(z.ImageSVG) + 19 + msgp.BytesPrefixSize + len(z.ImagePDF) + 18 + msgp.BytesPrefixSize + len(z.PlotRDS) + 20 + msgp.StringPrefixSize + len(z.TableJSON) + 21 + msgp.StringPrefixSize + len(z.WidgetJSON) + 21 + msgp.BytesPrefixSize + len(z.WidgetHTML) + 21 + msgp.StringPrefixSize + len(z.WidgetHash)
 	return
 }
 func (z *HashRElem) Gstring() (r string) {
@@ -1381,6 +2229,26 @@ func (z *HashRElem) Gstring() (r string) {
 	r += fmt.Sprintf("     OverlayNoteJSON: \"%v\",\n", z.OverlayNoteJSON)
 	r += fmt.Sprintf("    OverlayHideSeqno: %v,\n", z.OverlayHideSeqno)
 	r += fmt.Sprintf("OverlayHideSeqnoJSON: \"%v\",\n", z.OverlayHideSeqnoJSON)
+	r += fmt.Sprintf("            Checksum: \"%v\",\n", z.Checksum)
+	r += fmt.Sprintf("            PrevHash: \"%v\",\n", z.PrevHash)
+	r += fmt.Sprintf("           ImageHash: \"%v\",\n", z.ImageHash)
+	r += fmt.Sprintf("          SourceHost: \"%v\",\n", z.SourceHost)
+	r += fmt.Sprintf("        SourceBookID: \"%v\",\n", z.SourceBookID)
+	r += fmt.Sprintf("           ErrorJSON: \"%v\",\n", z.ErrorJSON)
+	r += fmt.Sprintf("              WallNs: %v,\n", z.WallNs)
+	r += fmt.Sprintf("               CPUNs: %v,\n", z.CPUNs)
+	r += fmt.Sprintf("         RHeapBefore: %v,\n", z.RHeapBefore)
+	r += fmt.Sprintf("          RHeapAfter: %v,\n", z.RHeapAfter)
+	r += fmt.Sprintf("           RHeapPeak: %v,\n", z.RHeapPeak)
+	r += fmt.Sprintf("              MaxRSS: %v,\n", z.MaxRSS)
+	r += fmt.Sprintf("           ImageMIME: \"%v\",\n", z.ImageMIME)
+	r += fmt.Sprintf("            ImageSVG: %v,\n", z.ImageSVG)
+	r += fmt.Sprintf("            ImagePDF: %v,\n", z.ImagePDF)
+	r += fmt.Sprintf("             PlotRDS: %v,\n", z.PlotRDS)
+	r += fmt.Sprintf("           TableJSON: \"%v\",\n", z.TableJSON)
+	r += fmt.Sprintf("          WidgetJSON: \"%v\",\n", z.WidgetJSON)
+	r += fmt.Sprintf("          WidgetHTML: %v,\n", z.WidgetHTML)
+	r += fmt.Sprintf("          WidgetHash: \"%v\",\n", z.WidgetHash)
 	r += "}\n"
 	return
 }
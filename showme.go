@@ -36,6 +36,15 @@ var curDirImagesLoaded = make(chan bool, 0)
 
 var gJsonCandles []byte
 
+// the highlight.js we serve, relative to homeRbookDir().
+const highlightJS = "js_css/cdn.jsdelivr.net/gh/highlightjs/cdn-release@11.6.0/build/highlight.min.js"
+const highlightCSS = "js_css/cdn.jsdelivr.net/gh/highlightjs/cdn-release@11.6.0/build/styles/devibeans.min.css"
+
+// homeRbookDir is where the rbook source, and so js_css/, is checked out.
+func homeRbookDir() string {
+	return os.Getenv("HOME") + "/go/src/github.com/glycerine/rbook/"
+}
+
 func getcwd() string {
 	cwd, err := os.Getwd()
 	panicOn(err)
@@ -150,10 +159,9 @@ func StartShowme(cfg *RbookConfig, b *HashRBook) {
 		}()
 	*/
 
-	home := os.Getenv("HOME")
-	homeRbook := home + "/go/src/github.com/glycerine/rbook/"
+	homeRbook := homeRbookDir()
 
-	myCSS := "js_css/cdn.jsdelivr.net/gh/highlightjs/cdn-release@11.6.0/build/styles/devibeans.min.css"
+	myCSS := highlightCSS
 	http.HandleFunc("/"+myCSS, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/css")
 		css, err := ioutil.ReadFile(homeRbook + myCSS)
@@ -165,7 +173,7 @@ func StartShowme(cfg *RbookConfig, b *HashRBook) {
 		}
 	})
 
-	myJS := "js_css/cdn.jsdelivr.net/gh/highlightjs/cdn-release@11.6.0/build/highlight.min.js"
+	myJS := highlightJS
 	http.HandleFunc("/"+myJS, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/javascript")
 		js, err := ioutil.ReadFile(homeRbook + myJS)
@@ -355,7 +363,7 @@ func StartShowme(cfg *RbookConfig, b *HashRBook) {
 
 	// load default candles, these can be replaced via setWebData() in R.
 	//home := os.Getenv("HOME")
-	gJsonCandles, err = ioutil.ReadFile(home + "/go/src/github.com/glycerine/rbook/testdata/stock-DJI.json")
+	gJsonCandles, err = ioutil.ReadFile(homeRbook + "testdata/stock-DJI.json")
 	panicOn(err)
 
 	http.HandleFunc("/data/stock-DJI.json", func(w http.ResponseWriter, r *http.Request) {
@@ -458,14 +466,15 @@ func StartShowme(cfg *RbookConfig, b *HashRBook) {
 		b.mut.Lock()
 		defer b.mut.Unlock()
 
-		e, ok := b.path2image[path]
-		if !ok {
+		// the png, or an svg or pdf beside it; see vector.go.
+		e, mime := b.imageForPath(path)
+		if e == nil {
 			//vv("path '%v' not found in book path2image; path2image = '%#v'", path, b.path2image)
 			http.Error(w, "invalid URL path", http.StatusBadRequest)
 			return
 		}
 		//vv("path '%v' found in book path2image", path)
-		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Type", mime)
 
 		// suddenly chrome is refusing to load images. wtf.
 		// Access to image at 'http://rog:8888/rbook/home/jaten/powerscalp_dni/my.rbook.plots/plotmini_000_zVoV92eLXjdLxMkY2Cyg.png?pathhash=tpRjceX508i5xt9Gw1boII1Tur77Jb-qJ_o0qjEQa33MmnlSKAsoJK-qRNvjomPn0f10Pvnum9sdpj92VoVMAw' (redirected from 'http://rog:8888/rbook//home/jaten/powerscalp_dni/my.rbook.plots/plotmini_000_zVoV92eLXjdLxMkY2Cyg.png?pathhash=tpRjceX508i5xt9Gw1boII1Tur77Jb-qJ_o0qjEQa33MmnlSKAsoJK-qRNvjomPn0f10Pvnum9sdpj92VoVMAw') from origin 'http://rog:8888' has been blocked by CORS policy: The request client is not a secure context and the resource is in more-private address space `private`.
@@ -475,6 +484,9 @@ func StartShowme(cfg *RbookConfig, b *HashRBook) {
 
 		// https://stackoverflow.com/questions/61050144/cache-control-immutable-header/61053585#61053585
 		etag := `"` + e.ImagePathHash + `"` // "SomeKey describing content - eg checksum"
+		if mime != e.mimeOf() {
+			etag = `"` + e.ImagePathHash + "-" + filepath.Ext(path)[1:] + `"`
+		}
 		w.Header().Set("Etag", etag)
 		w.Header().Set("Cache-Control", "max-age=365000000, immutable") // >10 years, immutable
 		if match := r.Header.Get("If-None-Match"); match != "" {
@@ -484,11 +496,46 @@ func StartShowme(cfg *RbookConfig, b *HashRBook) {
 			}
 		}
 
-		readSeeker := bytes.NewReader(e.ImageBy)
+		by, err := b.renderBytes(e, mime)
+		if err != nil {
+			vv("could not load image for path '%v': '%v'", path, err)
+			http.Error(w, "image not available", http.StatusInternalServerError)
+			return
+		}
+		if len(by) == 0 && mime != e.mimeOf() {
+			http.Error(w, "no such rendering of this image", http.StatusNotFound)
+			return
+		}
+		readSeeker := bytes.NewReader(by)
 		modtime := e.Tm
 		http.ServeContent(w, r, "", modtime, readSeeker)
 	})
 
+	// a plot drawn again at another size or format; see render.go.
+	http.HandleFunc("/rbook/render", func(w http.ResponseWriter, r *http.Request) {
+		serveRender(w, r, b, cfg.Rhome)
+	})
+
+	// a printed htmlwidget, for its sandboxed iframe; see widgets.go.
+	http.HandleFunc("/widget/", func(w http.ResponseWriter, r *http.Request) {
+		serveWidget(w, r, b)
+	})
+
+	// read-only merge of our book with those from other hosts; see merge.go.
+	http.HandleFunc("/timeline", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Private-Network", "true")
+		b.mut.Lock()
+		path := b.diskPath
+		b.mut.Unlock()
+		serveTimeline(w, path)
+	})
+
+	// search the live book; see grep.go.
+	http.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Private-Network", "true")
+		serveSearch(w, r, b)
+	})
+
 	http.HandleFunc("/tvcandles", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Private-Network", "true")
 
@@ -0,0 +1,182 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Per command timing and memory, for hunting slow steps and
+// memory blowups. The REPL loop in main measures around each
+// embedr.ReplDLLdo1(), and keeps what it found in the Command's
+// WallNs, CPUNs, RHeapBefore, RHeapAfter, and MaxRSS.
+//
+// ReplDLLdo1() first waits for a line of input, then evaluates
+// it. So the wall clock starts not at the call, but when the
+// input arrives: when the line can be read from the console,
+// as watchInput() sees it. Time spent idle, or typing the
+// command in, is not counted.
+//
+// Memory is the process's, R's heap included, as the OS counts
+// it; see processRSS(). Asking R would cost a gc() per command:
+// R only counts its heap in use by collecting it.
+
+// processUsage returns the user plus system time of the whole
+// process so far, and its peak resident set size in bytes: the
+// most it has used at any time since it started.
+func processUsage() (cpu time.Duration, maxRSS int64) {
+	var ru syscall.Rusage
+	err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru)
+	if err != nil {
+		return 0, 0
+	}
+	cpu = time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+	maxRSS = int64(ru.Maxrss)
+	if runtime.GOOS != "darwin" {
+		// linux gives kilobytes; darwin, bytes.
+		maxRSS *= 1024
+	}
+	return
+}
+
+// cmdMeter measures one trip through embedr.ReplDLLdo1().
+type cmdMeter struct {
+	t0   time.Time
+	cpu0 time.Duration
+
+	// when the input arrived; see watchInput(). Closing halt
+	// wakes watchInput.
+	input chan time.Time
+	halt  *os.File
+
+	// what we found, for fill().
+	wall, cpu           time.Duration
+	memBefore, memAfter int64
+	maxRSS              int64
+}
+
+// startCmdMeter is called just before embedr.ReplDLLdo1().
+func startCmdMeter() *cmdMeter {
+	m := &cmdMeter{
+		input: make(chan time.Time, 1),
+	}
+	m.memBefore = processRSS()
+	m.cpu0, _ = processUsage()
+	m.t0 = time.Now()
+	r, w, err := os.Pipe()
+	if err != nil {
+		// no watch; time from the call.
+		return m
+	}
+	m.halt = w
+	go m.watchInput(r)
+	return m
+}
+
+// watchInput notes when console input is first ready to be read,
+// without reading it; R does that. It sleeps in select() until
+// then, or until stop() closes m.halt, the write end of r. If R
+// already holds the input, after a ; or from a paste, there may
+// be nothing to see; then the call itself is the start.
+func (m *cmdMeter) watchInput(r *os.File) {
+	defer r.Close()
+	if waitInput(r) {
+		m.input <- time.Now()
+	}
+}
+
+// stop is called just after embedr.ReplDLLdo1().
+func (m *cmdMeter) stop() {
+	end := time.Now()
+	if m.halt != nil {
+		m.halt.Close()
+	}
+	select {
+	case t := <-m.input:
+		m.t0 = t
+	default:
+	}
+	m.wall = end.Sub(m.t0)
+	cpu, maxRSS := processUsage()
+	m.cpu = cpu - m.cpu0
+	m.maxRSS = maxRSS
+	m.memAfter = processRSS()
+}
+
+// fill puts the measurements on e, the Command that ran.
+func (m *cmdMeter) fill(e *HashRElem) {
+	e.WallNs = int64(m.wall)
+	e.CPUNs = int64(m.cpu)
+	e.RHeapBefore = m.memBefore
+	e.RHeapAfter = m.memAfter
+	e.MaxRSS = m.maxRSS
+}
+
+// hasStats is true if e has any of the measurements.
+func (e *HashRElem) hasStats() bool {
+	return e.WallNs != 0 || e.CPUNs != 0 || e.RHeapAfter != 0 || e.MaxRSS != 0
+}
+
+// StatsString gives e's measurements for people, as shown beside
+// the command in the browser and by -dumpts; "" if it has none.
+func (e *HashRElem) StatsString() string {
+	if !e.hasStats() {
+		return ""
+	}
+	var parts []string
+	if e.WallNs != 0 || e.CPUNs != 0 {
+		parts = append(parts, fmt.Sprintf("wall %v, cpu %v", fmtNs(e.WallNs), fmtNs(e.CPUNs)))
+	}
+	if e.RHeapAfter != 0 {
+		parts = append(parts, fmt.Sprintf("memory %v -> %v", fmtBytes(e.RHeapBefore), fmtBytes(e.RHeapAfter)))
+	}
+	if e.MaxRSS != 0 {
+		parts = append(parts, fmt.Sprintf("process peak RSS %v", fmtBytes(e.MaxRSS)))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func fmtNs(ns int64) string {
+	d := time.Duration(ns)
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(100 * time.Microsecond).String()
+	}
+	return d.Round(time.Microsecond).String()
+}
+
+func fmtBytes(n int64) string {
+	const kb, mb, gb = 1 << 10, 1 << 20, 1 << 30
+	switch {
+	case n >= gb:
+		return fmt.Sprintf("%.2f GB", float64(n)/gb)
+	case n >= mb:
+		return fmt.Sprintf("%.1f MB", float64(n)/mb)
+	case n >= kb:
+		return fmt.Sprintf("%.1f KB", float64(n)/kb)
+	}
+	return fmt.Sprintf("%v B", n)
+}
+
+// withStats adds e's measurements to the Command message msg, for
+// the browser to show beside the command.
+func withStats(msg string, e *HashRElem) string {
+	stats := e.StatsString()
+	colon := strings.Index(msg, ":")
+	if stats == "" || colon < 0 || !strings.HasSuffix(msg, "}") {
+		return msg
+	}
+	by, err := json.Marshal(stats)
+	panicOn(err)
+	json := fmt.Sprintf(`%v, "stats":%v}`, msg[colon+1:len(msg)-1], string(by))
+	lenPrefixedJson := fmt.Sprintf("%v:%v", len(json), json)
+	return lenPrefixedJson
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestCommandStats(t *testing.T) {
+
+	cv.Convey("a Command should keep how long it ran and the memory it used, and show them in its message for the browser, in -dumpts, and in -dump-jsonl", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-stats")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "my.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		cpu, maxRSS := processUsage()
+		cv.So(cpu, cv.ShouldBeGreaterThan, 0)
+		cv.So(maxRSS, cv.ShouldBeGreaterThan, 1<<20)
+		rss := processRSS()
+		cv.So(rss > 1<<20 && rss <= maxRSS, cv.ShouldBeTrue)
+
+		e := &HashRElem{
+			Typ:         Command,
+			Tm:          time.Date(2023, 9, 12, 5, 0, 0, 0, Chicago),
+			WallNs:      int64(2345 * time.Millisecond),
+			CPUNs:       int64(1500 * time.Microsecond),
+			RHeapBefore: 40 << 20,
+			RHeapAfter:  42 << 20,
+			RHeapPeak:   3 << 30,
+			MaxRSS:      5 << 30,
+		}
+		stats := "wall 2.345s, cpu 1.5ms; memory 40.0 MB -> 42.0 MB; process peak RSS 5.00 GB"
+		cv.So(e.StatsString(), cv.ShouldEqual, stats)
+		cv.So((&HashRElem{Typ: Command}).StatsString(), cv.ShouldEqual, "")
+
+		msg, n := prepCommandMessage("x <- big()", 0)
+		e.CmdJSON = withStats(msg, e)
+		e.BeginCommandLineNum, e.NumCommandLines = 1, n
+		var m struct {
+			Seqno   int      `json:"seqno"`
+			Command []string `json:"command"`
+			Stats   string   `json:"stats"`
+		}
+		panicOn(decodeLenPrefixed(e.CmdJSON, &m))
+		cv.So(m.Command, cv.ShouldResemble, []string{"x <- big()"})
+		cv.So(m.Stats, cv.ShouldEqual, stats)
+		cv.So(withStats(msg, &HashRElem{}), cv.ShouldEqual, msg)
+
+		writeHostBook(path, "rog", []*HashRElem{e})
+		h, err := loadBookReadOnly(path)
+		panicOn(err)
+		cv.So(h.elems[0].RHeapPeak, cv.ShouldEqual, int64(3<<30))
+		cv.So(h.elems[0].WallNs, cv.ShouldEqual, e.WallNs)
+
+		fd, err := ioutil.TempFile(dir, "dumpts")
+		panicOn(err)
+		cv.So((&RbookConfig{DumpTimestamps: true}).dumpBook(fd, path), cv.ShouldBeTrue)
+		panicOn(fd.Close())
+		cv.So(string(mustReadFile(fd.Name())), cv.ShouldContainSubstring, "command line [001]  =====:  "+stats+"\n")
+
+		je, err := (&RbookConfig{}).jsonlElemFor(h, h.elems[0])
+		panicOn(err)
+		by, err := json.Marshal(je)
+		panicOn(err)
+		back := &jsonlElem{}
+		panicOn(json.Unmarshal(by, back))
+		cv.So(back.CPUNs, cv.ShouldEqual, e.CPUNs)
+		cv.So(back.MaxRSS, cv.ShouldEqual, e.MaxRSS)
+	})
+}
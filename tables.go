@@ -0,0 +1,202 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/glycerine/embedr"
+)
+
+// Data frames as tables. When a command prints a data.frame,
+// tibble, or matrix, we keep its first rows and columns as a Table
+// element, after the Console element with the printed text: the
+// column names and types, and the values, numbers as numbers and
+// NA as null, so the browser can sort them. The printed text stays
+// as it was, for -dump and the other tools.
+//
+// Only a value R printed itself counts: a top level task callback
+// notes the value of each command, if visible. So glimpse(df), or
+// anything else that prints and returns its data.frame invisibly,
+// gives no Table.
+
+// maxTableRows is how many rows of a table we keep.
+const maxTableRows = 1000
+
+// maxTableCols is how many columns of a table we keep.
+const maxTableCols = 100
+
+// tableR sets up .rbook.shown: .rbook.table(value, n, m) of the
+// value of the last command, if R printed it; else character(0).
+// The callback makes the table as the value goes by, and keeps
+// no reference to the value itself, so a big data.frame is not
+// held on to past its command. .rbook.table(v, n, m) gives the
+// first n rows and m columns of v, if a data.frame or matrix,
+// as one character vector:
+//
+//	nrow(v), ncol(v), rows kept, columns kept, then
+//	the kept names, their types, the kept rownames,
+//	a string of 0/1, 1 for each cell that is NA, then
+//	the cells, column by column; "" for NA.
+//
+// It gives character(0) for anything else.
+func tableR(n, m int) string {
+	return fmt.Sprintf(tableRfmt, n, m)
+}
+
+const tableRfmt = `local({
+  .rbook.shown <<- character(0)
+  addTaskCallback(function(expr, value, ok, visible) {
+    .rbook.shown <<- if (ok && visible) tryCatch(.rbook.table(value, %v, %v), error=function(e) character(0)) else character(0)
+    TRUE
+  }, name="rbook.table")
+  .rbook.table <<- function(v, n, m) {
+    if (!(is.data.frame(v) || is.matrix(v)) || length(dim(v)) != 2L || ncol(v) == 0L) return(character(0))
+    d <- head(v, n)
+    if (ncol(d) > m) d <- d[, seq_len(m), drop=FALSE]
+    nm <- colnames(d)
+    if (is.null(nm)) nm <- paste0("V", seq_len(ncol(d)))
+    rn <- rownames(d)
+    if (is.null(rn)) rn <- as.character(seq_len(nrow(d)))
+    if (is.matrix(d)) {
+      ty <- rep(typeof(d), ncol(d))
+      cols <- lapply(seq_len(ncol(d)), function(j) d[, j])
+    } else {
+      ty <- vapply(seq_len(ncol(d)), function(j) class(d[[j]])[1L], "")
+      cols <- lapply(seq_len(ncol(d)), function(j) d[[j]])
+    }
+    cell <- function(x) if (is.list(x)) vapply(x, function(y) paste(format(y), collapse=", "), "") else as.character(x)
+    isna <- function(x) if (is.list(x)) vapply(x, function(y) length(y) == 1L && is.na(y), NA) else is.na(x)
+    na <- unlist(lapply(cols, isna))
+    cells <- unlist(lapply(cols, cell))
+    cells[na] <- ""
+    c(nrow(v), ncol(v), nrow(d), ncol(d), nm, ty, rn, paste(ifelse(na, "1", "0"), collapse=""), cells)
+  }
+  invisible()
+})`
+
+// rTable is a Table element's table, as the browser gets it.
+type rTable struct {
+	NRow     int      `json:"nrow"` // of the whole table; len(Rows) were kept.
+	NCol     int      `json:"ncol"` // of the whole table; len(Names) were kept.
+	Names    []string `json:"names"`
+	Types    []string `json:"types"` // R's class() of each column; typeof() for a matrix.
+	RowNames []string `json:"rownames"`
+
+	// Rows hold numbers for numeric columns, true or false for
+	// logical ones, and strings for the rest; nil for NA.
+	Rows [][]interface{} `json:"rows"`
+}
+
+// shownTable gives the first rows and columns of the value R
+// printed for the last command, if it is a table; else nil.
+func shownTable() *rTable {
+	got, err := embedr.EvalR_fullback(`local({v <- .rbook.shown; .rbook.shown <<- character(0); v})`)
+	if err != nil {
+		return nil
+	}
+	t, err := parseRTable(rStrings(got))
+	if err != nil {
+		vv("could not read table: '%v'", err)
+		return nil
+	}
+	return t
+}
+
+// parseRTable reads what .rbook.table() gave; nil if it
+// gave nothing.
+func parseRTable(v []string) (t *rTable, err error) {
+	if len(v) == 0 {
+		return nil, nil
+	}
+	if len(v) < 4 {
+		return nil, fmt.Errorf("parseRTable: short header %q", v)
+	}
+	var n [4]int
+	for i := range n {
+		n[i], err = strconv.Atoi(v[i])
+		if err != nil {
+			return nil, fmt.Errorf("parseRTable: bad header %q", v[:4])
+		}
+	}
+	nrow, allcol, kept, ncol := n[0], n[1], n[2], n[3]
+	if ncol <= 0 || kept < 0 || len(v) != 4+2*ncol+kept+1+ncol*kept {
+		return nil, fmt.Errorf("parseRTable: %v columns of %v rows do not fit %v values", ncol, kept, len(v))
+	}
+	v = v[4:]
+	t = &rTable{
+		NRow:     nrow,
+		NCol:     allcol,
+		Names:    v[:ncol],
+		Types:    v[ncol : 2*ncol],
+		RowNames: v[2*ncol : 2*ncol+kept],
+		Rows:     make([][]interface{}, kept),
+	}
+	na := v[2*ncol+kept]
+	cells := v[2*ncol+kept+1:]
+	if len(na) != len(cells) {
+		return nil, fmt.Errorf("parseRTable: %v NA flags for %v cells", len(na), len(cells))
+	}
+	for i := range t.Rows {
+		t.Rows[i] = make([]interface{}, ncol)
+	}
+	for j := 0; j < ncol; j++ {
+		for i := 0; i < kept; i++ {
+			k := j*kept + i
+			if na[k] == '1' {
+				continue // nil, for NA.
+			}
+			t.Rows[i][j] = tableCell(t.Types[j], cells[k])
+		}
+	}
+	return
+}
+
+// tableCell gives the value of s, from a column of R type ty,
+// for json.
+func tableCell(ty, s string) interface{} {
+	switch ty {
+	case "numeric", "double", "integer":
+		x, err := strconv.ParseFloat(s, 64)
+		if err == nil && !math.IsInf(x, 0) && !math.IsNaN(x) {
+			return x
+		}
+	case "logical":
+		switch s {
+		case "TRUE":
+			return true
+		case "FALSE":
+			return false
+		}
+	}
+	return s
+}
+
+// prepTableMessage gives the message for a Table element.
+func prepTableMessage(t *rTable, seqno int) string {
+	by, err := json.Marshal(t)
+	panicOn(err)
+	json := fmt.Sprintf(`{"seqno": %v, "table":%v}`, seqno, string(by))
+	lenPrefixedJson := fmt.Sprintf("%v:%v", len(json), json)
+	return lenPrefixedJson
+}
+
+// Summary gives the shape of t, for the text tools.
+func (t *rTable) Summary() string {
+	s := fmt.Sprintf("%v x %v: %v", t.NRow, t.NCol, strings.Join(t.Names, ", "))
+	var kept []string
+	if len(t.Rows) < t.NRow {
+		kept = append(kept, fmt.Sprintf("%v rows", len(t.Rows)))
+	}
+	if len(t.Names) < t.NCol {
+		kept = append(kept, fmt.Sprintf("%v columns", len(t.Names)))
+	}
+	if len(kept) > 0 {
+		s += fmt.Sprintf(" (first %v kept)", strings.Join(kept, " and "))
+	}
+	return s
+}
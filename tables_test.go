@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestTableElem(t *testing.T) {
+
+	cv.Convey("a printed data.frame should also be kept as a Table element of typed values, NA as null; -dump should show only the printed text, and -textconv and -extract should know the type", t, func() {
+
+		// as .rbook.table(df, 2, 100) would give for a 5 row
+		// df with columns x (numeric), ok (logical), and s (factor).
+		got := []string{"5", "3", "2", "3",
+			"x", "ok", "s",
+			"numeric", "logical", "factor",
+			"1", "2",
+			"010001",
+			"1.5", "", "TRUE", "FALSE", "a", ""}
+		tb, err := parseRTable(got)
+		panicOn(err)
+		cv.So(tb.NRow, cv.ShouldEqual, 5)
+		cv.So(tb.NCol, cv.ShouldEqual, 3)
+		cv.So(tb.Names, cv.ShouldResemble, []string{"x", "ok", "s"})
+		cv.So(tb.RowNames, cv.ShouldResemble, []string{"1", "2"})
+		cv.So(tb.Rows[0], cv.ShouldResemble, []interface{}{1.5, true, "a"})
+		cv.So(tb.Rows[1][0] == nil, cv.ShouldBeTrue)
+		cv.So(tb.Rows[1][1], cv.ShouldEqual, false)
+		cv.So(tb.Rows[1][2] == nil, cv.ShouldBeTrue)
+		cv.So(tb.Summary(), cv.ShouldEqual, "5 x 3: x, ok, s (first 2 rows kept)")
+
+		// as .rbook.table(df, 2, 2) would give: the first two columns.
+		narrow, err := parseRTable([]string{"5", "3", "2", "2",
+			"x", "ok",
+			"numeric", "logical",
+			"1", "2",
+			"0100",
+			"1.5", "", "TRUE", "FALSE"})
+		panicOn(err)
+		cv.So(narrow.NCol, cv.ShouldEqual, 3)
+		cv.So(narrow.Rows[0], cv.ShouldResemble, []interface{}{1.5, true})
+		cv.So(narrow.Summary(), cv.ShouldEqual, "5 x 3: x, ok (first 2 rows and 2 columns kept)")
+
+		// Inf is kept as R prints it; json has no number for it.
+		cv.So(tableCell("numeric", "Inf"), cv.ShouldEqual, "Inf")
+		cv.So(tableCell("integer", "42"), cv.ShouldEqual, 42.0)
+
+		none, err := parseRTable(nil)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(none == nil, cv.ShouldBeTrue)
+		_, err = parseRTable(got[:len(got)-1])
+		cv.So(err, cv.ShouldNotBeNil)
+
+		dir, err := ioutil.TempDir("", "rbook-table")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "my.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		t0 := time.Date(2023, 9, 12, 5, 0, 0, 0, Chicago)
+		msg, n := prepCommandMessage("head(df, 2)", 0)
+		writeHostBook(path, "rog", []*HashRElem{
+			{Typ: Command, Tm: t0, CmdJSON: msg, BeginCommandLineNum: 1, NumCommandLines: n},        // 0
+			{Typ: Console, Tm: t0, ConsoleJSON: prepConsoleMessage(`["##     x    ok s"]`, 1)},      // 1
+			{Typ: Table, Tm: t0, TableJSON: prepTableMessage(tb, 2)},                                // 2
+			{Typ: Comment, Tm: t0.Add(time.Minute), CommentJSON: prepCommentMessage(`"### ok"`, 3)}, // 3
+		})
+
+		h, err := loadBookReadOnly(path)
+		panicOn(err)
+		cv.So(h.elems[2].Typ, cv.ShouldEqual, Table)
+		cv.So(Table.String(), cv.ShouldEqual, "Table")
+		d, err := decodeElem(h.elems[2])
+		panicOn(err)
+		cv.So(d.Seqno, cv.ShouldEqual, 2)
+		cv.So(d.Table.Names, cv.ShouldResemble, []string{"x", "ok", "s"})
+		cv.So(d.Table.Rows[0], cv.ShouldResemble, []interface{}{1.5, true, "a"})
+
+		for _, ts := range []bool{false, true} {
+			fd, err := ioutil.TempFile(dir, "dump")
+			panicOn(err)
+			cv.So((&RbookConfig{DumpTimestamps: ts}).dumpBook(fd, path), cv.ShouldBeTrue)
+			panicOn(fd.Close())
+			dump := string(mustReadFile(fd.Name()))
+			cv.So(dump, cv.ShouldContainSubstring, "\n    ##     x    ok s\n")
+			cv.So(strings.Contains(dump, "nrow"), cv.ShouldBeFalse)
+			cv.So(strings.Count(dump, "====="), cv.ShouldEqual, map[bool]int{false: 0, true: 6}[ts])
+		}
+
+		var text bytes.Buffer
+		cv.So((&RbookConfig{}).textconvBook(&text, path), cv.ShouldBeTrue)
+		cv.So(text.String(), cv.ShouldContainSubstring, "\n[0002] table ")
+		cv.So(text.String(), cv.ShouldContainSubstring, "\n    5 x 3: x, ok, s (first 2 rows kept)\n")
+
+		// -grep finds a table by its column names.
+		var hits bytes.Buffer
+		cv.So((&RbookConfig{Grep: "ok, s", GrepIn: []string{path}}).grepCmd(&hits), cv.ShouldBeTrue)
+		cv.So(hits.String(), cv.ShouldContainSubstring, "  seqno 2  [001]  ")
+		cv.So(hits.String(), cv.ShouldEndWith, "  table: 5 x 3: x, ok, s (first 2 rows kept)\n")
+
+		f, err := newElemFilter("", "", "", "comment,table")
+		panicOn(err)
+		cfg := &RbookConfig{Out: filepath.Join(dir, "tables.rbook"), filter: f}
+		cv.So(cfg.extractBook(path), cv.ShouldBeTrue)
+		x, err := loadBookReadOnly(cfg.Out)
+		panicOn(err)
+		cv.So(len(x.elems), cv.ShouldEqual, 2)
+		d, err = decodeElem(x.elems[0])
+		panicOn(err)
+		cv.So(d.Seqno, cv.ShouldEqual, 0)
+		cv.So(d.Table.NRow, cv.ShouldEqual, 5)
+	})
+}
+
+func TestShownTable(t *testing.T) {
+
+	rscript, err := exec.LookPath("Rscript")
+	if err != nil {
+		t.Skip("needs R's Rscript on the PATH")
+	}
+
+	cv.Convey("only a data.frame R printed itself should give a table, cut to the rows and columns asked for; one returned invisibly, as glimpse() does, should not", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-shown")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+
+		script := strings.Join([]string{
+			tableR(2, 1),
+			`df <- data.frame(x=c(1.5, NA, 3), ok=c(TRUE, FALSE, NA))`,
+			`df`,
+			`cat("shown", paste(.rbook.shown, collapse="|"), "\n")`,
+			`glimpse <- function(d) { print(summary(d)); invisible(d) }`,
+			`glimpse(df)`,
+			`cat("glimpse", length(.rbook.shown), "\n")`,
+			// nothing is left holding on to a printed value.
+			`local({e <- new.env(); reg.finalizer(e, function(e) cat("collected\n")); e})`,
+			`1`,
+			`invisible(gc())`,
+		}, "\n")
+		scriptPath := filepath.Join(dir, "shown.R")
+		panicOn(ioutil.WriteFile(scriptPath, []byte(script), 0600))
+
+		out, err := exec.Command(rscript, "--vanilla", scriptPath).CombinedOutput()
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(string(out), cv.ShouldContainSubstring, "shown 3|2|2|1|x|numeric|1|2|01|1.5| \n")
+		cv.So(string(out), cv.ShouldContainSubstring, "glimpse 0 \n")
+		cv.So(string(out), cv.ShouldContainSubstring, "collected\n")
+
+		tb, err := parseRTable(strings.Split("3|2|2|1|x|numeric|1|2|01|1.5|", "|"))
+		panicOn(err)
+		cv.So(tb.Summary(), cv.ShouldEqual, "3 x 2: x (first 2 rows and 1 columns kept)")
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/glycerine/embedr"
+)
+
+// Vector renderings of plots, for taking figures into a paper.
+// Under rbook -vector svg,pdf each captured page is also copied,
+// by dev.copy(), to an svg() and/or cairo_pdf() device, and kept
+// beside the png in the Image element's ImageSVG and ImagePDF.
+// The files land in <book>.plots/ too, named as the png but for
+// the extension; /rbook/ serves them under those names.
+
+const (
+	mimePNG = "image/png"
+	mimeSVG = "image/svg+xml"
+	mimePDF = "application/pdf"
+)
+
+// parseVector checks the -vector list, giving its kinds, "svg"
+// and "pdf", in that order, once each.
+func parseVector(s string) (kinds []string, err error) {
+	if s == "" {
+		return nil, nil
+	}
+	var svg, pdf bool
+	for _, k := range strings.Split(s, ",") {
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "svg":
+			svg = true
+		case "pdf":
+			pdf = true
+		default:
+			return nil, fmt.Errorf("-vector: unknown kind '%v'; use svg, pdf, or svg,pdf", k)
+		}
+	}
+	if svg {
+		kinds = append(kinds, "svg")
+	}
+	if pdf {
+		kinds = append(kinds, "pdf")
+	}
+	return
+}
+
+// besidePNG gives where a file of the kind (svg, pdf, or rds)
+// made from the same page as the png at pngPath goes.
+func besidePNG(pngPath, kind string) string {
+	return strings.TrimSuffix(pngPath, filepath.Ext(pngPath)) + "." + kind
+}
+
+// vectorR is the R code that copies the page on the current
+// device to a kind file at path, 7 inches square: the size of our
+// 700 pixel png, which pngArgs opens at 100 dpi. Then it goes back
+// to the current device. Our devices keep their display lists, so
+// dev.copy() can replay them.
+func vectorR(kind, path string) string {
+	dev := "svg"
+	if kind == "pdf" {
+		dev = "cairo_pdf"
+	}
+	return fmt.Sprintf(`local({ d <- dev.cur(); dev.copy(%v, filename='%v', width=7, height=7); dev.off(); dev.set(d); invisible() })`, dev, path)
+}
+
+// saveVectors copies the page on the current device to each of
+// kinds, beside the png to be saved at pngPath, returning the
+// bytes. A rendering that fails is left out; the png is still
+// saved.
+func saveVectors(kinds []string, pngPath string) (svg, pdf []byte) {
+	for _, kind := range kinds {
+		path := besidePNG(pngPath, kind)
+		err := embedr.EvalR(vectorR(kind, path))
+		if err != nil {
+			vv("error during %v copy to '%v': '%v'", kind, path, err)
+			continue
+		}
+		by, err := ioutil.ReadFile(path)
+		if err != nil {
+			vv("could not read back %v copy '%v': '%v'", kind, path, err)
+			continue
+		}
+		if kind == "svg" {
+			svg = by
+		} else {
+			pdf = by
+		}
+	}
+	return
+}
+
+// mimeOf gives the MIME type of e's ImageBy.
+func (e *HashRElem) mimeOf() string {
+	if e.ImageMIME == "" {
+		return mimePNG
+	}
+	return e.ImageMIME
+}
+
+// withVectors adds the paths of e's vector renderings to its
+// Image message msg, so the browser can show the svg, and offer
+// the pdf.
+func withVectors(msg string, e *HashRElem) string {
+	var add string
+	if len(e.ImageSVG) > 0 {
+		by, err := json.Marshal(besidePNG(e.ImagePath, "svg"))
+		panicOn(err)
+		add += fmt.Sprintf(`, "svg":%v`, string(by))
+	}
+	if len(e.ImagePDF) > 0 {
+		by, err := json.Marshal(besidePNG(e.ImagePath, "pdf"))
+		panicOn(err)
+		add += fmt.Sprintf(`, "pdf":%v`, string(by))
+	}
+	colon := strings.Index(msg, ":")
+	if add == "" || colon < 0 || !strings.HasSuffix(msg, "}") {
+		return msg
+	}
+	json := msg[colon+1:len(msg)-1] + add + "}"
+	lenPrefixedJson := fmt.Sprintf("%v:%v", len(json), json)
+	return lenPrefixedJson
+}
+
+// imageForPath finds the Image element that /rbook/path asks
+// for, and the MIME type of the rendering wanted: the png at its
+// ImagePath, or an svg or pdf beside it. Caller holds h.mut.
+func (h *HashRBook) imageForPath(path string) (e *HashRElem, mime string) {
+	if e, ok := h.path2image[path]; ok {
+		return e, e.mimeOf()
+	}
+	ext := filepath.Ext(path)
+	switch ext {
+	case ".svg":
+		mime = mimeSVG
+	case ".pdf":
+		mime = mimePDF
+	default:
+		return nil, ""
+	}
+	e, ok := h.path2image[strings.TrimSuffix(path, ext)+".png"]
+	if !ok {
+		return nil, ""
+	}
+	return e, mime
+}
+
+// renderBytes returns the mime rendering of the Image element e;
+// empty if it has none. Caller holds h.mut.
+func (h *HashRBook) renderBytes(e *HashRElem, mime string) ([]byte, error) {
+	switch mime {
+	case mimeSVG, mimePDF:
+	default:
+		return h.imageBytes(e)
+	}
+	src := e
+	if e.lazyImage {
+		got, err := h.loadLazyFrame(e)
+		if err != nil {
+			return nil, err
+		}
+		src = got
+	}
+	if mime == mimeSVG {
+		return src.ImageSVG, nil
+	}
+	return src.ImagePDF, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestVectorRenderings(t *testing.T) {
+
+	cv.Convey("under rbook -vector an Image element should carry svg and pdf renderings beside its png, found by /rbook/ under their own names and content types, even under -lazy with the png in -blobs; and kept by -dump-jsonl and -load-jsonl", t, func() {
+
+		kinds, err := parseVector("pdf, SVG")
+		panicOn(err)
+		cv.So(kinds, cv.ShouldResemble, []string{"svg", "pdf"})
+		_, err = parseVector("eps")
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(besidePNG("/b.plots/plotmini_000_x.png", "svg"), cv.ShouldEqual, "/b.plots/plotmini_000_x.svg")
+		cv.So(vectorR("pdf", "/p.pdf"), cv.ShouldContainSubstring, "dev.copy(cairo_pdf, filename='/p.pdf', width=7, height=7)")
+		// the png they sit beside is 700 pixels at 100 dpi: also 7 inches.
+		cv.So(pngOpenR("/p.png"), cv.ShouldContainSubstring, "height=700, width=700, res=100,")
+
+		dir, err := ioutil.TempDir("", "rbook-vector")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "vector.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		h, appendFD, err := ReadBook("tester", "testhost", path)
+		panicOn(err)
+		png := []byte("\x89PNG pretend plot")
+		svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`)
+		pdf := []byte("%PDF-1.5 pretend")
+		for i := 0; i < 2; i++ {
+			e := &HashRElem{
+				Typ:       Image,
+				Tm:        time.Now(),
+				Seqno:     i,
+				ImagePath: filepath.Join(dir, "plot", string(rune('a'+i))+".png"),
+				ImageMIME: mimePNG,
+			}
+			e.ImageHash, err = h.blobs.put(png)
+			panicOn(err)
+			if i == 1 {
+				e.ImageSVG = svg
+				e.ImagePDF = pdf
+			}
+			e.ImageJSON = withVectors(prepImageMessage(e.ImagePath, "ph", i), e)
+			h.mut.Lock()
+			h.appendElem(e)
+			h.mut.Unlock()
+			by, err := e.SaveToSlice()
+			panicOn(err)
+			_, err = appendFD.Write(by)
+			panicOn(err)
+		}
+		appendFD.Close()
+
+		cv.So(h.elems[1].ImageJSON, cv.ShouldEndWith, `, "svg":"`+filepath.Join(dir, "plot", "b.svg")+`", "pdf":"`+filepath.Join(dir, "plot", "b.pdf")+`"}`)
+		cv.So(strings.Contains(h.elems[0].ImageJSON, `"svg"`), cv.ShouldBeFalse)
+
+		lz, appendFD, err := ReadBookLazy("tester", "testhost", path)
+		panicOn(err)
+		defer appendFD.Close()
+		cv.So(len(lz.elems[1].ImageSVG), cv.ShouldEqual, 0)
+		d, err := decodeElem(lz.elems[1])
+		panicOn(err)
+		cv.So(d.Seqno, cv.ShouldEqual, 1)
+
+		lz.mut.Lock()
+		e, mime := lz.imageForPath(filepath.Join(dir, "plot", "b.svg"))
+		cv.So(e, cv.ShouldEqual, lz.elems[1])
+		cv.So(mime, cv.ShouldEqual, "image/svg+xml")
+		by, err := lz.renderBytes(e, mime)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(bytes.Equal(by, svg), cv.ShouldBeTrue)
+
+		e, mime = lz.imageForPath(filepath.Join(dir, "plot", "b.pdf"))
+		cv.So(mime, cv.ShouldEqual, "application/pdf")
+		by, err = lz.renderBytes(e, mime)
+		cv.So(bytes.Equal(by, pdf), cv.ShouldBeTrue)
+
+		// the png, from the blob store, though the frame was dropped.
+		e, mime = lz.imageForPath(filepath.Join(dir, "plot", "b.png"))
+		cv.So(mime, cv.ShouldEqual, "image/png")
+		by, err = lz.renderBytes(e, mime)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(bytes.Equal(by, png), cv.ShouldBeTrue)
+
+		// no svg made for the first plot.
+		e, mime = lz.imageForPath(filepath.Join(dir, "plot", "a.svg"))
+		by, err = lz.renderBytes(e, mime)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(len(by), cv.ShouldEqual, 0)
+
+		e, _ = lz.imageForPath(filepath.Join(dir, "plot", "c.svg"))
+		cv.So(e == nil, cv.ShouldBeTrue)
+		lz.mut.Unlock()
+
+		// the html export shows the svg when there is one.
+		cfg := &RbookConfig{Out: filepath.Join(dir, "out.html")}
+		cv.So(cfg.exportHTML(path), cv.ShouldBeTrue)
+		page := string(mustReadFile(cfg.Out))
+		cv.So(strings.Count(page, `src="data:image/svg+xml;base64,`), cv.ShouldEqual, 1)
+		cv.So(strings.Count(page, `src="data:image/png;base64,`), cv.ShouldEqual, 1)
+
+		jsonl := filepath.Join(dir, "vector.jsonl")
+		cfg = &RbookConfig{Out: jsonl}
+		cv.So(cfg.dumpJSONL(path), cv.ShouldBeTrue)
+		out := filepath.Join(dir, "copy.rbook")
+		cfg = &RbookConfig{Out: out}
+		cv.So(cfg.loadJSONL(jsonl), cv.ShouldBeTrue)
+		cv.So(bytes.Equal(mustReadFile(out), mustReadFile(path)), cv.ShouldBeTrue)
+	})
+}
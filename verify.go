@@ -0,0 +1,151 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/glycerine/blake2b-simd"
+)
+
+// checksumOf returns the base64 encoded blake2b-512 hash of by.
+func checksumOf(by []byte) string {
+	sum := blake2b.Sum512(by)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ComputeChecksum returns the checksum of e as it
+// serializes with an empty Checksum field. e is not modified.
+func (e *HashRElem) ComputeChecksum() (string, error) {
+	cp := *e
+	cp.Checksum = ""
+	b, err := cp.marshalAll()
+	if err != nil {
+		return "", err
+	}
+	return checksumOf(b), nil
+}
+
+// ComputeChecksum returns the checksum of the book header as it
+// serializes with an empty Checksum field. Caller must not
+// be racing with other writers of book.Checksum.
+func (book *HashRBook) ComputeChecksum() (string, error) {
+	save := book.Checksum
+	book.Checksum = ""
+	b, err := book.marshalAll()
+	book.Checksum = save
+	if err != nil {
+		return "", err
+	}
+	return checksumOf(b), nil
+}
+
+// verifyResult summarizes a walk over a book by verifyFrames.
+type verifyResult struct {
+	book *HashRBook
+
+	// the last element in the book; nil if there are none.
+	last *HashRElem
+
+	// head of the hash chain after the last element.
+	head string
+
+	nelem     int
+	checked   int // frames whose Checksum we verified.
+	unchecked int // frames without a Checksum, in books older than chainFormatVersion.
+}
+
+// verifyFrames walks every frame of the book at path, checking
+// each frame's Checksum and its link in the hash chain. It stops
+// at, and prints, the first bad frame: its seqno and its byte
+// offset in the file. Images kept under rbook -blobs are checked
+// against their checksums too. Frames written before we had
+// checksums, in books older than chainFormatVersion, are counted
+// but cannot be checked; in a newer book, a frame without its
+// checksum is bad. ok is true if nothing bad was found.
+func verifyFrames(path string) (res *verifyResult, ok bool) {
+
+	r, err := OpenBookReader(path)
+	if err != nil {
+		fmt.Printf("BAD: %v\n", err)
+		return nil, false
+	}
+	defer r.Close()
+
+	h := r.Book
+	legacy := h.FormatVersion < chainFormatVersion
+	if h.Checksum == "" && !legacy {
+		fmt.Printf("BAD header at offset 0: BookID '%v' header has no checksum, though its format version %v says it should.\n", h.BookID, h.FormatVersion)
+		return nil, false
+	}
+	if h.Checksum != "" {
+		sum, err := h.ComputeChecksum()
+		panicOn(err)
+		if sum != h.Checksum {
+			fmt.Printf("BAD header checksum at offset 0: BookID '%v' header has been altered.\n", h.BookID)
+			return nil, false
+		}
+	}
+
+	res = &verifyResult{
+		book: h,
+		head: h.chainAnchor(),
+	}
+	lastSeqno := -1
+	for {
+		e, err := r.Next()
+		beg := r.Offset()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Printf("BAD frame at offset %v (the frame after seqno %v): '%v'\n", beg, lastSeqno, err)
+			return res, false
+		}
+		if e.Checksum == "" {
+			if !legacy {
+				fmt.Printf("BAD checksum: first bad seqno %v at offset %v has no checksum, though the book's format version %v says it should.\n", e.Seqno, beg, h.FormatVersion)
+				return res, false
+			}
+			res.unchecked++
+		} else {
+			sum, err := e.ComputeChecksum()
+			panicOn(err)
+			if sum != e.Checksum {
+				fmt.Printf("BAD checksum: first bad seqno %v at offset %v.\n", e.Seqno, beg)
+				return res, false
+			}
+			res.checked++
+		}
+		if e.ImageHash != "" && len(e.ImageBy) == 0 {
+			_, err = res.book.blobs.get(e.ImageHash)
+			if err != nil {
+				fmt.Printf("BAD image blob for seqno %v at offset %v: %v\n", e.Seqno, beg, err)
+				return res, false
+			}
+		}
+		// e.Checksum, if present, was just verified; so trust it.
+		res.head, err = chainLink(res.head, e, true, legacy)
+		if err != nil {
+			fmt.Printf("BAD hash chain: first broken link at seqno %v at offset %v: %v\n", e.Seqno, beg, err)
+			return res, false
+		}
+		lastSeqno = e.Seqno
+		res.last = e
+		res.nelem++
+	}
+	return res, true
+}
+
+// verifyBook implements rbook -verify. Returns true if
+// the book at path passed.
+func (c *RbookConfig) verifyBook(path string) (ok bool) {
+	res, ok := verifyFrames(path)
+	if !ok {
+		return false
+	}
+	fmt.Printf("ok: '%v' (BookID %v): %v frames verified, hash chain intact; %v older frames had no checksum to verify.\n", path, res.book.BookID, res.checked, res.unchecked)
+	return true
+}
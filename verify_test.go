@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestVerifyChecksums(t *testing.T) {
+
+	cv.Convey("rbook -verify should pass an untouched book, and find the first frame whose content was edited after the fact", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-verify")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "verify.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		writeTestBook(path, 3)
+
+		cfg := &RbookConfig{}
+		cv.So(cfg.verifyBook(path), cv.ShouldBeTrue)
+
+		// tamper with the last command: x <- 1 becomes x <- 9.
+		// (json.Marshal has escaped the < for us).
+		by, err := ioutil.ReadFile(path)
+		panicOn(err)
+		i := bytes.LastIndex(by, []byte(`- 1"`))
+		cv.So(i, cv.ShouldBeGreaterThan, 0)
+		by[i+2] = '9'
+		panicOn(ioutil.WriteFile(path, by, 0660))
+
+		cv.So(cfg.verifyBook(path), cv.ShouldBeFalse)
+	})
+
+	cv.Convey("UnframeBinMsgpack should return NotBinarySlice on corrupt bytes, rather than panic", t, func() {
+		_, _, _, err := UnframeBinMsgpack([]byte{0x01, 0x02})
+		cv.So(err, cv.ShouldEqual, NotBinarySlice)
+	})
+}
@@ -0,0 +1,157 @@
+package main
+
+// Copyright (C) 2023 Jason E. Aten, Ph.D. All rights reserved.
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/glycerine/embedr"
+)
+
+// Interactive htmlwidgets: plotly, leaflet, DT, and the rest. At
+// the console, printing one would open it in a viewer, and rbook
+// would see nothing. So we register our own print method for
+// class htmlwidget, which writes the widget as one self-contained
+// html page by htmlwidgets::saveWidget(), javascript and data
+// inlined. After the command, takeWidgets() collects those pages
+// into Widget elements, kept whole in the book.
+//
+// The browser shows each in an iframe from /widget/<hash>, where
+// <hash> is the checksum of its page. The iframe is sandboxed,
+// scripts allowed but not the origin of rbook's own page, so a
+// widget cannot reach the book, or the websocket, or cookies.
+
+// widgetR sets up the capture in R. It is evaluated once. The
+// print method is registered when htmlwidgets is loaded, after
+// its own; or at once, if it already is.
+const widgetR = `local({
+  .rbook.widgets <<- character(0)
+  .rbook.printWidget <<- function(x, ...) {
+    cls <- class(x)[1L]
+    f <- tempfile("rbook-widget-", fileext=".html")
+    ok <- tryCatch({
+      htmlwidgets::saveWidget(x, f, selfcontained=TRUE, title=cls)
+      TRUE
+    }, error=function(e) {
+      message("rbook: could not save the ", cls, " widget: ", conditionMessage(e))
+      FALSE
+    })
+    if (ok) .rbook.widgets <<- c(.rbook.widgets, cls, f)
+    invisible(x)
+  }
+  reg <- function(...) registerS3method("print", "htmlwidget", .rbook.printWidget, envir=asNamespace("htmlwidgets"))
+  if (isNamespaceLoaded("htmlwidgets")) reg()
+  setHook(packageEvent("htmlwidgets", "onLoad"), reg)
+  invisible()
+})`
+
+// printedWidget is a widget that .rbook.printWidget saved.
+type printedWidget struct {
+	Class string // of the widget, as "plotly" or "leaflet".
+	Path  string // of the html page saveWidget() wrote.
+}
+
+// takeWidgets gives the widgets printed since it was last
+// called, in the order printed.
+func takeWidgets() (ws []printedWidget) {
+	got, err := embedr.EvalR_fullback(`local({w <- .rbook.widgets; .rbook.widgets <<- character(0); w})`)
+	if err != nil {
+		return nil
+	}
+	v := rStrings(got)
+	for i := 0; i+1 < len(v); i += 2 {
+		ws = append(ws, printedWidget{Class: v[i], Path: v[i+1]})
+	}
+	return
+}
+
+// widgetElem makes the Widget element numbered seqno for w, taking
+// its page from the temp file, which it then removes.
+func widgetElem(w printedWidget, tm time.Time, seqno int) (*HashRElem, error) {
+	by, err := ioutil.ReadFile(w.Path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the %v widget: '%v'", w.Class, err)
+	}
+	os.Remove(w.Path)
+	e := &HashRElem{
+		Typ:        Widget,
+		Tm:         tm,
+		Seqno:      seqno,
+		WidgetHTML: by,
+		WidgetHash: checksumOf(by),
+	}
+	e.WidgetJSON = prepWidgetMessage(w.Class, e.WidgetHash, seqno)
+	e.msg = []byte(e.WidgetJSON)
+	return e, nil
+}
+
+// prepWidgetMessage gives the message for a Widget element: the
+// page to show is at /widget/<hash>.
+func prepWidgetMessage(class, hash string, seqno int) string {
+	json := fmt.Sprintf(`{"seqno": %v, "widget":%q, "widgetClass":%q}`, seqno, hash, class)
+	lenPrefixedJson := fmt.Sprintf("%v:%v", len(json), json)
+	return lenPrefixedJson
+}
+
+// widgetByHash returns the Widget element whose page has checksum
+// hash; nil if there is none. Caller holds h.mut.
+func (h *HashRBook) widgetByHash(hash string) *HashRElem {
+	for i := len(h.elems) - 1; i >= 0; i-- {
+		e := h.elems[i]
+		if e.Typ == Widget && e.WidgetHash == hash {
+			return e
+		}
+	}
+	return nil
+}
+
+// widgetHTML returns the page of the Widget element e, reading it
+// back from the book file under -lazy. Caller holds h.mut.
+func (h *HashRBook) widgetHTML(e *HashRElem) ([]byte, error) {
+	if !e.lazyImage {
+		return e.WidgetHTML, nil
+	}
+	got, err := h.loadLazyFrame(e)
+	if err != nil {
+		return nil, err
+	}
+	return got.WidgetHTML, nil
+}
+
+// serveWidget answers /widget/<hash> with the page of that widget
+// in the live book b. The Content-Security-Policy sandbox holds
+// even if the page is opened outside of its iframe.
+func serveWidget(w http.ResponseWriter, r *http.Request, b *HashRBook) {
+	hash := strings.TrimPrefix(r.URL.Path, "/widget/")
+
+	b.mut.Lock()
+	e := b.widgetByHash(hash)
+	var by []byte
+	var err error
+	if e != nil {
+		by, err = b.widgetHTML(e)
+	}
+	b.mut.Unlock()
+	switch {
+	case e == nil:
+		http.Error(w, "no such widget", http.StatusNotFound)
+		return
+	case err != nil:
+		vv("could not load widget '%v': '%v'", hash, err)
+		http.Error(w, "widget not available", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "sandbox allow-scripts")
+	w.Header().Set("Access-Control-Allow-Private-Network", "true")
+	w.Header().Set("Etag", `"`+hash+`"`)
+	w.Header().Set("Cache-Control", "max-age=365000000, immutable")
+	http.ServeContent(w, r, "", e.Tm, bytes.NewReader(by))
+}
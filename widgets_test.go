@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func TestWidgetElem(t *testing.T) {
+
+	cv.Convey("a printed htmlwidget should be kept whole in the book as a Widget element, served sandboxed from /widget/<hash> even under -lazy, exported inline by -export-html, and kept by -dump-jsonl and -load-jsonl", t, func() {
+
+		dir, err := ioutil.TempDir("", "rbook-widget")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "widget.rbook")
+
+		cwd, err := os.Getwd()
+		panicOn(err)
+		panicOn(os.Chdir(dir))
+		defer os.Chdir(cwd)
+
+		page := []byte(`<!DOCTYPE html><html><head><title>plotly</title><script>var x = "a" < "b";</script></head><body><div class="plotly html-widget"></div></body></html>`)
+		saved := filepath.Join(dir, "rbook-widget-1.html")
+		panicOn(ioutil.WriteFile(saved, page, 0600))
+
+		t0 := time.Date(2023, 9, 12, 5, 0, 0, 0, Chicago)
+		h, appendFD, err := ReadBook("tester", "testhost", path)
+		panicOn(err)
+		msg, n := prepCommandMessage("plot_ly(x=1:3)", 0)
+		cmd := &HashRElem{Typ: Command, Tm: t0, CmdJSON: msg, BeginCommandLineNum: 1, NumCommandLines: n, msg: []byte(msg)}
+		w, err := widgetElem(printedWidget{Class: "plotly", Path: saved}, t0, 1)
+		panicOn(err)
+		cv.So(FileExists(saved), cv.ShouldBeFalse) // taken in.
+		cv.So(w.WidgetHash, cv.ShouldEqual, checksumOf(page))
+		cv.So(w.WidgetJSON, cv.ShouldEndWith, `{"seqno": 1, "widget":"`+w.WidgetHash+`", "widgetClass":"plotly"}`)
+		for _, e := range []*HashRElem{cmd, w} {
+			h.mut.Lock()
+			h.appendElem(e)
+			h.mut.Unlock()
+			by, err := e.SaveToSlice()
+			panicOn(err)
+			_, err = appendFD.Write(by)
+			panicOn(err)
+		}
+		appendFD.Close()
+
+		_, err = widgetElem(printedWidget{Class: "leaflet", Path: saved}, t0, 2)
+		cv.So(err, cv.ShouldNotBeNil)
+
+		lz, appendFD, err := ReadBookLazy("tester", "testhost", path)
+		panicOn(err)
+		defer appendFD.Close()
+		cv.So(lz.elems[1].Typ, cv.ShouldEqual, Widget)
+		cv.So(len(lz.elems[1].WidgetHTML), cv.ShouldEqual, 0)
+		d, err := decodeElem(lz.elems[1])
+		panicOn(err)
+		cv.So(d.Widget, cv.ShouldEqual, w.WidgetHash)
+		cv.So(d.WidgetClass, cv.ShouldEqual, "plotly")
+
+		get := func(hash string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			serveWidget(rec, httptest.NewRequest("GET", "/widget/"+hash, nil), lz)
+			return rec
+		}
+		rec := get(w.WidgetHash)
+		cv.So(rec.Code, cv.ShouldEqual, 200)
+		cv.So(rec.Header().Get("Content-Type"), cv.ShouldEqual, "text/html; charset=utf-8")
+		cv.So(rec.Header().Get("Content-Security-Policy"), cv.ShouldEqual, "sandbox allow-scripts")
+		cv.So(bytes.Equal(rec.Body.Bytes(), page), cv.ShouldBeTrue)
+		cv.So(get("nope").Code, cv.ShouldEqual, 404)
+
+		// the script form has only the command.
+		fd, err := ioutil.TempFile(dir, "dump")
+		panicOn(err)
+		cv.So((&RbookConfig{}).dumpBook(fd, path), cv.ShouldBeTrue)
+		panicOn(fd.Close())
+		cv.So(strings.Contains(string(mustReadFile(fd.Name())), "html"), cv.ShouldBeFalse)
+
+		var text bytes.Buffer
+		cv.So((&RbookConfig{}).textconvBook(&text, path), cv.ShouldBeTrue)
+		cv.So(text.String(), cv.ShouldContainSubstring, "\n[0001] widget plotly ")
+		cv.So(text.String(), cv.ShouldContainSubstring, "\n    "+w.WidgetHash+"\n")
+
+		// -grep finds a widget by its class.
+		var hits bytes.Buffer
+		cv.So((&RbookConfig{Grep: "^plotly$", GrepIn: []string{path}}).grepCmd(&hits), cv.ShouldBeTrue)
+		cv.So(hits.String(), cv.ShouldEndWith, "  widget: plotly\n")
+
+		cfg := &RbookConfig{Out: filepath.Join(dir, "out.html")}
+		cv.So(cfg.exportHTML(path), cv.ShouldBeTrue)
+		out := string(mustReadFile(cfg.Out))
+		cv.So(out, cv.ShouldContainSubstring, `<iframe class="Rwidget" title="plotly" sandbox="allow-scripts" srcdoc="&lt;!DOCTYPE html&gt;`)
+		cv.So(out, cv.ShouldContainSubstring, `var x = &#34;a&#34; &lt; &#34;b&#34;;`)
+
+		f, err := newElemFilter("", "", "", "widget")
+		panicOn(err)
+		cfg = &RbookConfig{Out: filepath.Join(dir, "widgets.rbook"), filter: f}
+		cv.So(cfg.extractBook(path), cv.ShouldBeTrue)
+		x, err := loadBookReadOnly(cfg.Out)
+		panicOn(err)
+		cv.So(len(x.elems), cv.ShouldEqual, 1)
+		cv.So(x.elems[0].Seqno, cv.ShouldEqual, 0)
+		cv.So(x.elems[0].WidgetJSON, cv.ShouldContainSubstring, `{"seqno": 0, "widget":`)
+		cv.So(bytes.Equal(x.elems[0].WidgetHTML, page), cv.ShouldBeTrue)
+
+		jsonl := filepath.Join(dir, "widget.jsonl")
+		cfg = &RbookConfig{Out: jsonl}
+		cv.So(cfg.dumpJSONL(path), cv.ShouldBeTrue)
+		cp := filepath.Join(dir, "copy.rbook")
+		cfg = &RbookConfig{Out: cp}
+		cv.So(cfg.loadJSONL(jsonl), cv.ShouldBeTrue)
+		cv.So(bytes.Equal(mustReadFile(cp), mustReadFile(path)), cv.ShouldBeTrue)
+	})
+}
@@ -45,6 +45,9 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait.
 	//pingPeriod = (pongWait * 9) / 10
 	pingPeriod = 30 * time.Second
+
+	// Batches a client may have waiting before the hub gives up on it.
+	clientQueue = 1024
 )
 
 var (
@@ -67,8 +70,9 @@ type Client struct {
 	// The websocket connection.
 	conn *websocket.Conn
 
-	// Buffered channel of outbound messages.
-	send chan []byte
+	// Buffered channel of outbound messages, in batches; at most
+	// clientQueue of them. See Hub.sendTo.
+	send chan [][]byte
 
 	// coordinate readPump and writePump goro: notice and shutdown if
 	// the other dies.
@@ -101,7 +105,7 @@ func (c *Client) readPump() {
 		c.setDone()
 	}()
 	for {
-		_, _, err := c.conn.ReadMessage()
+		_, msg, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway) {
 				// 2022/11/16 12:42:27 An error happened when reading from the
@@ -117,6 +121,9 @@ func (c *Client) readPump() {
 		if c.isDone() {
 			return // writePump has shut down, so we should too.
 		}
+		if before, ok := parsePageRequest(msg); ok {
+			c.hub.page <- &pageRequest{client: c, before: before}
+		}
 	}
 }
 
@@ -136,7 +143,7 @@ func (c *Client) writePump() {
 	}()
 	for {
 		select {
-		case message, ok := <-c.send:
+		case batch, ok := <-c.send:
 			if !ok {
 				vvlog("The hub closed the channel.")
 				c.write(websocket.CloseMessage, []byte{})
@@ -148,12 +155,22 @@ func (c *Client) writePump() {
 			if err != nil {
 				return
 			}
-			w.Write(message)
-
+			first := true
 			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write(newline)
-				w.Write(<-c.send)
+			for i := 0; i <= n; i++ {
+				if i > 0 {
+					batch, ok = <-c.send
+					if !ok {
+						break
+					}
+				}
+				for _, message := range batch {
+					if !first {
+						w.Write(newline)
+					}
+					first = false
+					w.Write(message)
+				}
 			}
 
 			if err := w.Close(); err != nil {
@@ -189,7 +206,7 @@ func NewClient(hub *Hub, conn *websocket.Conn) *Client {
 	return &Client{
 		hub:    hub,
 		conn:   conn,
-		send:   make(chan []byte, 8192),
+		send:   make(chan [][]byte, clientQueue),
 		doneCh: make(chan struct{}),
 	}
 }
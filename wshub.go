@@ -4,7 +4,6 @@ package main
 
 import (
 	"fmt"
-	"time"
 
 	"github.com/glycerine/embedr"
 )
@@ -51,16 +50,137 @@ type Hub struct {
 	// Unregister requests from clients.
 	unregister chan *Client
 
+	// Requests from clients for older pages of the book.
+	page chan *pageRequest
+
+	// Pages read from the book file, ready to send; see readPageFor.
+	ready chan *pageReply
+
+	// Clients whose first page is still being read, with the
+	// broadcasts that came meanwhile, to send after it.
+	waiting map[*Client][]*HashRElem
+
 	book *HashRBook
+
+	// how many of the most recent elements to send a newly
+	// registered client, and per page after; 0 means all.
+	replayN int
 }
 
-func newHub(book *HashRBook) *Hub {
+func newHub(book *HashRBook, replayN int) *Hub {
 	return &Hub{
 		book:       book,
 		broadcast:  make(chan *HashRElem),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		page:       make(chan *pageRequest),
+		ready:      make(chan *pageReply),
+		waiting:    make(map[*Client][]*HashRElem),
 		clients:    make(map[*Client]bool),
+		replayN:    replayN,
+	}
+}
+
+// sendTo queues msgs, as one batch, for the writePump of client.
+// The hub is a single goroutine, so it must never wait on one
+// client: if client already has clientQueue batches waiting, it
+// cannot keep up, and we drop it and return false. Its browser
+// reconnects, and gets the most recent page afresh.
+func (h *Hub) sendTo(client *Client, msgs ...[]byte) (ok bool) {
+	select {
+	case client.send <- msgs:
+		return true
+	default:
+		vvlog("dropping websocket client, %v batches behind", len(client.send))
+		h.drop(client)
+		return false
+	}
+}
+
+// drop forgets client, and closes its send channel, so its
+// writePump closes the websocket.
+func (h *Hub) drop(client *Client) {
+	delete(h.clients, client)
+	delete(h.waiting, client)
+	close(client.send)
+}
+
+// pageReply is a page of the book read for client.
+type pageReply struct {
+	client *Client
+	msgs   [][]byte
+
+	// first is set for the page a newly registered client
+	// starts with; newest is the seqno of its last element.
+	first  bool
+	newest int
+}
+
+// readPageFor reads the page at sp for client, off the hub
+// goroutine, and hands it back to the hub to send. The hub
+// itself never reads the book file, so a big replay to one
+// client does not hold up the broadcasts to the others, nor
+// archiveElem.
+func (h *Hub) readPageFor(client *Client, sp *pageSpan, first bool) {
+	pg := h.book.readPage(sp)
+	var msgs [][]byte
+	if first {
+		h.book.mut.Lock()
+		initMsg := prepInitMessage(h.book, pg)
+		h.book.mut.Unlock()
+		msgs = append([][]byte{[]byte(initMsg)}, pg.msgs...)
+	} else {
+		msgs = append([][]byte{[]byte(prepPageStartMessage(pg))}, pg.msgs...)
+		msgs = append(msgs, []byte(prepPageEndMessage()))
+	}
+	h.ready <- &pageReply{client: client, msgs: msgs, first: first, newest: sp.newest()}
+}
+
+// addClient registers client, and starts reading the most recent
+// page of the book for it. Broadcasts wait in h.waiting until
+// that page is sent.
+func (h *Hub) addClient(client *Client) {
+	h.clients[client] = true
+	h.waiting[client] = nil
+	go h.readPageFor(client, h.book.pageEntries(-1, h.replayN), true)
+}
+
+// sendPage sends client the page read for it. After a first page,
+// it sends the broadcasts that came while it was read, less those
+// already in the page.
+func (h *Hub) sendPage(r *pageReply) {
+	if !h.clients[r.client] {
+		return // gone already.
+	}
+	msgs := r.msgs
+	if r.first {
+		for _, e := range h.waiting[r.client] {
+			if e.Seqno > r.newest {
+				msgs = append(msgs, e.msg)
+			}
+		}
+		delete(h.waiting, r.client)
+	}
+	if h.sendTo(r.client, msgs...) && r.first {
+		vvlog("sent init msg to new client, with %v updates", len(msgs)-1)
+	}
+}
+
+// broadcastElem sends e to every client, or holds it for those
+// still waiting on their first page.
+func (h *Hub) broadcastElem(e *HashRElem) {
+	for client := range h.clients {
+		held, ok := h.waiting[client]
+		if !ok {
+			h.sendTo(client, e.msg)
+			continue
+		}
+		if len(held) >= clientQueue {
+			vvlog("dropping websocket client, %v broadcasts behind its first page", len(held))
+			h.drop(client)
+			continue
+		}
+		h.waiting[client] = append(held, e)
 	}
 }
 
@@ -83,7 +203,6 @@ func (h *Hub) run() {
 			vvlog(msg)
 		}
 	}()
-top:
 	for {
 		select {
 		case client := <-h.register:
@@ -96,57 +215,27 @@ top:
 			vvlog("websocket client (count %v) remote:%v", ncli, cc.RemoteAddr().String())
 			//embedr.SetCustomPrompt(fmt.Sprintf("[wsclient: %v] >", ncli))
 
-			// give the new client all the book, starting with the init message
-			h.book.mut.Lock()
-			select {
-			case client.send <- []byte(prepInitMessage(h.book)):
-				vvlog("sent init msg to new client, have %v updates to follow", len(h.book.elems))
-			case <-time.After(300 * time.Second):
-				//default:
-				vvlog("client.send could not proceed after 300 seconds.")
-				close(client.send)
-				delete(h.clients, client)
-				h.book.mut.Unlock()
-				continue top // don't try to send below on closed channel!
-			}
-			for i, e := range h.book.elems {
-				_ = i
-				//vv("updating new client with seqno %v", e.Seqno)
-				select {
-				//huh. got after long inactivity, panic: send on closed channel
-				// also got when old client coming back to new server.
-				// Saw panic: send on closed channel when browser went
-				// offline but server was still up.
-				case client.send <- e.msg:
-				case <-time.After(300 * time.Second):
-					//default:
-					// this was the closing too early culprit!
-					vvlog("closing client.send after i=%v out of %v", i, len(h.book.elems))
-					close(client.send)
-					delete(h.clients, client)
-					h.book.mut.Unlock()
-					continue top // don't try to send below on closed channel!
-				}
+			// give the new client the init message and the most
+			// recent page of the book. It asks for older pages
+			// as the user scrolls up.
+			h.addClient(client)
+
+		case req := <-h.page:
+			if !h.clients[req.client] {
+				continue // gone already.
 			}
-			h.book.mut.Unlock()
+			go h.readPageFor(req.client, h.book.pageEntries(req.before, h.replayN), false)
+
+		case r := <-h.ready:
+			h.sendPage(r)
 
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
+				h.drop(client)
 				vvlog("closed client.send after unregister")
 			}
 		case message := <-h.broadcast:
-			for client := range h.clients {
-				select {
-				case client.send <- message.msg:
-				case <-time.After(300 * time.Second):
-					//default:
-					close(client.send)
-					delete(h.clients, client)
-					vvlog("closed client.send after broadcast failure")
-				}
-			}
+			h.broadcastElem(message)
 		}
 	}
 }